@@ -0,0 +1,68 @@
+// Package billing 会計金額の計算（消費税・割り勘など）
+package billing
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidPartCount = errors.New("分割数が不正です")
+	ErrInvalidRoundUnit = errors.New("端数の単位が不正です")
+	// 0円の請求は支払えず分割会計が完了しなくなるため作成しない
+	ErrTooManyParts = errors.New("請求額に対して分割数が多すぎます（0円の請求ができてしまいます）")
+	ErrEmptyPart    = errors.New("金額が0円の請求があります")
+)
+
+// AmountMismatchError 分割後の合計が元の請求額と一致しない
+type AmountMismatchError struct {
+	Total int
+	Sum   int
+}
+
+func (e *AmountMismatchError) Error() string {
+	return fmt.Sprintf("分割後の合計（¥%d）が請求額（¥%d）と一致しません", e.Sum, e.Total)
+}
+
+// SplitEvenly 請求額をn人で均等に分割する
+// 2人目以降はroundUnit（1・10・100円）単位で切り捨てた金額とし、
+// 端数は1人目（幹事）が負担する
+// 2人目以降が0円になる人数の場合は ErrTooManyParts を返す
+func SplitEvenly(total, n, roundUnit int) ([]int, error) {
+	if n < 1 {
+		return nil, ErrInvalidPartCount
+	}
+	if roundUnit != 1 && roundUnit != 10 && roundUnit != 100 {
+		return nil, ErrInvalidRoundUnit
+	}
+
+	share := total / n / roundUnit * roundUnit
+	if n > 1 && share == 0 {
+		return nil, ErrTooManyParts
+	}
+	amounts := make([]int, n)
+	amounts[0] = total - share*(n-1)
+	for i := 1; i < n; i++ {
+		amounts[i] = share
+	}
+	return amounts, nil
+}
+
+// ValidateAmounts 分割した金額の合計が請求額と一致するか検証する
+// 0円以下の請求がある場合は ErrEmptyPart を返す
+func ValidateAmounts(total int, amounts []int) error {
+	if len(amounts) == 0 {
+		return ErrInvalidPartCount
+	}
+	sum := 0
+	for _, amount := range amounts {
+		if amount <= 0 {
+			return ErrEmptyPart
+		}
+		sum += amount
+	}
+	if sum != total {
+		return &AmountMismatchError{Total: total, Sum: sum}
+	}
+	return nil
+}
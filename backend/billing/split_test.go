@@ -0,0 +1,78 @@
+package billing
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitEvenly(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int
+		n         int
+		roundUnit int
+		want      []int
+		wantErr   error
+	}{
+		{name: "割り切れる", total: 3000, n: 3, roundUnit: 1, want: []int{1000, 1000, 1000}},
+		{name: "端数は1人目", total: 1000, n: 3, roundUnit: 1, want: []int{334, 333, 333}},
+		{name: "10円単位", total: 10000, n: 3, roundUnit: 10, want: []int{3340, 3330, 3330}},
+		{name: "100円単位", total: 10000, n: 3, roundUnit: 100, want: []int{3400, 3300, 3300}},
+		{name: "1人", total: 1234, n: 1, roundUnit: 100, want: []int{1234}},
+		{name: "1人0円", total: 0, n: 1, roundUnit: 1, want: []int{0}},
+		{name: "人数0", total: 1000, n: 0, roundUnit: 1, wantErr: ErrInvalidPartCount},
+		{name: "不正な端数単位", total: 1000, n: 2, roundUnit: 50, wantErr: ErrInvalidRoundUnit},
+		{name: "0円の請求ができる人数", total: 5, n: 6, roundUnit: 1, wantErr: ErrTooManyParts},
+		{name: "端数単位で0円になる", total: 150, n: 2, roundUnit: 100, wantErr: ErrTooManyParts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitEvenly(tt.total, tt.n, tt.roundUnit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("amounts = %v, want %v", got, tt.want)
+			}
+			// 分割した金額はそのまま請求として作成できる
+			if err == nil && tt.total > 0 {
+				if err := ValidateAmounts(tt.total, got); err != nil {
+					t.Errorf("ValidateAmounts(%d, %v) = %v", tt.total, got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateAmounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		amounts  []int
+		wantErr  error
+		mismatch *AmountMismatchError
+	}{
+		{name: "一致", total: 1000, amounts: []int{600, 400}},
+		{name: "請求なし", total: 1000, amounts: nil, wantErr: ErrInvalidPartCount},
+		{name: "0円の請求", total: 1000, amounts: []int{1000, 0}, wantErr: ErrEmptyPart},
+		{name: "マイナスの請求", total: 1000, amounts: []int{1100, -100}, wantErr: ErrEmptyPart},
+		{name: "不足", total: 1000, amounts: []int{500, 400}, mismatch: &AmountMismatchError{Total: 1000, Sum: 900}},
+		{name: "超過", total: 1000, amounts: []int{500, 600}, mismatch: &AmountMismatchError{Total: 1000, Sum: 1100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAmounts(tt.total, tt.amounts)
+			if tt.mismatch != nil {
+				var mismatch *AmountMismatchError
+				if !errors.As(err, &mismatch) || *mismatch != *tt.mismatch {
+					t.Fatalf("err = %v, want %v", err, tt.mismatch)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
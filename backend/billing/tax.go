@@ -0,0 +1,113 @@
+package billing
+
+import "sort"
+
+// 税率（%）
+const (
+	StandardTaxRate = 10
+	ReducedTaxRate  = 8
+)
+
+// 商品の税区分
+const (
+	TaxCategoryStandard = "standard" // 標準税率（酒類など）
+	TaxCategoryReduced  = "reduced"  // 軽減税率対象（飲食料品）
+)
+
+// 飲食の区分
+const (
+	DiningEatIn   = "eat_in"  // 店内飲食
+	DiningTakeout = "takeout" // 持ち帰り
+)
+
+// IsValidTaxCategory 有効な税区分か判定
+func IsValidTaxCategory(category string) bool {
+	return category == TaxCategoryStandard || category == TaxCategoryReduced
+}
+
+// IsValidDiningOption 有効な飲食の区分か判定
+func IsValidDiningOption(option string) bool {
+	return option == DiningEatIn || option == DiningTakeout
+}
+
+// TaxRate 税区分と飲食の区分から税率を決める
+// 店内飲食は外食にあたるため、飲食料品でも標準税率になる
+func TaxRate(category, dining string) int {
+	if category == TaxCategoryReduced && dining == DiningTakeout {
+		return ReducedTaxRate
+	}
+	return StandardTaxRate
+}
+
+// TaxLine 税計算の対象となる明細
+type TaxLine struct {
+	Rate             int  // 税率（%）
+	Amount           int  // 明細の金額
+	PriceIncludesTax bool // Amount が税込か
+}
+
+// RateTotal 税率ごとの集計
+type RateTotal struct {
+	Rate     int `json:"rate"`
+	Subtotal int `json:"subtotal"` // 税抜金額
+	Tax      int `json:"tax"`      // 消費税額
+	Total    int `json:"total"`    // 税込金額
+}
+
+// TaxSummary 請求全体の税額計算結果
+type TaxSummary struct {
+	PriceIncludesTax bool        `json:"price_includes_tax"` // すべての明細が税込か
+	Rates            []RateTotal `json:"rates"`              // 税率の高い順
+	Subtotal         int         `json:"subtotal"`
+	Tax              int         `json:"tax"`
+	Total            int         `json:"total"`
+}
+
+// CalculateTax 明細から税額を計算する
+// 適格請求書の端数処理ルールに従い、税率ごとに金額を合計してから
+// 1回だけ端数処理（1円未満切り捨て）する
+// 税込・税抜の明細が混在する場合（請求の途中で設定を変えた場合）は、それぞれで端数処理する
+func CalculateTax(lines []TaxLine) TaxSummary {
+	type key struct {
+		rate      int
+		inclusive bool
+	}
+	amounts := make(map[key]int)
+	summary := TaxSummary{PriceIncludesTax: true, Rates: []RateTotal{}}
+	for _, line := range lines {
+		amounts[key{line.Rate, line.PriceIncludesTax}] += line.Amount
+		if !line.PriceIncludesTax {
+			summary.PriceIncludesTax = false
+		}
+	}
+
+	byRate := make(map[int]*RateTotal)
+	for k, amount := range amounts {
+		rt, ok := byRate[k.rate]
+		if !ok {
+			rt = &RateTotal{Rate: k.rate}
+			byRate[k.rate] = rt
+		}
+		if k.inclusive {
+			tax := amount * k.rate / (100 + k.rate)
+			rt.Subtotal += amount - tax
+			rt.Tax += tax
+			rt.Total += amount
+		} else {
+			tax := amount * k.rate / 100
+			rt.Subtotal += amount
+			rt.Tax += tax
+			rt.Total += amount + tax
+		}
+	}
+	for _, rt := range byRate {
+		summary.Rates = append(summary.Rates, *rt)
+		summary.Subtotal += rt.Subtotal
+		summary.Tax += rt.Tax
+		summary.Total += rt.Total
+	}
+	sort.Slice(summary.Rates, func(i, j int) bool {
+		return summary.Rates[i].Rate > summary.Rates[j].Rate
+	})
+	return summary
+}
@@ -0,0 +1,385 @@
+// Package config 設定ファイルと環境変数からのアプリケーションの設定の読み込み
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 実行環境
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+// DefaultFile 設定ファイルを指定しない場合に、作業ディレクトリにあれば読み込むファイル
+const DefaultFile = "config.json"
+
+// 本番環境で求める署名鍵の最小の長さ（バイト）
+const minSecretLength = 32
+
+// Config アプリケーションの設定
+type Config struct {
+	Env        string           `json:"env"` // development または production
+	Server     ServerConfig     `json:"server"`
+	Database   DatabaseConfig   `json:"database"`
+	Storage    StorageConfig    `json:"storage"`
+	Session    SessionConfig    `json:"session"`
+	Tokens     TokensConfig     `json:"tokens"`
+	Encryption EncryptionConfig `json:"encryption"`
+	Password   PasswordConfig   `json:"password"`
+	Mail       MailConfig       `json:"mail"`
+}
+
+// ServerConfig HTTPサーバー
+type ServerConfig struct {
+	Addr           string   `json:"addr"`            // 待ち受けるアドレス（例: ":8080"）
+	BaseURL        string   `json:"base_url"`        // メール内のリンク・QRコードのURLの基準（開発環境の既定 http://localhost:8080）
+	AllowedOrigins []string `json:"allowed_origins"` // CORSで許可するオリジン（開発環境で空の場合はすべて許可）
+}
+
+// DatabaseConfig データベース
+type DatabaseConfig struct {
+	Path string `json:"path"` // SQLiteのファイル
+}
+
+// StorageConfig ファイルの保存先
+type StorageConfig struct {
+	UploadDir string `json:"upload_dir"` // 商品画像のアップロード先（/uploads で公開する）
+	StaticDir string `json:"static_dir"` // 静的ファイル（/static で公開する）
+}
+
+// SessionConfig ログインのセッション（Cookie）
+type SessionConfig struct {
+	Secret   string   `json:"secret"`    // Cookieの署名鍵（本番環境では必須）
+	MaxAge   Duration `json:"max_age"`   // セッションの有効期間（例: "1h"）
+	Secure   bool     `json:"secure"`    // HTTPSのみでCookieを送る（本番環境では既定で true）
+	SameSite string   `json:"same_site"` // lax, strict, none
+}
+
+// TokensConfig 署名付きトークンの鍵
+type TokensConfig struct {
+	TableSecret   string `json:"table_secret"`   // テーブルのQRコードのトークン（本番環境では必須）
+	AccountSecret string `json:"account_secret"` // パスワード再設定・メールアドレス確認のトークン（本番環境では必須）
+}
+
+// EncryptionConfig 保存する秘密情報（OpenAI APIキー）の暗号化
+type EncryptionConfig struct {
+	Keys       string `json:"keys"`        // 「バージョン:Base64の32バイトの鍵」のカンマ区切り（本番環境では必須）
+	KeyVersion int    `json:"key_version"` // 新しく暗号化する値に使う鍵のバージョン（0の場合は最新）
+}
+
+// PasswordConfig パスワードの条件
+type PasswordConfig struct {
+	MinLength     int  `json:"min_length"`
+	RequireSymbol bool `json:"require_symbol"`
+}
+
+// MailConfig メールの送信（SMTPHost が空の場合は Dir に .eml ファイルとして保存し、Dir も空ならログに出力する）
+type MailConfig struct {
+	From         string `json:"from"`
+	Dir          string `json:"dir"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPUsername string `json:"smtp_username"`
+	SMTPPassword string `json:"smtp_password"`
+}
+
+// Duration "1h" などの文字列で指定する時間
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("時間は \"1h\" のような文字列で指定してください")
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Defaults 実行環境ごとの既定の設定（本番環境ではCookieをHTTPSのみにする）
+func Defaults(env string) Config {
+	cfg := Config{
+		Env: env,
+		Server: ServerConfig{
+			Addr: ":8080",
+		},
+		Database: DatabaseConfig{
+			Path: "users.db",
+		},
+		Storage: StorageConfig{
+			UploadDir: "./uploads",
+			StaticDir: "./static",
+		},
+		Session: SessionConfig{
+			MaxAge:   Duration(time.Hour),
+			Secure:   env == EnvProduction,
+			SameSite: "lax",
+		},
+		Password: PasswordConfig{
+			MinLength: 8,
+		},
+		Mail: MailConfig{
+			From:     "no-reply@orderbase.local",
+			SMTPPort: 587,
+		},
+	}
+	// 開発環境はローカルのサーバーを指す（本番環境は公開するURLを設定する）
+	if env == EnvDevelopment {
+		cfg.Server.BaseURL = "http://localhost:8080"
+	}
+	return cfg
+}
+
+// Load 設定を読み込んで検証する
+// 実行環境の既定値に、設定ファイル（path、空の場合は config.json があれば）と環境変数の順で上書きする
+func Load(path string) (*Config, error) {
+	var file []byte
+	if path == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			path = DefaultFile
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("設定ファイルを読み込めません: %w", err)
+		}
+		file = data
+	}
+
+	// 実行環境によって既定値が変わるため、先に実行環境を決める
+	env := EnvDevelopment
+	if file != nil {
+		var head struct {
+			Env string `json:"env"`
+		}
+		if err := json.Unmarshal(file, &head); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: %w", path, err)
+		}
+		if head.Env != "" {
+			env = head.Env
+		}
+	}
+	if value := os.Getenv("ORDERBASE_ENV"); value != "" {
+		env = value
+	}
+
+	cfg := Defaults(env)
+	if file != nil {
+		decoder := json.NewDecoder(bytes.NewReader(file))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("設定ファイル %s: %w", path, err)
+		}
+	}
+	cfg.Env = env
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyEnv 環境変数で設定を上書き
+func (c *Config) applyEnv() error {
+	var errs []error
+	setString := func(name string, dst *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = value
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s が数値ではありません: %s", name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	setBool := func(name string, dst *bool) {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s は true または false で指定してください: %s", name, value))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	setString("ADDR", &c.Server.Addr)
+	if port, ok := os.LookupEnv("PORT"); ok {
+		c.Server.Addr = ":" + port
+	}
+	setString("APP_BASE_URL", &c.Server.BaseURL)
+	if value, ok := os.LookupEnv("ALLOWED_ORIGINS"); ok {
+		c.Server.AllowedOrigins = splitList(value)
+	}
+	setString("DB_PATH", &c.Database.Path)
+	setString("UPLOAD_DIR", &c.Storage.UploadDir)
+	setString("STATIC_DIR", &c.Storage.StaticDir)
+
+	setString("SESSION_SECRET", &c.Session.Secret)
+	if value, ok := os.LookupEnv("SESSION_MAX_AGE"); ok {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("SESSION_MAX_AGE は \"1h\" のような形式で指定してください: %s", value))
+		} else {
+			c.Session.MaxAge = Duration(d)
+		}
+	}
+	setBool("COOKIE_SECURE", &c.Session.Secure)
+	setString("COOKIE_SAME_SITE", &c.Session.SameSite)
+
+	setString("TABLE_TOKEN_SECRET", &c.Tokens.TableSecret)
+	setString("ACCOUNT_TOKEN_SECRET", &c.Tokens.AccountSecret)
+	setString("SECRET_KEYS", &c.Encryption.Keys)
+	setInt("SECRET_KEY_VERSION", &c.Encryption.KeyVersion)
+
+	setInt("PASSWORD_MIN_LENGTH", &c.Password.MinLength)
+	setBool("PASSWORD_REQUIRE_SYMBOL", &c.Password.RequireSymbol)
+
+	setString("MAIL_FROM", &c.Mail.From)
+	setString("MAIL_DIR", &c.Mail.Dir)
+	setString("SMTP_HOST", &c.Mail.SMTPHost)
+	setInt("SMTP_PORT", &c.Mail.SMTPPort)
+	setString("SMTP_USERNAME", &c.Mail.SMTPUsername)
+	setString("SMTP_PASSWORD", &c.Mail.SMTPPassword)
+
+	return errors.Join(errs...)
+}
+
+// splitList カンマ区切りの値を分割（空の要素は除く）
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// IsProduction 本番環境か
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// SameSiteMode Cookieの SameSite 属性
+func (c *Config) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.Session.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Validate 設定を検証（問題をすべてまとめて返す）
+// 本番環境では署名鍵・暗号化の鍵・許可するオリジン・ベースURLの設定とHTTPSのみのCookieを必須にする
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		fail("env は %s または %s で指定してください: %s", EnvDevelopment, EnvProduction, c.Env)
+	}
+	if c.Server.Addr == "" {
+		fail("server.addr が空です")
+	}
+	if c.Server.BaseURL != "" {
+		if u, err := url.Parse(c.Server.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("server.base_url は \"https://example.com\" のような形式で指定してください: %s", c.Server.BaseURL)
+		}
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if origin == "*" {
+			fail("server.allowed_origins に * は指定できません（Cookieを使うため、オリジンを列挙してください）")
+		} else if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+			fail("server.allowed_origins のオリジンが不正です: %s", origin)
+		}
+	}
+	if c.Database.Path == "" {
+		fail("database.path が空です")
+	}
+	if c.Storage.UploadDir == "" {
+		fail("storage.upload_dir が空です")
+	}
+	if c.Storage.StaticDir == "" {
+		fail("storage.static_dir が空です")
+	}
+	if c.Session.MaxAge <= 0 {
+		fail("session.max_age は正の時間で指定してください")
+	}
+	switch strings.ToLower(c.Session.SameSite) {
+	case "lax", "strict":
+	case "none":
+		if !c.Session.Secure {
+			fail("session.same_site を none にする場合は session.secure を true にしてください")
+		}
+	default:
+		fail("session.same_site は lax, strict, none のいずれかで指定してください: %s", c.Session.SameSite)
+	}
+	if c.Password.MinLength < 1 {
+		fail("password.min_length は1以上にしてください")
+	}
+	if c.Mail.SMTPHost != "" && (c.Mail.SMTPPort <= 0 || c.Mail.SMTPPort > 65535) {
+		fail("mail.smtp_port が不正です: %d", c.Mail.SMTPPort)
+	}
+	if c.Encryption.KeyVersion < 0 {
+		fail("encryption.key_version は0以上にしてください")
+	}
+
+	if c.IsProduction() {
+		requireSecret := func(name, value string) {
+			if value == "" {
+				fail("%s が設定されていません（本番環境では必須）", name)
+			} else if len(value) < minSecretLength {
+				fail("%s は%dバイト以上にしてください", name, minSecretLength)
+			}
+		}
+		requireSecret("session.secret (SESSION_SECRET)", c.Session.Secret)
+		requireSecret("tokens.table_secret (TABLE_TOKEN_SECRET)", c.Tokens.TableSecret)
+		requireSecret("tokens.account_secret (ACCOUNT_TOKEN_SECRET)", c.Tokens.AccountSecret)
+		if c.Encryption.Keys == "" {
+			fail("encryption.keys (SECRET_KEYS) が設定されていません（本番環境では必須）")
+		}
+		if len(c.Server.AllowedOrigins) == 0 {
+			fail("server.allowed_origins (ALLOWED_ORIGINS) が設定されていません（本番環境では必須）")
+		}
+		// メール内のリンク・QRコードのURLをリクエストのホストから作らないため
+		if c.Server.BaseURL == "" {
+			fail("server.base_url (APP_BASE_URL) が設定されていません（本番環境では必須）")
+		}
+		if !c.Session.Secure {
+			fail("本番環境では session.secure (COOKIE_SECURE) を true にしてください")
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,151 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// イベント種別
+const (
+	OrderCreated       = "order-created"
+	OrderStatusChanged = "order-status-changed"
+	TableChanged       = "table-changed"
+	PrintJobChanged    = "print-job-changed"
+	StockChanged       = "stock-changed"
+
+	// Resync 再接続時に取りこぼしたイベントを再送できないことを示す（クライアントは画面を読み込み直す）
+	Resync = "resync"
+)
+
+// 再接続時のリプレイ用に店舗ごとに保持するイベント数
+const historySize = 256
+
+// 購読者ごとのバッファ（溢れた購読者は切断し、Last-Event-IDで再接続させる）
+const subscriberBuffer = 64
+
+// Event ハブから配信されるイベント
+type Event struct {
+	ID        uint64      `json:"id"`
+	StoreID   uint        `json:"store_id"` // イベントが発生した店舗（購読者には同じ店舗のイベントのみ配信する）
+	Type      string      `json:"type"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// storeHistory 店舗ごとのリプレイ用の履歴
+type storeHistory struct {
+	events    []Event
+	evictedID uint64 // 履歴から押し出された最後のイベントID（これ以前は再送できない）
+}
+
+// Hub プロセス内のPub/Subハブ
+type Hub struct {
+	mu          sync.Mutex
+	nextID      uint64
+	histories   map[uint]*storeHistory // 店舗IDごと（他店舗のイベントで履歴が押し出されないように分ける）
+	subscribers map[chan Event]uint    // 購読者ごとの店舗ID
+}
+
+// NewHub ハブを作成
+func NewHub() *Hub {
+	return &Hub{
+		histories:   make(map[uint]*storeHistory),
+		subscribers: make(map[chan Event]uint),
+	}
+}
+
+// Publish イベントを同じ店舗の購読者に配信
+func (h *Hub) Publish(storeID uint, eventType string, data interface{}) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	event := Event{
+		ID:        h.nextID,
+		StoreID:   storeID,
+		Type:      eventType,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+
+	history := h.histories[storeID]
+	if history == nil {
+		history = &storeHistory{}
+		h.histories[storeID] = history
+	}
+	history.events = append(history.events, event)
+	if overflow := len(history.events) - historySize; overflow > 0 {
+		history.evictedID = history.events[overflow-1].ID
+		history.events = append([]Event(nil), history.events[overflow:]...)
+	}
+
+	for ch, subscriberStore := range h.subscribers {
+		if subscriberStore != storeID {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+			// 受信が追いつかない購読者は切断
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return event
+}
+
+// Subscribe 店舗のイベントの購読を開始
+// lastEventIDより後のイベントをreplayとして返す。取りこぼしを再送できない場合
+// （履歴から押し出された・サーバー再起動で採番が戻った）はresyncイベントだけを返す
+func (h *Hub) Subscribe(storeID uint, lastEventID uint64) (<-chan Event, []Event, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []Event
+	if lastEventID > 0 {
+		history := h.histories[storeID]
+		switch {
+		case lastEventID > h.nextID || (history != nil && lastEventID < history.evictedID):
+			replay = []Event{{
+				ID:        h.nextID,
+				StoreID:   storeID,
+				Type:      Resync,
+				CreatedAt: time.Now(),
+			}}
+		case history != nil:
+			for _, event := range history.events {
+				if event.ID > lastEventID {
+					replay = append(replay, event)
+				}
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	h.subscribers[ch] = storeID
+
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, replay, unsubscribe
+}
+
+// Default アプリケーション全体で共有するハブ
+var Default = NewHub()
+
+// Publish デフォルトハブにイベントを配信
+func Publish(storeID uint, eventType string, data interface{}) Event {
+	return Default.Publish(storeID, eventType, data)
+}
+
+// Subscribe デフォルトハブを購読
+func Subscribe(storeID uint, lastEventID uint64) (<-chan Event, []Event, func()) {
+	return Default.Subscribe(storeID, lastEventID)
+}
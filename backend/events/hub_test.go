@@ -0,0 +1,71 @@
+package events
+
+import "testing"
+
+func eventIDs(events []Event) []uint64 {
+	ids := make([]uint64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func TestSubscribeReplaysOwnStoreEvents(t *testing.T) {
+	hub := NewHub()
+	first := hub.Publish(1, OrderCreated, nil)
+	hub.Publish(2, OrderCreated, nil)
+	second := hub.Publish(1, OrderStatusChanged, nil)
+
+	_, replay, unsubscribe := hub.Subscribe(1, first.ID)
+	defer unsubscribe()
+
+	if len(replay) != 1 || replay[0].ID != second.ID {
+		t.Fatalf("replay = %v, want [%d]", eventIDs(replay), second.ID)
+	}
+}
+
+func TestSubscribeKeepsHistoryPerStore(t *testing.T) {
+	hub := NewHub()
+	last := hub.Publish(1, OrderCreated, nil)
+	missed := hub.Publish(1, OrderStatusChanged, nil)
+
+	// 他店舗のイベントが多くても店舗1の履歴は押し出されない
+	for i := 0; i < historySize*2; i++ {
+		hub.Publish(2, StockChanged, nil)
+	}
+
+	_, replay, unsubscribe := hub.Subscribe(1, last.ID)
+	defer unsubscribe()
+
+	if len(replay) != 1 || replay[0].ID != missed.ID {
+		t.Fatalf("replay = %v, want [%d]", eventIDs(replay), missed.ID)
+	}
+}
+
+func TestSubscribeResyncsWhenHistoryEvicted(t *testing.T) {
+	hub := NewHub()
+	last := hub.Publish(1, OrderCreated, nil)
+	for i := 0; i < historySize+1; i++ {
+		hub.Publish(1, StockChanged, nil)
+	}
+
+	_, replay, unsubscribe := hub.Subscribe(1, last.ID)
+	defer unsubscribe()
+
+	if len(replay) != 1 || replay[0].Type != Resync || replay[0].ID != hub.nextID {
+		t.Fatalf("replay = %+v, want a single resync event with id %d", replay, hub.nextID)
+	}
+}
+
+func TestSubscribeResyncsAfterRestart(t *testing.T) {
+	hub := NewHub()
+	hub.Publish(1, OrderCreated, nil)
+
+	// 再起動前のIDで再接続された
+	_, replay, unsubscribe := hub.Subscribe(1, 500)
+	defer unsubscribe()
+
+	if len(replay) != 1 || replay[0].Type != Resync {
+		t.Fatalf("replay = %+v, want a single resync event", replay)
+	}
+}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	mailer "orderbase/mail"
+	"orderbase/models"
+	"orderbase/security"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// AccountHandler メールアドレスの登録・確認とパスワードの再設定
+type AccountHandler struct {
+	DB        *gorm.DB
+	Mailer    mailer.Mailer
+	Tokens    *security.TokenSigner
+	Passwords security.PasswordPolicy
+	Logins    *security.LoginGuard // 再設定メールの送信回数の制限
+	BaseURL   string               // メール内のリンク先（未設定の場合はメールを送らない）
+}
+
+// errBaseURLNotConfigured リンク先が未設定（リクエストのHostヘッダーからは作らない）
+var errBaseURLNotConfigured = errors.New("メール内のリンク先（server.base_url）が設定されていません")
+
+// トークンの有効期限
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 24 * time.Hour
+)
+
+// normalizeEmail メールアドレスの形式を確認して小文字にそろえる（不正な場合は空文字）
+func normalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ""
+	}
+	return strings.ToLower(email)
+}
+
+// linkURL メール内のリンクを設定のベースURLから作成
+// リクエストのHostヘッダーを使うと、偽のホストを指定した再設定リンクをメールで送らせることができるため使わない
+func (h *AccountHandler) linkURL(path, token string) (string, error) {
+	base := strings.TrimRight(h.BaseURL, "/")
+	if base == "" {
+		return "", errBaseURLNotConfigured
+	}
+	return base + path + "?token=" + url.QueryEscape(token), nil
+}
+
+// userFingerprint トークンの検証用にユーザーの現在の状態を返す関数を作成
+func (h *AccountHandler) userFingerprint(state func(user *models.User) string) func(uint) (string, error) {
+	return func(userID uint) (string, error) {
+		var user models.User
+		if err := h.DB.First(&user, userID).Error; err != nil {
+			return "", err
+		}
+		return state(&user), nil
+	}
+}
+
+// パスワードの再設定トークンはパスワードが変わると無効になる（1回だけ使える）
+func passwordResetFingerprint(user *models.User) string { return user.Password }
+
+// メールアドレスの確認トークンはメールアドレスが変わると無効になる
+func emailVerifyFingerprint(user *models.User) string { return user.Email }
+
+// sendVerificationEmail メールアドレスの確認メールを送信
+func (h *AccountHandler) sendVerificationEmail(c *gin.Context, user *models.User) error {
+	token := h.Tokens.Sign(security.PurposeEmailVerify, user.ID, emailVerifyFingerprint(user), time.Now().Add(emailVerifyTTL))
+	link, err := h.linkURL("/verify-email", token)
+	if err != nil {
+		return err
+	}
+	return h.Mailer.Send(c.Request.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "【OrderBase】メールアドレスの確認",
+		Body: fmt.Sprintf("%s 様\n\n以下のリンクを開いてメールアドレスの確認を完了してください。\n%s\n\nリンクの有効期限は24時間です。\nお心当たりのない場合はこのメールを破棄してください。\n",
+			user.Username, link),
+	})
+}
+
+// UpdateEmail ログイン中のユーザーのメールアドレスを変更し、確認メールを送信（現在のパスワードで本人確認する）
+func (h *AccountHandler) UpdateEmail(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "メールアドレスの形式が正しくありません"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		return
+	}
+	if email == user.Email {
+		c.JSON(http.StatusOK, gin.H{"message": "メールアドレスは変更されていません", "email": user.Email})
+		return
+	}
+
+	var exists int64
+	if err := h.DB.Model(&models.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&exists).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メールアドレスの変更に失敗しました"})
+		return
+	}
+	if exists > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "このメールアドレスは既に使われています"})
+		return
+	}
+
+	if err := h.DB.Model(user).Updates(map[string]interface{}{"email": email, "email_verified_at": nil}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メールアドレスの変更に失敗しました"})
+		return
+	}
+	user.Email = email
+	user.EmailVerifiedAt = nil
+	if err := h.sendVerificationEmail(c, user); err != nil {
+		log.Printf("確認メールの送信に失敗しました: user=%d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "確認メールの送信に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "確認メールを送信しました", "email": email})
+}
+
+// ResendEmailVerification 確認メールを再送信
+func (h *AccountHandler) ResendEmailVerification(c *gin.Context) {
+	user := CurrentUser(c)
+	if user.Email == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "メールアドレスが登録されていません"})
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "メールアドレスは確認済みです"})
+		return
+	}
+
+	if err := h.sendVerificationEmail(c, user); err != nil {
+		log.Printf("確認メールの送信に失敗しました: user=%d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "確認メールの送信に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "確認メールを送信しました"})
+}
+
+// VerifyEmail 確認メールのトークンでメールアドレスを確認済みにする（ログイン不要）
+func (h *AccountHandler) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "トークンが必要です"})
+		return
+	}
+
+	userID, err := h.Tokens.Verify(security.PurposeEmailVerify, req.Token, time.Now(), h.userFingerprint(emailVerifyFingerprint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メールアドレスの確認に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "メールアドレスを確認しました"})
+}
+
+// ForgotPassword パスワードの再設定メールを送信（ログイン不要）
+// 登録されていないメールアドレスでも同じレスポンスを返す。確認済みのメールアドレスにのみ送信する
+func (h *AccountHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+	email := normalizeEmail(req.Email)
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "メールアドレスの形式が正しくありません"})
+		return
+	}
+
+	// 同じIPアドレス・メールアドレスからの連続した送信を制限する（送信ごとに試行として数える）
+	now := time.Now()
+	ip := c.ClientIP()
+	accountKey := security.AccountKey("password-reset", email)
+	if wait := h.Logins.Wait(ip, accountKey, now); wait > 0 {
+		respondTooManyAttempts(c, wait)
+		return
+	}
+	h.Logins.Fail(ip, accountKey, now)
+
+	var user models.User
+	if err := h.DB.Where("email = ? AND email_verified_at IS NOT NULL", email).First(&user).Error; err == nil {
+		token := h.Tokens.Sign(security.PurposePasswordReset, user.ID, passwordResetFingerprint(&user), now.Add(passwordResetTTL))
+		link, err := h.linkURL("/reset-password", token)
+		if err == nil {
+			err = h.Mailer.Send(c.Request.Context(), mailer.Message{
+				To:      user.Email,
+				Subject: "【OrderBase】パスワードの再設定",
+				Body: fmt.Sprintf("%s 様\n\nパスワードの再設定を受け付けました。以下のリンクから新しいパスワードを設定してください。\n%s\n\nリンクの有効期限は1時間で、1回だけ使えます。\nお心当たりのない場合はこのメールを破棄してください（パスワードは変更されません）。\n",
+					user.Username, link),
+			})
+		}
+		if err != nil {
+			log.Printf("パスワード再設定メールの送信に失敗しました: user=%d: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "登録されているメールアドレスの場合は、パスワードの再設定メールを送信しました"})
+}
+
+// ResetPassword 再設定メールのトークンで新しいパスワードを設定（ログイン不要）
+// アカウントの一時ロックも解除する。二段階認証が有効な場合はログイン時に引き続き確認コードが必要
+func (h *AccountHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "トークンと新しいパスワードが必要です"})
+		return
+	}
+
+	userID, err := h.Tokens.Verify(security.PurposePasswordReset, req.Token, time.Now(), h.userFingerprint(passwordResetFingerprint))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var user models.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": security.ErrInvalidToken.Error()})
+		return
+	}
+	if err := h.Passwords.Validate(req.Password, user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの再設定に失敗しました"})
+		return
+	}
+	// トークンの検証後に別の再設定で変更されていた場合は更新しない
+	result := h.DB.Model(&models.User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Updates(map[string]interface{}{"password": string(hash), "failed_logins": 0, "locked_until": nil})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの再設定に失敗しました"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": security.ErrInvalidToken.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを再設定しました"})
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	mailer "orderbase/mail"
+	"orderbase/models"
+	"orderbase/security"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testBaseURL = "https://orderbase.example.com"
+
+// accountFixture メール送信をファイルに保存するアカウントAPIのルーター
+type accountFixture struct {
+	db      *gorm.DB
+	router  *gin.Engine
+	mailDir string
+	user    models.User
+}
+
+// newAccountFixture メールアドレス確認済みのユーザーを作成し、指定したベースURLでリンクを作るルーターを用意
+func newAccountFixture(t *testing.T, baseURL string) *accountFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Store{}, &models.User{}); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("old-pass-123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifiedAt := time.Now()
+	f := &accountFixture{db: db, mailDir: t.TempDir()}
+	f.user = models.User{Username: "owner", Password: string(hash), Email: "owner@example.com", EmailVerifiedAt: &verifiedAt, Role: models.RoleOwner}
+	mustCreate(t, db, &f.user)
+
+	h := &AccountHandler{
+		DB:        db,
+		Mailer:    &mailer.FileMailer{Dir: f.mailDir, From: "no-reply@orderbase.local"},
+		Tokens:    security.NewTokenSigner([]byte("test-account-token-secret-32bytes")),
+		Passwords: security.DefaultPasswordPolicy(),
+		Logins:    security.DefaultLoginGuard(),
+		BaseURL:   baseURL,
+	}
+	f.router = gin.New()
+	f.router.POST("/password/forgot", h.ForgotPassword)
+	f.router.POST("/password/reset", h.ResetPassword)
+	f.router.POST("/email/verify", h.VerifyEmail)
+	loggedIn := f.router.Group("", func(c *gin.Context) {
+		var user models.User
+		if err := db.First(&user, f.user.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		c.Set(currentUserKey, &user)
+		c.Next()
+	})
+	loggedIn.PUT("/user/email", h.UpdateEmail)
+	return f
+}
+
+// post リクエストのHostヘッダーを偽のホストにしてJSONを送る（リンクに使われないことを確認するため）
+func (f *accountFixture) post(t *testing.T, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Host = "attacker.example"
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+// sentMails 保存されたメールの本文
+func (f *accountFixture) sentMails(t *testing.T) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(f.mailDir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make([]string, 0, len(paths))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(file)
+		if err != nil {
+			file.Close()
+			t.Fatal(err)
+		}
+		var encoded bytes.Buffer
+		encoded.ReadFrom(msg.Body)
+		file.Close()
+		body, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded.String(), "\r\n", ""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+var mailLinkPattern = regexp.MustCompile(`https?://\S+`)
+
+// mailToken 1通だけ送られたメールのリンクを確認し、トークンを取り出す
+func (f *accountFixture) mailToken(t *testing.T, path string) string {
+	t.Helper()
+	bodies := f.sentMails(t)
+	if len(bodies) != 1 {
+		t.Fatalf("sent %d mails, want 1", len(bodies))
+	}
+	link := mailLinkPattern.FindString(bodies[0])
+	prefix := testBaseURL + path + "?token="
+	if !strings.HasPrefix(link, prefix) {
+		t.Fatalf("link = %q, want prefix %q", link, prefix)
+	}
+	return strings.TrimPrefix(link, prefix)
+}
+
+func TestPasswordReset(t *testing.T) {
+	f := newAccountFixture(t, testBaseURL)
+
+	if w := f.post(t, http.MethodPost, "/password/forgot", gin.H{"email": "Owner@Example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("forgot status = %d, body = %s", w.Code, w.Body)
+	}
+	token := f.mailToken(t, "/reset-password")
+
+	w := f.post(t, http.MethodPost, "/password/reset", gin.H{"token": token, "password": "new-pass-456"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, body = %s", w.Code, w.Body)
+	}
+	var user models.User
+	if err := f.db.First(&user, f.user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("new-pass-456")) != nil {
+		t.Error("パスワードが再設定されていません")
+	}
+
+	// 再設定に使ったトークンは再利用できない
+	if w := f.post(t, http.MethodPost, "/password/reset", gin.H{"token": token, "password": "other-pass-789"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("reuse status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+func TestPasswordResetIgnoresUnknownEmail(t *testing.T) {
+	f := newAccountFixture(t, testBaseURL)
+
+	if w := f.post(t, http.MethodPost, "/password/forgot", gin.H{"email": "nobody@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("forgot status = %d, body = %s", w.Code, w.Body)
+	}
+	if bodies := f.sentMails(t); len(bodies) != 0 {
+		t.Errorf("sent %d mails, want 0", len(bodies))
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	f := newAccountFixture(t, testBaseURL)
+
+	w := f.post(t, http.MethodPut, "/user/email", gin.H{"email": "new@example.com", "password": "old-pass-123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update email status = %d, body = %s", w.Code, w.Body)
+	}
+	token := f.mailToken(t, "/verify-email")
+
+	if w := f.post(t, http.MethodPost, "/email/verify", gin.H{"token": token}); w.Code != http.StatusOK {
+		t.Fatalf("verify status = %d, body = %s", w.Code, w.Body)
+	}
+	var user models.User
+	if err := f.db.First(&user, f.user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "new@example.com" || user.EmailVerifiedAt == nil {
+		t.Errorf("email = %q, verified_at = %v; want new@example.com, set", user.Email, user.EmailVerifiedAt)
+	}
+}
+
+func TestAccountMailsRequireBaseURL(t *testing.T) {
+	f := newAccountFixture(t, "")
+
+	// 再設定メールは登録の有無と同じレスポンスを返し、リクエストのホストでリンクを作らない
+	if w := f.post(t, http.MethodPost, "/password/forgot", gin.H{"email": "owner@example.com"}); w.Code != http.StatusOK {
+		t.Fatalf("forgot status = %d, body = %s", w.Code, w.Body)
+	}
+	if w := f.post(t, http.MethodPut, "/user/email", gin.H{"email": "new@example.com", "password": "old-pass-123"}); w.Code != http.StatusInternalServerError {
+		t.Fatalf("update email status = %d, body = %s", w.Code, w.Body)
+	}
+	if bodies := f.sentMails(t); len(bodies) != 0 {
+		t.Errorf("sent %d mails, want 0: %q", len(bodies), bodies)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type APITokenHandler struct {
+	DB *gorm.DB
+}
+
+const (
+	// APIトークンの先頭に付ける文字列（ログや設定ファイルで見分けやすくする）
+	apiTokenPrefix = "obt_"
+	// 一覧に表示するトークンの先頭部分の長さ
+	apiTokenDisplayLength = len(apiTokenPrefix) + 8
+)
+
+// canManageAPIToken ログイン中のユーザーがトークンを管理できるか
+// 個人用トークンは発行したユーザー本人、連携サービス用トークンはAPIトークンの管理権限を持つユーザーが管理する
+func canManageAPIToken(user *models.User, token *models.APIToken) bool {
+	if token.Kind == models.APITokenService {
+		return models.RoleHasPermission(user.Role, models.PermAPITokensManage)
+	}
+	return token.UserID == user.ID
+}
+
+// GetAPITokens ログイン中のユーザーが管理できるAPIトークンの一覧を取得
+func (h *APITokenHandler) GetAPITokens(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	user := CurrentUser(c)
+
+	query := h.DB.Where("store_id = ?", storeID)
+	if models.RoleHasPermission(user.Role, models.PermAPITokensManage) {
+		query = query.Where("user_id = ? OR kind = ?", user.ID, models.APITokenService)
+	} else {
+		query = query.Where("user_id = ? AND kind = ?", user.ID, models.APITokenPersonal)
+	}
+	var tokens []models.APIToken
+	if err := query.Order("id ASC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "APIトークンの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tokens": tokens,
+		"scopes": models.RolePermissions(user.Role), // 発行時に選択できるスコープ
+	})
+}
+
+// CreateAPIToken APIトークンを発行（トークンはこのレスポンスでのみ返す）
+func (h *APITokenHandler) CreateAPIToken(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	user := CurrentUser(c)
+
+	var req struct {
+		Name          string   `json:"name"`
+		Kind          string   `json:"kind"`            // personal（省略時）または service
+		Scopes        []string `json:"scopes"`          // 許可する操作（自分の役割で許可されているもののみ）
+		ExpiresInDays int      `json:"expires_in_days"` // 有効期限（日数、0の場合は無期限）
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "トークン名が必要です"})
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = models.APITokenPersonal
+	}
+	if !models.IsValidAPITokenKind(req.Kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なトークンの種類です"})
+		return
+	}
+	if req.Kind == models.APITokenService && !models.RoleHasPermission(user.Role, models.PermAPITokensManage) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "連携サービス用トークンを発行する権限がありません"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "スコープを1つ以上指定してください"})
+		return
+	}
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !models.RoleHasPermission(user.Role, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "指定できないスコープです: " + scope})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "有効期限が不正です"})
+		return
+	}
+
+	secret, err := newSecretToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "APIトークンの発行に失敗しました"})
+		return
+	}
+	token := apiTokenPrefix + secret
+	apiToken := models.APIToken{
+		StoreID:   storeID,
+		UserID:    user.ID,
+		Kind:      req.Kind,
+		Name:      req.Name,
+		Prefix:    token[:apiTokenDisplayLength],
+		TokenHash: hashSecretToken(token),
+		Scopes:    strings.Join(scopes, ","),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiToken.ExpiresAt = &expiresAt
+	}
+	if err := h.DB.Create(&apiToken).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "APIトークンの発行に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "APIトークンを発行しました",
+		"api_token": apiToken,
+		"token":     token, // Authorization: Bearer ヘッダーで送る（再表示はできない）
+	})
+}
+
+// RevokeAPIToken APIトークンを解除（以降そのトークンでは認証できない）
+func (h *APITokenHandler) RevokeAPIToken(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var apiToken models.APIToken
+	if err := h.DB.Where("store_id = ?", storeID).First(&apiToken, c.Param("id")).Error; err != nil || !canManageAPIToken(CurrentUser(c), &apiToken) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "APIトークンが見つかりません"})
+		return
+	}
+	if apiToken.RevokedAt == nil {
+		now := time.Now()
+		if err := h.DB.Model(&apiToken).Update("revoked_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "APIトークンの解除に失敗しました"})
+			return
+		}
+		apiToken.RevokedAt = &now
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "APIトークンを解除しました", "api_token": apiToken})
+}
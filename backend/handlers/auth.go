@@ -2,7 +2,12 @@ package handlers
 
 import (
 	"net/http"
+	"orderbase/billing"
 	"orderbase/models"
+	"orderbase/receipt"
+	"orderbase/security"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -11,7 +16,10 @@ import (
 )
 
 type AuthHandler struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	Passwords security.PasswordPolicy // 登録時のパスワードの条件
+	Logins    *security.LoginGuard    // ログイン試行の制限
+	Secrets   *security.Keyring       // OpenAI APIキーの暗号化
 }
 
 func (h *AuthHandler) ShowRegisterPage(c *gin.Context) {
@@ -27,11 +35,26 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "入力値が不正です"})
 		return
 	}
+	if err := h.Passwords.Validate(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	hash, _ := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	user := models.User{Username: req.Username, Password: string(hash)}
+	user := models.User{Username: req.Username, Password: string(hash), Role: models.RoleOwner}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	// 新規登録したユーザーは自分の店舗を持ち、そのオーナーになる
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		store := models.Store{Name: req.Username, OwnerID: &user.ID}
+		if err := tx.Create(&store).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Update("store_id", store.ID).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "登録失敗（重複？）"})
 		return
 	}
@@ -43,6 +66,9 @@ func (h *AuthHandler) ShowLoginPage(c *gin.Context) {
 	c.HTML(http.StatusOK, "login.html", nil)
 }
 
+// LoginUser ユーザー名とパスワードでログイン
+// 失敗が続くとIPアドレス・アカウントごとに待ち時間を延ばし、さらに続くとアカウントを一時ロックする
+// ユーザーが存在しない場合もパスワードが違う場合も同じエラーを返す
 func (h *AuthHandler) LoginUser(c *gin.Context) {
 	var req struct {
 		Username string `json:"username"`
@@ -53,24 +79,106 @@ func (h *AuthHandler) LoginUser(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
+	ip := c.ClientIP()
+	accountKey := security.AccountKey(models.LoginMethodPassword, req.Username)
+
 	var user models.User
-	if err := h.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーが見つかりません"})
+	found := h.DB.Where("username = ?", req.Username).First(&user).Error == nil
+	if wait := h.Logins.Wait(ip, accountKey, now); wait > 0 {
+		var attempted *models.User
+		if found {
+			attempted = &user
+		}
+		recordLoginAttempt(h.DB, c, attempted, req.Username, models.LoginMethodPassword, models.LoginFailThrottled)
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
+	if !found {
+		// 存在しないユーザーでも応答時間が変わらないようにパスワードを照合する
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		h.Logins.Fail(ip, accountKey, now)
+		recordLoginAttempt(h.DB, c, nil, req.Username, models.LoginMethodPassword, models.LoginFailUnknownUser)
+		respondLoginFailed(c)
+		return
+	}
+
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		h.Logins.IP.Fail(ip, now)
+		recordLoginAttempt(h.DB, c, &user, req.Username, models.LoginMethodPassword, models.LoginFailLocked)
+		respondTooManyAttempts(c, user.LockedUntil.Sub(now))
 		return
 	}
 
 	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		h.Logins.Fail(ip, accountKey, now)
+		h.recordPasswordFailure(&user, now)
+		recordLoginAttempt(h.DB, c, &user, req.Username, models.LoginMethodPassword, models.LoginFailWrongPassword)
+		respondLoginFailed(c)
 		return
 	}
 
-	// ✅ ログイン成功 → セッション保存！
+	h.Logins.Succeed(accountKey)
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		h.DB.Model(&user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
+	}
+	recordLoginAttempt(h.DB, c, &user, req.Username, models.LoginMethodPassword, "")
+
 	session := sessions.Default(c)
+
+	// 二段階認証が有効な場合は確認コード（VerifyLoginTwoFactor）の確認後にログインさせる
+	if user.TOTPEnabled {
+		session.Delete("user")
+		session.Delete("user_id")
+		session.Delete("terminal_id")
+		session.Delete(twoFactorVerifiedKey)
+		session.Set(pendingTwoFactorUserKey, user.ID)
+		session.Set(pendingTwoFactorAtKey, now.Unix())
+		session.Save()
+		c.JSON(http.StatusOK, gin.H{"message": "確認コードを入力してください", "two_factor_required": true})
+		return
+	}
+
+	// ✅ ログイン成功 → セッション保存！
+	setLoginSession(session, &user, false)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":                   "ログイン成功",
+		"two_factor_setup_required": twoFactorSetupRequired(h.DB, &user), // 店舗の設定で二段階認証の設定が必要な場合は true
+	})
+}
+
+// setLoginSession ログインしたユーザーをセッションに保存
+// twoFactorVerified はこのログインで二段階認証の確認コードを確認した場合に true
+func setLoginSession(session sessions.Session, user *models.User, twoFactorVerified bool) {
 	session.Set("user", user.Username)
 	session.Set("user_id", user.ID)
+	session.Delete("terminal_id")
+	if twoFactorVerified {
+		session.Set(twoFactorVerifiedKey, user.ID)
+	} else {
+		session.Delete(twoFactorVerifiedKey)
+	}
+	session.Delete(pendingTwoFactorUserKey)
+	session.Delete(pendingTwoFactorAtKey)
 	session.Save()
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "ログイン成功"})
+// recordPasswordFailure パスワードの連続失敗回数を増やし、上限に達したらアカウントを一時ロック
+func (h *AuthHandler) recordPasswordFailure(user *models.User, now time.Time) {
+	failures := user.FailedLogins + 1
+	if failures < h.Logins.LockoutThreshold {
+		h.DB.Model(user).Update("failed_logins", gorm.Expr("failed_logins + 1"))
+		return
+	}
+	lockedUntil := now.Add(h.Logins.LockoutDuration)
+	h.DB.Model(user).Updates(map[string]interface{}{"failed_logins": 0, "locked_until": lockedUntil})
+}
+
+// GetPasswordPolicy 登録時のパスワードの条件を取得（入力画面での案内用）
+func (h *AuthHandler) GetPasswordPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, h.Passwords)
 }
 
 func (h *AuthHandler) LogoutUser(c *gin.Context) {
@@ -83,12 +191,7 @@ func (h *AuthHandler) LogoutUser(c *gin.Context) {
 
 // OpenAI APIキーを設定
 func (h *AuthHandler) SetOpenAIKey(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
+	userID := currentUserID(c)
 
 	var req struct {
 		APIKey string `json:"api_key"`
@@ -98,7 +201,13 @@ func (h *AuthHandler) SetOpenAIKey(c *gin.Context) {
 		return
 	}
 
-	if err := h.DB.Model(&models.User{}).Where("id = ?", userID).Update("open_ai_key", req.APIKey).Error; err != nil {
+	// APIキーは暗号化して保存する
+	encrypted, err := h.Secrets.Encrypt(req.APIKey, models.OpenAIKeyContext(userID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+		return
+	}
+	if err := h.DB.Model(&models.User{}).Where("id = ?", userID).Update("open_ai_key", encrypted).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
 		return
 	}
@@ -108,42 +217,29 @@ func (h *AuthHandler) SetOpenAIKey(c *gin.Context) {
 
 // OpenAI APIキーを取得
 func (h *AuthHandler) GetOpenAIKey(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	user := CurrentUser(c)
+	apiKey, err := h.Secrets.Decrypt(user.OpenAIKey, models.OpenAIKeyContext(user.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "APIキーの復号に失敗しました"})
 		return
 	}
 
 	// APIキーの最初の数文字だけ返す（セキュリティのため）
 	maskedKey := ""
-	if len(user.OpenAIKey) > 10 {
-		maskedKey = user.OpenAIKey[:10] + "..." + user.OpenAIKey[len(user.OpenAIKey)-4:]
-	} else if user.OpenAIKey != "" {
+	if len(apiKey) > 10 {
+		maskedKey = apiKey[:10] + "..." + apiKey[len(apiKey)-4:]
+	} else if apiKey != "" {
 		maskedKey = "設定済み"
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"has_key": user.OpenAIKey != "",
+		"has_key": apiKey != "",
 		"masked_key": maskedKey,
 	})
 }
 
 // SetMainMenu メインメニューページを設定
 func (h *AuthHandler) SetMainMenu(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
 	var req struct {
 		MainMenuPage string `json:"main_menu_page"`
 	}
@@ -151,8 +247,12 @@ func (h *AuthHandler) SetMainMenu(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
 		return
 	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
 
-	if err := h.DB.Model(&models.User{}).Where("id = ?", userID).Update("main_menu_page", req.MainMenuPage).Error; err != nil {
+	if err := h.DB.Model(&models.Store{}).Where("id = ?", storeID).Update("main_menu_page", req.MainMenuPage).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
 		return
 	}
@@ -162,20 +262,113 @@ func (h *AuthHandler) SetMainMenu(c *gin.Context) {
 
 // GetMainMenu メインメニューページを取得
 func (h *AuthHandler) GetMainMenu(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
-	if err := h.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"main_menu_page": store.MainMenuPage,
+	})
+}
+
+// SetTaxSettings 価格の税込・税抜を設定
+func (h *AuthHandler) SetTaxSettings(c *gin.Context) {
+	var req struct {
+		PriceIncludesTax *bool `json:"price_includes_tax" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	// 以降の注文から適用（注文済みの金額は変わらない）
+	if err := h.DB.Model(&models.Store{}).Where("id = ?", storeID).Update("price_includes_tax", *req.PriceIncludesTax).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "税設定を保存しました"})
+}
+
+// GetTaxSettings 価格の税込・税抜と税率を取得
+func (h *AuthHandler) GetTaxSettings(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"price_includes_tax": store.PriceIncludesTax,
+		"standard_rate":      billing.StandardTaxRate,
+		"reduced_rate":       billing.ReducedTaxRate,
+	})
+}
+
+// SetReceiptSettings 領収書に記載する店舗名・登録番号を設定
+func (h *AuthHandler) SetReceiptSettings(c *gin.Context) {
+	var req struct {
+		StoreName     string `json:"store_name"`
+		InvoiceNumber string `json:"invoice_number"` // 適格請求書発行事業者の登録番号
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+	req.InvoiceNumber = strings.ToUpper(strings.TrimSpace(req.InvoiceNumber))
+	if req.InvoiceNumber != "" && !receipt.IsValidRegistrationNumber(req.InvoiceNumber) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "登録番号は「T」と13桁の数字で入力してください"})
+		return
+	}
+
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.Model(&models.Store{}).Where("id = ?", storeID).Updates(map[string]interface{}{
+		"name":           req.StoreName,
+		"invoice_number": req.InvoiceNumber,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "領収書の設定を保存しました"})
+}
+
+// GetReceiptSettings 領収書に記載する店舗名・登録番号を取得
+func (h *AuthHandler) GetReceiptSettings(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"main_menu_page": user.MainMenuPage,
+		"store_name":     store.Name,
+		"invoice_number": store.InvoiceNumber,
 	})
 }
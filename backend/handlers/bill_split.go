@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"orderbase/billing"
+	"orderbase/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// errPartAlreadyPaid 同時に支払われたなどで、請求が既に支払い済みになっている
+var errPartAlreadyPaid = errors.New("この請求は支払い済みです")
+
+// errSplitPaymentStarted 分割会計の支払いが始まった来店で注文を追加・取り消ししようとした
+var errSplitPaymentStarted = errors.New("分割会計の支払いが始まっているため、注文の追加・取り消しはできません")
+
+// ensureSplitPaymentNotStarted 分割会計の支払いが始まった来店では注文の追加・取り消しをさせない
+// （支払い済みの請求と請求額が合わなくなり、分割のやり直しも会計もできなくなるため）
+func ensureSplitPaymentNotStarted(tx *gorm.DB, tableSessionID *uint) error {
+	if tableSessionID == nil {
+		return nil
+	}
+	var paid int64
+	if err := tx.Model(&models.BillSplitPart{}).
+		Joins("JOIN bill_splits ON bill_splits.id = bill_split_parts.bill_split_id").
+		Where("bill_splits.table_session_id = ? AND bill_splits.status = ? AND bill_split_parts.status = ?",
+			*tableSessionID, models.BillSplitOpen, models.BillSplitPartPaid).
+		Count(&paid).Error; err != nil {
+		return err
+	}
+	if paid > 0 {
+		return errSplitPaymentStarted
+	}
+	return nil
+}
+
+// findOpenBillSplit 来店セッションの未完了の分割を取得（なければnil）
+func findOpenBillSplit(db *gorm.DB, tableSessionID uint) (*models.BillSplit, error) {
+	var split models.BillSplit
+	err := db.Where("table_session_id = ? AND status = ?", tableSessionID, models.BillSplitOpen).
+		Preload("Parts").
+		Preload("Parts.Items").
+		First(&split).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &split, nil
+}
+
+// billSplitHasPayments 分割のいずれかが支払い済みか
+func billSplitHasPayments(split *models.BillSplit) bool {
+	for _, part := range split.Parts {
+		if part.Status == models.BillSplitPartPaid {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTableBill テーブルの未会計の請求（注文・合計・分割状況）を取得
+func (h *PaymentHandler) GetTableBill(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	current, orders, bill, ok := h.currentBill(c, table)
+	if !ok {
+		return
+	}
+
+	split, err := findOpenBillSplit(h.DB, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table":   table,
+		"session": current,
+		"orders":  orders,
+		"total":   bill.Total,
+		"tax":     bill,
+		"split":   split,
+	})
+}
+
+// CreateBillSplit 請求を分割（均等・品目ごと・金額指定）
+func (h *PaymentHandler) CreateBillSplit(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Mode      string `json:"mode" binding:"required,oneof=even items amounts"`
+		Count     int    `json:"count"`      // 均等割りの人数
+		RoundUnit int    `json:"round_unit"` // 均等割りの端数単位（1・10・100円）
+		Parts     []struct {
+			Label   string `json:"label"`
+			ItemIDs []uint `json:"item_ids"` // 品目ごとの場合の注文明細ID
+			Amount  int    `json:"amount"`   // 金額指定の場合の金額
+		} `json:"parts"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	current, orders, bill, ok := h.currentBill(c, table)
+	if !ok {
+		return
+	}
+	total := bill.Total
+	if len(orders) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未会計の注文がありません"})
+		return
+	}
+
+	existing, err := findOpenBillSplit(h.DB, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の取得に失敗しました"})
+		return
+	}
+	if existing != nil && billSplitHasPayments(existing) {
+		c.JSON(http.StatusConflict, gin.H{"error": "支払い済みの分割があるため変更できません"})
+		return
+	}
+
+	split := models.BillSplit{
+		TableID:        table.ID,
+		TableSessionID: current.ID,
+		Mode:           req.Mode,
+		Total:          total,
+		Status:         models.BillSplitOpen,
+	}
+
+	switch req.Mode {
+	case models.BillSplitEven:
+		roundUnit := req.RoundUnit
+		if roundUnit == 0 {
+			roundUnit = 1
+		}
+		amounts, err := billing.SplitEvenly(total, req.Count, roundUnit)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i, amount := range amounts {
+			split.Parts = append(split.Parts, models.BillSplitPart{
+				Label:  fmt.Sprintf("%d人目", i+1),
+				Amount: amount,
+			})
+		}
+
+	case models.BillSplitAmounts:
+		for i, p := range req.Parts {
+			label := p.Label
+			if label == "" {
+				label = fmt.Sprintf("%d人目", i+1)
+			}
+			split.Parts = append(split.Parts, models.BillSplitPart{Label: label, Amount: p.Amount})
+		}
+
+	case models.BillSplitItems:
+		// 未会計の明細をすべて、いずれか1つの請求に割り当てる
+		lines := make(map[uint]billing.TaxLine)
+		for _, order := range orders {
+			for _, item := range order.Items {
+				lines[item.ID] = billing.TaxLine{
+					Rate:             item.TaxRate,
+					Amount:           item.LineTotal,
+					PriceIncludesTax: order.PriceIncludesTax,
+				}
+			}
+		}
+		allocated := make(map[uint]bool)
+		for i, p := range req.Parts {
+			label := p.Label
+			if label == "" {
+				label = fmt.Sprintf("%d人目", i+1)
+			}
+			part := models.BillSplitPart{Label: label}
+			var partLines []billing.TaxLine
+			for _, itemID := range p.ItemIDs {
+				line, exists := lines[itemID]
+				if !exists {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("注文明細 %d は未会計の明細ではありません", itemID)})
+					return
+				}
+				if allocated[itemID] {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("注文明細 %d が複数の請求に割り当てられています", itemID)})
+					return
+				}
+				allocated[itemID] = true
+				partLines = append(partLines, line)
+				part.Items = append(part.Items, models.BillSplitItem{OrderItemID: itemID, Amount: line.Amount})
+			}
+			// 各請求の金額は税込で計算する
+			part.Amount = billing.CalculateTax(partLines).Total
+			split.Parts = append(split.Parts, part)
+		}
+		if len(allocated) != len(lines) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "割り当てられていない注文明細があります"})
+			return
+		}
+		// 税額の端数処理による差額は1人目が負担する
+		if len(split.Parts) > 0 {
+			sum := 0
+			for _, part := range split.Parts {
+				sum += part.Amount
+			}
+			split.Parts[0].Amount += total - sum
+		}
+	}
+
+	// 分割後の合計が請求額と一致することを確認
+	amounts := make([]int, 0, len(split.Parts))
+	for i := range split.Parts {
+		split.Parts[i].Status = models.BillSplitPartUnpaid
+		amounts = append(amounts, split.Parts[i].Amount)
+	}
+	if err := billing.ValidateAmounts(total, amounts); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "bill_total": total})
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// 未払いの既存の分割は置き換える
+		if existing != nil {
+			if err := tx.Model(existing).Update("status", models.BillSplitCancelled).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&split).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の作成に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "請求を分割しました",
+		"split":   split,
+	})
+}
+
+// CancelBillSplit 未払いの分割を取り消す
+func (h *PaymentHandler) CancelBillSplit(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	splitID, err := strconv.ParseUint(c.Param("split_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var split models.BillSplit
+	if err := h.DB.Where("id = ? AND table_id = ? AND status = ?", splitID, table.ID, models.BillSplitOpen).
+		Preload("Parts").
+		First(&split).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分割会計が見つかりません"})
+		return
+	}
+	if billSplitHasPayments(&split) {
+		c.JSON(http.StatusConflict, gin.H{"error": "支払い済みの分割があるため取り消せません"})
+		return
+	}
+
+	if err := h.DB.Model(&split).Update("status", models.BillSplitCancelled).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の取り消しに失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "分割会計を取り消しました"})
+}
+
+// PayBillSplitPart 分割した請求の1つを支払う（全て支払われるとテーブルの会計が完了）
+func (h *PaymentHandler) PayBillSplitPart(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	splitID, err := strconv.ParseUint(c.Param("split_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+	partID, err := strconv.ParseUint(c.Param("part_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var req struct {
+		Payments []PaymentRequest `json:"payments" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	current, orders, bill, ok := h.currentBill(c, table)
+	if !ok {
+		return
+	}
+	total := bill.Total
+
+	split, err := findOpenBillSplit(h.DB, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の取得に失敗しました"})
+		return
+	}
+	if split == nil || split.ID != uint(splitID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分割会計が見つかりません"})
+		return
+	}
+
+	// 分割後に注文が追加・取り消しされた場合は分割をやり直す
+	if split.Total != total {
+		c.JSON(http.StatusConflict, gin.H{"error": "注文内容が変わったため分割をやり直してください", "bill_total": total})
+		return
+	}
+
+	var part *models.BillSplitPart
+	for i := range split.Parts {
+		if split.Parts[i].ID == uint(partID) {
+			part = &split.Parts[i]
+		}
+	}
+	if part == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "請求が見つかりません"})
+		return
+	}
+	if part.Status == models.BillSplitPartPaid {
+		c.JSON(http.StatusConflict, gin.H{"error": "この請求は支払い済みです"})
+		return
+	}
+
+	paidTotal := 0
+	for _, p := range req.Payments {
+		paidTotal += p.Amount
+	}
+	if paidTotal != part.Amount {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       fmt.Sprintf("支払額の合計（¥%d）が請求額（¥%d）と一致しません", paidTotal, part.Amount),
+			"part_amount": part.Amount,
+		})
+		return
+	}
+
+	results, err := h.chargePayments(c, req.Payments)
+	if err != nil {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := actorFromSession(c)
+	settled := false
+	var records []models.Payment
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// 同じ請求が同時に支払われた場合はどちらか一方のみ成功させる
+		result := tx.Model(&models.BillSplitPart{}).
+			Where("id = ? AND status = ?", part.ID, models.BillSplitPartUnpaid).
+			Update("status", models.BillSplitPartPaid)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errPartAlreadyPaid
+		}
+
+		var err error
+		records, err = recordPayments(tx, results, models.Payment{TableID: &table.ID, TableSessionID: &current.ID, BillSplitPartID: &part.ID}, actor)
+		if err != nil {
+			return err
+		}
+
+		// 最後の2件が同時に支払われても会計が完了するよう、更新後の未払いの数で判定する
+		var unpaid int64
+		if err := tx.Model(&models.BillSplitPart{}).
+			Where("bill_split_id = ? AND status = ?", split.ID, models.BillSplitPartUnpaid).
+			Count(&unpaid).Error; err != nil {
+			return err
+		}
+		if unpaid > 0 {
+			return nil
+		}
+
+		// 最後の請求が支払われたらテーブルの会計を完了
+		if orders, err = loadOpenOrders(tx, current.ID); err != nil {
+			return err
+		}
+		if billTax(orders).Total != split.Total {
+			return errBillChanged
+		}
+		if err := tx.Model(split).Update("status", models.BillSplitPaid).Error; err != nil {
+			return err
+		}
+		settled = true
+		return settleTable(tx, table, current, orders, actor)
+	})
+	if err != nil {
+		h.refundPayments(c, results)
+		if transitionErr, ok := err.(*statusTransitionError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+			return
+		}
+		if errors.Is(err, errPartAlreadyPaid) || errors.Is(err, errBillChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "会計処理に失敗しました"})
+		return
+	}
+
+	part.Status = models.BillSplitPartPaid
+	if settled {
+		publishSettlement(table, orders)
+	}
+
+	remaining := 0
+	for _, p := range split.Parts {
+		if p.ID != part.ID && p.Status == models.BillSplitPartUnpaid {
+			remaining += p.Amount
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "支払いが完了しました",
+		"part":      part,
+		"payments":  records,
+		"change":    totalChange(records),
+		"remaining": remaining,
+		"settled":   settled,
+	})
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"orderbase/models"
+	"orderbase/payments"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// createEvenSplit テーブルの請求を均等に分割する
+func (f *checkoutFixture) createEvenSplit(t *testing.T, count int) models.BillSplit {
+	t.Helper()
+	w := f.post(t, fmt.Sprintf("/tables/%d/splits", f.table.ID), gin.H{"mode": models.BillSplitEven, "count": count})
+	if w.Code != http.StatusOK {
+		t.Fatalf("create split status = %d, body = %s", w.Code, w.Body)
+	}
+	var res struct {
+		Split models.BillSplit `json:"split"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Split
+}
+
+// payPart 分割した請求の1つを現金で支払う
+func (f *checkoutFixture) payPart(t *testing.T, split models.BillSplit, part models.BillSplitPart) int {
+	t.Helper()
+	w := f.post(t, fmt.Sprintf("/tables/%d/splits/%d/parts/%d/pay", f.table.ID, split.ID, part.ID),
+		gin.H{"payments": []PaymentRequest{{Method: payments.MethodCash, Amount: part.Amount}}})
+	return w.Code
+}
+
+func TestSplitPaymentBlocksOrderChanges(t *testing.T) {
+	f := newCheckoutFixture(t, payments.CashProvider{})
+	order := f.addOrder(t, 1000)
+	other := f.addOrder(t, 500)
+
+	split := f.createEvenSplit(t, 3)
+	if code := f.payPart(t, split, split.Parts[0]); code != http.StatusOK {
+		t.Fatalf("pay first part status = %d", code)
+	}
+
+	// 1人目の支払い後は注文・明細の取り消しと削除を受け付けない
+	changes := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"cancel order", http.MethodPatch, fmt.Sprintf("/orders/%d/status", order.ID), gin.H{"status": models.OrderStatusCancelled}},
+		{"cancel item", http.MethodPatch, fmt.Sprintf("/orders/%d/items/%d/status", other.ID, other.Items[0].ID), gin.H{"status": models.OrderStatusCancelled}},
+		{"delete order", http.MethodDelete, fmt.Sprintf("/orders/%d", order.ID), nil},
+	}
+	for _, change := range changes {
+		if w := f.request(t, change.method, change.path, change.body); w.Code != http.StatusConflict {
+			t.Errorf("%s status = %d, body = %s; want 409", change.name, w.Code, w.Body)
+		}
+	}
+	for _, id := range []uint{order.ID, other.ID} {
+		if got := f.orderStatus(t, id); got != models.OrderStatusReceived {
+			t.Errorf("order %d status = %q, want %q", id, got, models.OrderStatusReceived)
+		}
+	}
+
+	// 請求額が変わっていないため残りの請求を支払って会計を完了できる
+	for _, part := range split.Parts[1:] {
+		if code := f.payPart(t, split, part); code != http.StatusOK {
+			t.Fatalf("pay part %d status = %d", part.ID, code)
+		}
+	}
+	for _, id := range []uint{order.ID, other.ID} {
+		if got := f.orderStatus(t, id); got != models.OrderStatusPaid {
+			t.Errorf("order %d status = %q, want %q", id, got, models.OrderStatusPaid)
+		}
+	}
+	if got := f.sessionStatus(t); got != models.TableSessionClosed {
+		t.Errorf("session status = %q, want %q", got, models.TableSessionClosed)
+	}
+}
+
+func TestSplitAllowsOrderChangesBeforePayment(t *testing.T) {
+	f := newCheckoutFixture(t, payments.CashProvider{})
+	order := f.addOrder(t, 1000)
+	f.addOrder(t, 500)
+
+	// 支払い前なら注文を取り消して分割をやり直せる
+	stale := f.createEvenSplit(t, 2)
+	w := f.request(t, http.MethodPatch, fmt.Sprintf("/orders/%d/status", order.ID), gin.H{"status": models.OrderStatusCancelled})
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancel order status = %d, body = %s", w.Code, w.Body)
+	}
+	if code := f.payPart(t, stale, stale.Parts[0]); code != http.StatusConflict {
+		t.Fatalf("pay stale part status = %d, want 409", code)
+	}
+
+	split := f.createEvenSplit(t, 2)
+	if split.Total != 500 {
+		t.Fatalf("split total = %d, want 500", split.Total)
+	}
+	for _, part := range split.Parts {
+		if code := f.payPart(t, split, part); code != http.StatusOK {
+			t.Fatalf("pay part %d status = %d", part.ID, code)
+		}
+	}
+	if got := f.sessionStatus(t); got != models.TableSessionClosed {
+		t.Errorf("session status = %q, want %q", got, models.TableSessionClosed)
+	}
+}
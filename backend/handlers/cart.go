@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"orderbase/events"
 	"orderbase/models"
+	"orderbase/printing"
+	"orderbase/qrtoken"
 	"strconv"
 	"time"
 
@@ -13,8 +18,9 @@ import (
 
 // AddToCartRequest カート追加リクエスト
 type AddToCartRequest struct {
-	ProductID uint `json:"product_id" binding:"required"`
-	Quantity  int  `json:"quantity" binding:"required,min=1"`
+	ProductID uint   `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+	OptionIDs []uint `json:"option_ids"` // 選択したオプション
 }
 
 // GetOrCreateSessionID セッションIDを取得または作成
@@ -49,18 +55,45 @@ func AddToCart(c *gin.Context, db *gorm.DB) {
 	}
 
 	// 商品が存在するか確認
-	var product models.Product
-	if err := db.First(&product, req.ProductID).Error; err != nil {
+	product, err := loadProductForOrder(db, req.ProductID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
 		return
 	}
 
-	// 既にカートにある場合は数量を更新
+	// 選択オプションを検証（価格はサーバー側で計算する）
+	options, msg := selectOptions(product, req.OptionIDs)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	key := optionKey(req.OptionIDs)
+
+	// 1つのカートには同じ店舗の商品のみ入れられる
+	var otherStore int64
+	if err := db.Model(&models.CartItem{}).
+		Where("session_id = ? AND store_id <> ?", sessionID, product.StoreID).
+		Count(&otherStore).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カートの取得に失敗しました"})
+		return
+	}
+	if otherStore > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "別の店舗の商品がカートに入っています"})
+		return
+	}
+
+	// 品切れ・在庫不足の商品は追加できない
+	if !checkCartStock(c, db, sessionID, product, req.Quantity, 0) {
+		return
+	}
+
+	// 同じ商品・同じオプションが既にカートにある場合は数量を更新
 	var existingItem models.CartItem
-	if err := db.Where("session_id = ? AND product_id = ?", sessionID, req.ProductID).First(&existingItem).Error; err == nil {
+	if err := db.Where("session_id = ? AND product_id = ? AND option_key = ?", sessionID, req.ProductID, key).First(&existingItem).Error; err == nil {
 		existingItem.Quantity += req.Quantity
 		db.Save(&existingItem)
-		db.Preload("Product").First(&existingItem, existingItem.ID)
+		preloadCartItems(db).First(&existingItem, existingItem.ID)
+		priceCartItem(&existingItem)
 		c.JSON(http.StatusOK, gin.H{
 			"message": "カートを更新しました",
 			"item":    existingItem,
@@ -71,8 +104,13 @@ func AddToCart(c *gin.Context, db *gorm.DB) {
 	// 新規追加
 	cartItem := models.CartItem{
 		SessionID: sessionID,
+		StoreID:   product.StoreID,
 		ProductID: req.ProductID,
 		Quantity:  req.Quantity,
+		OptionKey: key,
+	}
+	for _, option := range options {
+		cartItem.Options = append(cartItem.Options, models.CartItemOption{OptionID: option.OptionID})
 	}
 
 	if err := db.Create(&cartItem).Error; err != nil {
@@ -80,7 +118,8 @@ func AddToCart(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	db.Preload("Product").First(&cartItem, cartItem.ID)
+	preloadCartItems(db).First(&cartItem, cartItem.ID)
+	priceCartItem(&cartItem)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "カートに追加しました",
@@ -88,28 +127,71 @@ func AddToCart(c *gin.Context, db *gorm.DB) {
 	})
 }
 
+// checkCartStock カートに入れる数量が在庫内か確認（カート内の他の行や、同じ食材を使う商品も合算する）
+// 商品のレシピは事前に読み込んでおく。品切れ・在庫不足の場合はレスポンスを返して false を返す
+func checkCartStock(c *gin.Context, db *gorm.DB, sessionID string, product models.Product, quantity int, excludeItemID uint) bool {
+	if product.SoldOut {
+		c.JSON(http.StatusConflict, gin.H{"error": "品切れです"})
+		return false
+	}
+	if !product.TrackStock && len(product.Recipe) == 0 {
+		return true
+	}
+
+	// カート内の他の行が確保する分を差し引く
+	var others []models.CartItem
+	db.Where("session_id = ? AND id <> ?", sessionID, excludeItemID).
+		Preload("Product.Recipe.Ingredient").
+		Find(&others)
+	checker := newStockChecker()
+	for _, item := range others {
+		checker.take(item.Product, item.Quantity)
+	}
+
+	units, limited := checker.available(product)
+	if !limited || quantity <= units {
+		return true
+	}
+	if units == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "品切れです", "available": 0})
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "在庫が不足しています", "available": units})
+	return false
+}
+
 // GetCart カート内容を取得
 func GetCart(c *gin.Context, db *gorm.DB) {
 	sessionID := GetOrCreateSessionID(c)
 
 	var cartItems []models.CartItem
-	if err := db.Where("session_id = ?", sessionID).
-		Preload("Product").
+	if err := preloadCartItems(db).Where("session_id = ?", sessionID).
 		Find(&cartItems).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "カートの取得に失敗しました"})
 		return
 	}
+	priceCartItems(cartItems)
 
-	// 合計金額を計算
-	totalPrice := 0
-	for _, item := range cartItems {
-		totalPrice += item.Product.Price * item.Quantity
+	// 店内飲食か持ち帰りかで税率が変わる
+	diningOption, ok := diningOptionOrDefault(c.Query("dining_option"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な飲食の区分です"})
+		return
+	}
+
+	// 合計金額と税額を計算
+	tax, err := cartTax(db, cartItems, diningOption)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "税額の計算に失敗しました"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"items":       cartItems,
-		"total_price": totalPrice,
-		"item_count":  len(cartItems),
+		"items":         cartItems,
+		"total_price":   tax.Total,
+		"item_count":    len(cartItems),
+		"dining_option": diningOption,
+		"tax":           tax,
 	})
 }
 
@@ -131,14 +213,18 @@ func UpdateCartItem(c *gin.Context, db *gorm.DB) {
 	}
 
 	var cartItem models.CartItem
-	if err := db.Where("id = ? AND session_id = ?", itemID, sessionID).First(&cartItem).Error; err != nil {
+	if err := db.Where("id = ? AND session_id = ?", itemID, sessionID).Preload("Product.Recipe.Ingredient").First(&cartItem).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "カート内に商品が見つかりません"})
 		return
 	}
+	if req.Quantity > cartItem.Quantity && !checkCartStock(c, db, sessionID, cartItem.Product, req.Quantity, cartItem.ID) {
+		return
+	}
 
 	cartItem.Quantity = req.Quantity
 	db.Save(&cartItem)
-	db.Preload("Product").First(&cartItem, cartItem.ID)
+	preloadCartItems(db).First(&cartItem, cartItem.ID)
+	priceCartItem(&cartItem)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "数量を更新しました",
@@ -161,7 +247,13 @@ func RemoveFromCart(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	if err := db.Delete(&cartItem).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_item_id = ?", cartItem.ID).Delete(&models.CartItemOption{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&cartItem).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "削除に失敗しました"})
 		return
 	}
@@ -170,27 +262,42 @@ func RemoveFromCart(c *gin.Context, db *gorm.DB) {
 }
 
 // CheckoutCart カート内商品をまとめて注文（ゲスト購入対応）
-func CheckoutCart(c *gin.Context, db *gorm.DB) {
+func CheckoutCart(c *gin.Context, db *gorm.DB, signer *qrtoken.Signer, spooler *printing.Spooler) {
 	session := sessions.Default(c)
 	sessionID := GetOrCreateSessionID(c)
 
-	// リクエストボディからテーブルIDを取得（オプショナル）
+	// テーブルはQRコードのトークンから特定する（オプショナル）
 	var req struct {
-		TableID *uint `json:"table_id"`
+		TableID      *uint  `json:"table_id"`
+		TableToken   string `json:"table_token"`
+		DiningOption string `json:"dining_option"` // eat_in（デフォルト）または takeout
+		AllowPartial bool   `json:"allow_partial"` // true の場合は在庫が足りない商品をカートに残して残りを注文する
 	}
 	// リクエストボディがない場合もエラーにしない
 	c.ShouldBindJSON(&req)
 
-	// テーブルIDが指定されている場合、テーブルが存在するか確認
-	if req.TableID != nil {
-		var table models.Table
-		if err := db.First(&table, *req.TableID).Error; err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "指定されたテーブルが見つかりません"})
-			return
-		}
-		// テーブルがアクティブかチェック
-		if table.Status != "active" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "指定されたテーブルは現在使用できません"})
+	tableToken := req.TableToken
+	if tableToken == "" {
+		tableToken = tableTokenFromRequest(c)
+	}
+	if tableToken == "" && req.TableID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "テーブルはQRコードから指定してください"})
+		return
+	}
+	diningOption, ok := diningOptionOrDefault(req.DiningOption)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な飲食の区分です"})
+		return
+	}
+
+	// トークンが指定されている場合、テーブルと来店セッションを検証
+	var table *models.Table
+	var tableSession *models.TableSession
+	if tableToken != "" {
+		var err error
+		table, tableSession, err = resolveTableToken(db, signer, tableToken)
+		if err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 			return
 		}
 	}
@@ -206,6 +313,7 @@ func CheckoutCart(c *gin.Context, db *gorm.DB) {
 	var cartItems []models.CartItem
 	if err := db.Where("session_id = ?", sessionID).
 		Preload("Product").
+		Preload("Options").
 		Find(&cartItems).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "カートの取得に失敗しました"})
 		return
@@ -216,6 +324,20 @@ func CheckoutCart(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
+	// 注文先はカートの商品の店舗（テーブル注文の場合はテーブルも同じ店舗のもの）
+	storeID := cartItems[0].StoreID
+	if table != nil && table.StoreID != storeID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このテーブルでは注文できない商品がカートに入っています"})
+		return
+	}
+
+	// 価格の税込・税抜は店舗の設定に従う
+	includesTax, err := pricesIncludeTax(db, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗情報の取得に失敗しました"})
+		return
+	}
+
 	// トランザクション開始
 	tx := db.Begin()
 	defer func() {
@@ -224,32 +346,99 @@ func CheckoutCart(c *gin.Context, db *gorm.DB) {
 		}
 	}()
 
-	var orders []models.Order
-	totalAmount := 0
-
-	// カート内の各商品を注文に変換
+	// カート全体を1つの注文（明細行付き）に変換
+	order := models.Order{
+		StoreID:          storeID,
+		UserID:           userID, // nilでもOK（ゲスト購入）
+		Status:           models.OrderStatusReceived,
+		DiningOption:     diningOption,
+		PriceIncludesTax: includesTax,
+	}
+	// テーブル注文は現在の来店セッションに紐づける
+	if table != nil {
+		order.TableID = &table.ID
+		order.TableSessionID = &tableSession.ID
+	}
+	// 選択オプションは注文時点の内容で検証し直す（品切れ・削除された場合は注文できない）
+	products := make(map[uint]models.Product)
+	stock := newStockChecker()
+	var shortages []StockShortage
+	var orderedIDs []uint
 	for _, item := range cartItems {
-		order := models.Order{
-			UserID:     userID,      // nilでもOK（ゲスト購入）
-			ProductID:  item.ProductID,
-			Quantity:   item.Quantity,
-			TotalPrice: item.Product.Price * item.Quantity,
-			Status:     "pending",
-			TableID:    req.TableID, // テーブルID（オプショナル）
+		product, ok := products[item.ProductID]
+		if !ok {
+			product, err = loadProductForOrder(tx, item.ProductID)
+			if err != nil || product.StoreID != storeID {
+				tx.Rollback()
+				c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+				return
+			}
+			products[item.ProductID] = product
 		}
-
-		if err := tx.Create(&order).Error; err != nil {
+		options, msg := selectOptions(product, item.OptionIDs())
+		if msg != "" {
 			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の作成に失敗しました"})
+			c.JSON(http.StatusConflict, gin.H{"error": msg, "cart_item_id": item.ID})
 			return
 		}
+		if available, ok := stock.take(product, item.Quantity); !ok {
+			cartItemID := item.ID
+			shortages = append(shortages, StockShortage{
+				ProductID:  product.ID,
+				Name:       product.Name,
+				Requested:  item.Quantity,
+				Available:  available,
+				CartItemID: &cartItemID,
+			})
+			continue
+		}
+		order.Items = append(order.Items, newOrderItem(product, item.Quantity, diningOption, options))
+		orderedIDs = append(orderedIDs, item.ID)
+	}
+	if len(shortages) > 0 && (!req.AllowPartial || len(order.Items) == 0) {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "在庫が不足している商品があります", "shortages": shortages})
+		return
+	}
+	applyOrderTax(&order)
+
+	// 分割会計の支払いが始まったテーブルには注文を追加しない
+	if err := ensureSplitPaymentNotStarted(tx, order.TableSessionID); err != nil {
+		tx.Rollback()
+		if errors.Is(err, errSplitPaymentStarted) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の作成に失敗しました"})
+		return
+	}
+
+	if err := tx.Create(&order).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の作成に失敗しました"})
+		return
+	}
+	if err := recordOrderStatusEvent(tx, order.ID, nil, "", order.Status, actorFromSession(c), "注文作成"); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の作成に失敗しました"})
+		return
+	}
 
-		totalAmount += order.TotalPrice
-		orders = append(orders, order)
+	// 在庫を減らす（確認後に他の注文で在庫が減っていた場合は注文しない）
+	changed, err := deductStock(tx, &order, actorFromSession(c))
+	if err != nil {
+		tx.Rollback()
+		var shortage *stockShortageError
+		if errors.As(err, &shortage) {
+			c.JSON(http.StatusConflict, gin.H{"error": "在庫が不足している商品があります", "shortages": shortage.Shortages})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "在庫の更新に失敗しました"})
+		return
 	}
 
-	// カートをクリア
-	if err := tx.Where("session_id = ?", sessionID).Delete(&models.CartItem{}).Error; err != nil {
+	// 注文した商品をカートから削除（在庫不足で注文しなかった商品は残す）
+	if err := deleteCartItems(tx, sessionID, orderedIDs); err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "カートのクリアに失敗しました"})
 		return
@@ -261,19 +450,53 @@ func CheckoutCart(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	db.Preload("Items.Product").Preload("Items.Options").First(&order, order.ID)
+	order.Tax = orderTax(order)
+	events.Publish(order.StoreID, events.OrderCreated, order)
+	publishStockChanges(db, changed)
+	if err := queueKitchenTickets(db, spooler, order); err != nil {
+		log.Printf("調理伝票の印刷ジョブの登録に失敗しました（注文 #%d）: %v", order.ID, err)
+	}
+
+	response := gin.H{
 		"message":      "注文が完了しました",
-		"orders":       orders,
-		"total_amount": totalAmount,
-		"order_count":  len(orders),
-	})
+		"order":        order,
+		"total_amount": order.TotalPrice,
+		"item_count":   len(order.Items),
+		"tax":          order.Tax,
+	}
+	if len(shortages) > 0 {
+		response["message"] = "在庫が不足している商品を除いて注文しました"
+		response["shortages"] = shortages
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// clearCartItems セッションのカート内商品と選択オプションを削除
+func clearCartItems(tx *gorm.DB, sessionID string) error {
+	itemIDs := tx.Model(&models.CartItem{}).Select("id").Where("session_id = ?", sessionID)
+	if err := tx.Where("cart_item_id IN (?)", itemIDs).Delete(&models.CartItemOption{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("session_id = ?", sessionID).Delete(&models.CartItem{}).Error
+}
+
+// deleteCartItems 指定したカート内商品と選択オプションを削除
+func deleteCartItems(tx *gorm.DB, sessionID string, itemIDs []uint) error {
+	if len(itemIDs) == 0 {
+		return nil
+	}
+	if err := tx.Where("cart_item_id IN ?", itemIDs).Delete(&models.CartItemOption{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("session_id = ? AND id IN ?", sessionID, itemIDs).Delete(&models.CartItem{}).Error
 }
 
 // ClearCart カートを空にする
 func ClearCart(c *gin.Context, db *gorm.DB) {
 	sessionID := GetOrCreateSessionID(c)
 
-	if err := db.Where("session_id = ?", sessionID).Delete(&models.CartItem{}).Error; err != nil {
+	if err := clearCartItems(db, sessionID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "カートのクリアに失敗しました"})
 		return
 	}
@@ -0,0 +1,521 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CategoryHandler struct {
+	DB *gorm.DB
+}
+
+// CategoryRequest カテゴリー登録・更新リクエスト
+type CategoryRequest struct {
+	Name      string `json:"name"`
+	ParentID  *uint  `json:"parent_id"` // 0 を指定するとトップレベルに戻す
+	SortOrder *int   `json:"sort_order"`
+	Visible   *bool  `json:"visible"`
+}
+
+// ReorderRequest 並び替えリクエスト（先頭から順に表示順を振り直す）
+type ReorderRequest struct {
+	IDs []uint `json:"ids" binding:"required"`
+}
+
+// MenuProduct 公開メニューの商品
+type MenuProduct struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Price       int    `json:"price"`
+	ImagePath   string `json:"imagePath"`
+	Labels      string `json:"labels"`
+	TaxCategory string `json:"taxCategory"`
+	SoldOut     bool   `json:"soldOut"`
+
+	OptionGroups []models.OptionGroup `json:"optionGroups"` // 品切れの選択肢は含めない
+}
+
+// MenuCategory 公開メニューのカテゴリー（サブカテゴリーを含む）
+type MenuCategory struct {
+	ID       uint           `json:"id"`
+	Name     string         `json:"name"`
+	Products []MenuProduct  `json:"products"`
+	Children []MenuCategory `json:"children"`
+}
+
+// nextCategorySortOrder 同じ親を持つカテゴリーの末尾の表示順
+func nextCategorySortOrder(db *gorm.DB, storeID uint, parentID *uint) (int, error) {
+	var max *int
+	query := db.Model(&models.Category{}).Where("store_id = ?", storeID)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+	if err := query.Select("MAX(sort_order)").Scan(&max).Error; err != nil {
+		return 0, err
+	}
+	if max == nil {
+		return 0, nil
+	}
+	return *max + 1, nil
+}
+
+// nextProductSortOrder カテゴリー内の商品の末尾の表示順
+func nextProductSortOrder(db *gorm.DB, storeID uint, categoryID *uint) (int, error) {
+	var max *int
+	query := db.Model(&models.Product{}).Where("store_id = ?", storeID)
+	if categoryID == nil {
+		query = query.Where("category_id IS NULL")
+	} else {
+		query = query.Where("category_id = ?", *categoryID)
+	}
+	if err := query.Select("MAX(sort_order)").Scan(&max).Error; err != nil {
+		return 0, err
+	}
+	if max == nil {
+		return 0, nil
+	}
+	return *max + 1, nil
+}
+
+// findStoreCategory 店舗のカテゴリーを取得
+func findStoreCategory(db *gorm.DB, storeID uint, categoryID uint) (*models.Category, error) {
+	var category models.Category
+	if err := db.Where("id = ? AND store_id = ?", categoryID, storeID).First(&category).Error; err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// parseCategoryID フォームのカテゴリーIDを解析（空文字列・0 は未分類）
+func parseCategoryID(db *gorm.DB, storeID uint, value string) (*uint, string) {
+	if value == "" || value == "0" {
+		return nil, ""
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return nil, "不正なカテゴリーIDです"
+	}
+	category, err := findStoreCategory(db, storeID, uint(id))
+	if err != nil {
+		return nil, "カテゴリーが見つかりません"
+	}
+	return &category.ID, ""
+}
+
+// validateCategoryParent 親カテゴリーが自分自身やその子孫になっていないか確認
+func validateCategoryParent(db *gorm.DB, storeID uint, categoryID uint, parentID uint) string {
+	seen := make(map[uint]bool)
+	for id := parentID; ; {
+		if id == categoryID {
+			return "自分自身やサブカテゴリーを親にはできません"
+		}
+		if seen[id] {
+			return "カテゴリーの親子関係が循環しています"
+		}
+		seen[id] = true
+
+		parent, err := findStoreCategory(db, storeID, id)
+		if err != nil {
+			return "親カテゴリーが見つかりません"
+		}
+		if parent.ParentID == nil {
+			return ""
+		}
+		id = *parent.ParentID
+	}
+}
+
+// loadCategory URLのIDからカテゴリーを取得（見つからない場合はレスポンスを返す）
+func (h *CategoryHandler) loadCategory(c *gin.Context, storeID uint) (*models.Category, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なカテゴリーIDです"})
+		return nil, false
+	}
+	category, err := findStoreCategory(h.DB, storeID, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "カテゴリーが見つかりません"})
+		return nil, false
+	}
+	return category, true
+}
+
+// GetCategories 自分のカテゴリー一覧を取得（親ごとに表示順で並べる）
+func (h *CategoryHandler) GetCategories(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var categories []models.Category
+	if err := h.DB.Where("store_id = ?", storeID).
+		Order("parent_id ASC, sort_order ASC, id ASC").
+		Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+// CreateCategory カテゴリーを作成（表示順を省略した場合は末尾に追加）
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "カテゴリー名が必要です"})
+		return
+	}
+
+	category := models.Category{
+		Name:    req.Name,
+		StoreID: storeID,
+	}
+	if req.ParentID != nil && *req.ParentID != 0 {
+		if _, err := findStoreCategory(h.DB, storeID, *req.ParentID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "親カテゴリーが見つかりません"})
+			return
+		}
+		category.ParentID = req.ParentID
+	}
+	if req.SortOrder != nil {
+		category.SortOrder = *req.SortOrder
+	} else {
+		sortOrder, err := nextCategorySortOrder(h.DB, storeID, category.ParentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの作成に失敗しました"})
+			return
+		}
+		category.SortOrder = sortOrder
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&category).Error; err != nil {
+			return err
+		}
+		// default:true のため、非表示で作成する場合は作成後に更新する
+		if req.Visible != nil && !*req.Visible {
+			category.Visible = false
+			return tx.Model(&category).Update("visible", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの作成に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "カテゴリーを作成しました",
+		"category": category,
+	})
+}
+
+// UpdateCategory カテゴリーの名前・親・表示順・公開状態を更新
+func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	category, ok := h.loadCategory(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Name != "" {
+		category.Name = req.Name
+	}
+	if req.ParentID != nil {
+		if *req.ParentID == 0 {
+			category.ParentID = nil
+		} else {
+			if msg := validateCategoryParent(h.DB, storeID, category.ID, *req.ParentID); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			category.ParentID = req.ParentID
+		}
+	}
+	if req.SortOrder != nil {
+		category.SortOrder = *req.SortOrder
+	}
+	if req.Visible != nil {
+		category.Visible = *req.Visible
+	}
+
+	if err := h.DB.Model(category).Updates(map[string]interface{}{
+		"name":       category.Name,
+		"parent_id":  category.ParentID,
+		"sort_order": category.SortOrder,
+		"visible":    category.Visible,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの更新に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "カテゴリーを更新しました",
+		"category": category,
+	})
+}
+
+// DeleteCategory カテゴリーを削除（所属していた商品は未分類になる）
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	category, ok := h.loadCategory(c, storeID)
+	if !ok {
+		return
+	}
+
+	// サブカテゴリーが残っている場合は先に移動・削除してもらう
+	var children int64
+	if err := h.DB.Model(&models.Category{}).Where("parent_id = ?", category.ID).Count(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの削除に失敗しました"})
+		return
+	}
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "サブカテゴリーがあるため削除できません"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Product{}).
+			Where("category_id = ?", category.ID).
+			Update("category_id", nil).Error; err != nil {
+			return err
+		}
+		return tx.Delete(category).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "カテゴリーを削除しました"})
+}
+
+// ReorderCategories 同じ親を持つカテゴリーを指定した順に並べ替える
+func (h *CategoryHandler) ReorderCategories(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req ReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "並び順を指定してください"})
+		return
+	}
+
+	var categories []models.Category
+	if err := h.DB.Where("id IN ? AND store_id = ?", req.IDs, storeID).Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの取得に失敗しました"})
+		return
+	}
+	if len(categories) != len(req.IDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "カテゴリーが見つかりません"})
+		return
+	}
+	for _, category := range categories[1:] {
+		if !sameCategoryID(category.ParentID, categories[0].ParentID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "同じ親を持つカテゴリーのみ並べ替えできます"})
+			return
+		}
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range req.IDs {
+			if err := tx.Model(&models.Category{}).Where("id = ?", id).Update("sort_order", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "並べ替えに失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "並べ替えました"})
+}
+
+// ReorderCategoryProducts カテゴリー内の商品を指定した順に並べ替える
+func (h *CategoryHandler) ReorderCategoryProducts(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	category, ok := h.loadCategory(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req ReorderRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "並び順を指定してください"})
+		return
+	}
+
+	var count int64
+	if err := h.DB.Model(&models.Product{}).
+		Where("id IN ? AND store_id = ? AND category_id = ?", req.IDs, storeID, category.ID).
+		Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品の取得に失敗しました"})
+		return
+	}
+	if int(count) != len(req.IDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "このカテゴリーに含まれない商品が指定されています"})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for i, id := range req.IDs {
+			if err := tx.Model(&models.Product{}).Where("id = ?", id).Update("sort_order", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "並べ替えに失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "並べ替えました"})
+}
+
+// GetMenu 公開メニューをカテゴリーの階層ごとに取得（公開 - ログイン不要）
+// 非表示のカテゴリーとそのサブカテゴリー・商品は含めない
+func (h *CategoryHandler) GetMenu(c *gin.Context) {
+	username := c.Param("username")
+
+	storeID, err := userStoreID(h.DB, username)
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+		return
+	}
+
+	var categories []models.Category
+	if err := h.DB.Where("store_id = ?", storeID).
+		Order("sort_order ASC, id ASC").
+		Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "カテゴリーの取得に失敗しました"})
+		return
+	}
+
+	var products []models.Product
+	if err := h.DB.Where("store_id = ?", storeID).
+		Preload("OptionGroups", orderBySortOrder).
+		Preload("OptionGroups.Options", func(db *gorm.DB) *gorm.DB {
+			return orderBySortOrder(db.Where("available = ?", true))
+		}).
+		Preload("Recipe.Ingredient").
+		Order("sort_order ASC, id ASC").
+		Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品の取得に失敗しました"})
+		return
+	}
+
+	tree, uncategorized := buildMenuTree(categories, products)
+	c.JSON(http.StatusOK, gin.H{
+		"categories":    tree,
+		"uncategorized": uncategorized,
+	})
+}
+
+// buildMenuTree 表示順に並んだカテゴリーと商品から公開メニューの階層を組み立てる
+func buildMenuTree(categories []models.Category, products []models.Product) ([]MenuCategory, []MenuProduct) {
+	children := make(map[uint][]models.Category)
+	var roots []models.Category
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+		} else {
+			children[*category.ParentID] = append(children[*category.ParentID], category)
+		}
+	}
+
+	productsByCategory := make(map[uint][]MenuProduct)
+	uncategorized := []MenuProduct{}
+	for _, product := range products {
+		item := MenuProduct{
+			ID:          product.ID,
+			Name:        product.Name,
+			Price:       product.Price,
+			ImagePath:   product.ImagePath,
+			Labels:      product.Labels,
+			TaxCategory: product.TaxCategory,
+			SoldOut:     !productAvailable(product),
+
+			OptionGroups: product.OptionGroups,
+		}
+		if item.OptionGroups == nil {
+			item.OptionGroups = []models.OptionGroup{}
+		}
+		if product.CategoryID == nil {
+			uncategorized = append(uncategorized, item)
+		} else {
+			productsByCategory[*product.CategoryID] = append(productsByCategory[*product.CategoryID], item)
+		}
+	}
+
+	var build func(list []models.Category) []MenuCategory
+	build = func(list []models.Category) []MenuCategory {
+		result := []MenuCategory{}
+		for _, category := range list {
+			if !category.Visible {
+				continue
+			}
+			menu := MenuCategory{
+				ID:       category.ID,
+				Name:     category.Name,
+				Products: productsByCategory[category.ID],
+				Children: build(children[category.ID]),
+			}
+			if menu.Products == nil {
+				menu.Products = []MenuProduct{}
+			}
+			result = append(result, menu)
+		}
+		return result
+	}
+
+	return build(roots), uncategorized
+}
+
+// sameCategoryID 同じカテゴリーを指しているか判定（どちらも null の場合も同じとみなす）
+func sameCategoryID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// コンテキストにログイン中のユーザー・使用中のAPIトークンを保存するキー
+const (
+	currentUserKey     = "currentUser"
+	currentAPITokenKey = "currentAPIToken"
+)
+
+// APIトークンの最終使用日時を更新する間隔（リクエストごとに書き込まないようにする）
+const apiTokenTouchInterval = time.Minute
+
+// RequireLogin セッションまたはAPIトークンからログイン中のユーザーを取得してコンテキストに保存する認証ミドルウェア
+// Authorization: Bearer ヘッダーがある場合はAPIトークンのみで認証する（Cookieのセッションは使わない）
+// 未ログイン・削除済みのユーザー、無効なトークン、登録を取り消した共有端末でのPINログインの場合は401を返す
+func RequireLogin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, ok := bearerToken(c); ok {
+			authenticateAPIToken(c, db, token)
+			return
+		}
+
+		session := sessions.Default(c)
+		userID, ok := session.Get("user_id").(uint)
+		if !ok {
+			abortUnauthorized(c)
+			return
+		}
+
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			abortUnauthorized(c)
+			return
+		}
+		// 共有端末でPINログインしたセッションは、端末の登録が取り消されたら使えない
+		if terminalID, ok := session.Get("terminal_id").(uint); ok && !terminalActive(db, terminalID, &user) {
+			session.Delete("user")
+			session.Delete("user_id")
+			session.Delete("terminal_id")
+			session.Delete(twoFactorVerifiedKey)
+			session.Save()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "この端末の登録は取り消されました"})
+			return
+		}
+		c.Set(currentUserKey, &user)
+		c.Next()
+	}
+}
+
+// terminalActive 共有端末が登録済み（取り消されていない）で、ユーザーと同じ店舗のものか
+func terminalActive(db *gorm.DB, terminalID uint, user *models.User) bool {
+	var terminal models.Terminal
+	if err := db.Where("revoked_at IS NULL").First(&terminal, terminalID).Error; err != nil {
+		return false
+	}
+	return user.StoreID != nil && *user.StoreID == terminal.StoreID
+}
+
+// bearerToken Authorization ヘッダーのBearerトークンを取得
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return "", false
+	}
+	scheme, token, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// authenticateAPIToken APIトークンを検証し、発行したユーザーとしてコンテキストに保存
+func authenticateAPIToken(c *gin.Context, db *gorm.DB, token string) {
+	if token == "" {
+		abortUnauthorized(c)
+		return
+	}
+
+	var apiToken models.APIToken
+	if err := db.Where("token_hash = ?", hashSecretToken(token)).First(&apiToken).Error; err != nil {
+		abortUnauthorized(c)
+		return
+	}
+	now := time.Now()
+	if !apiToken.IsActive(now) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "APIトークンが無効または期限切れです"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, apiToken.UserID).Error; err != nil {
+		abortUnauthorized(c)
+		return
+	}
+	// 別の店舗に移ったユーザーのトークンは使えない
+	if user.StoreID == nil || *user.StoreID != apiToken.StoreID {
+		abortUnauthorized(c)
+		return
+	}
+
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= apiTokenTouchInterval {
+		db.Model(&apiToken).Update("last_used_at", now)
+		apiToken.LastUsedAt = &now
+	}
+
+	c.Set(currentUserKey, &user)
+	c.Set(currentAPITokenKey, &apiToken)
+	c.Next()
+}
+
+// RequireSession Cookieのセッションでログインしている場合のみ許可するミドルウェア
+// パスワード・PIN・APIキー・トークンの管理など、アカウントに関わる操作をAPIトークンで行えないようにする
+func RequireSession(c *gin.Context) {
+	if CurrentAPIToken(c) != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "この操作はAPIトークンでは行えません"})
+		return
+	}
+	c.Next()
+}
+
+// abortUnauthorized 未ログインのレスポンスを返して処理を中断
+func abortUnauthorized(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+}
+
+// CurrentUser ログイン中のユーザー（RequireLogin を通っていないルートでは nil）
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(currentUserKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// CurrentAPIToken 認証に使ったAPIトークン（Cookieのセッションでログインしている場合は nil）
+func CurrentAPIToken(c *gin.Context) *models.APIToken {
+	if value, ok := c.Get(currentAPITokenKey); ok {
+		if token, ok := value.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// currentUserID ログイン中のユーザーのID（RequireLogin を通ったハンドラーで使う）
+func currentUserID(c *gin.Context) uint {
+	if user := CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return 0
+}
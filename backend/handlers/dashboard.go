@@ -5,19 +5,12 @@ import (
 	"orderbase/models"
 	"time"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 func ShowDashboard(c *gin.Context) {
-	session := sessions.Default(c)
-	user := session.Get("user")
-	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-	c.JSON(http.StatusOK, gin.H{"username": user})
+	c.JSON(http.StatusOK, gin.H{"username": CurrentUser(c).Username})
 }
 
 // DashboardStats ダッシュボード統計情報のレスポンス
@@ -33,6 +26,7 @@ type DashboardStats struct {
 	PendingOrders      int64                `json:"pending_orders"`
 	CompletedOrders    int64                `json:"completed_orders"`
 	CancelledOrders    int64                `json:"cancelled_orders"`
+	RefundedOrders     int64                `json:"refunded_orders"`
 	TopProducts        []ProductStats       `json:"top_products"`
 	RecentOrders       []models.Order       `json:"recent_orders"`
 }
@@ -48,12 +42,14 @@ type ProductStats struct {
 
 // GetDashboardStats ダッシュボード統計情報を取得
 func GetDashboardStats(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
+	// 集計は店舗の注文のみ
+	orders := func() *gorm.DB {
+		return db.Model(&models.Order{}).Where("store_id = ?", storeID)
+	}
 
 	now := time.Now()
 	startOfToday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
@@ -63,63 +59,66 @@ func GetDashboardStats(c *gin.Context, db *gorm.DB) {
 	var stats DashboardStats
 
 	// 今日の売上と注文数
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfToday, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfToday, models.OrderStatusPaid).
 		Select("COALESCE(SUM(total_price), 0) as total_sales, COUNT(*) as total_orders").
 		Scan(&struct {
 			TotalSales  int   `gorm:"column:total_sales"`
 			TotalOrders int64 `gorm:"column:total_orders"`
 		}{TotalSales: stats.TodaySales, TotalOrders: stats.TodayOrders})
 
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfToday, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfToday, models.OrderStatusPaid).
 		Count(&stats.TodayOrders)
 
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfToday, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfToday, models.OrderStatusPaid).
 		Select("COALESCE(SUM(total_price), 0)").
 		Scan(&stats.TodaySales)
 
 	// 今月の売上と注文数
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfMonth, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfMonth, models.OrderStatusPaid).
 		Count(&stats.MonthOrders)
 
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfMonth, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfMonth, models.OrderStatusPaid).
 		Select("COALESCE(SUM(total_price), 0)").
 		Scan(&stats.MonthSales)
 
 	// 今年の売上と注文数
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfYear, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfYear, models.OrderStatusPaid).
 		Count(&stats.YearOrders)
 
-	db.Model(&models.Order{}).
-		Where("created_at >= ? AND status = ?", startOfYear, "completed").
+	orders().
+		Where("created_at >= ? AND status = ?", startOfYear, models.OrderStatusPaid).
 		Select("COALESCE(SUM(total_price), 0)").
 		Scan(&stats.YearSales)
 
 	// 全体の売上と注文数
-	db.Model(&models.Order{}).
-		Where("status = ?", "completed").
+	orders().
+		Where("status = ?", models.OrderStatusPaid).
 		Count(&stats.TotalOrders)
 
-	db.Model(&models.Order{}).
-		Where("status = ?", "completed").
+	orders().
+		Where("status = ?", models.OrderStatusPaid).
 		Select("COALESCE(SUM(total_price), 0)").
 		Scan(&stats.TotalSales)
 
 	// ステータス別注文数
-	db.Model(&models.Order{}).Where("status = ?", "pending").Count(&stats.PendingOrders)
-	db.Model(&models.Order{}).Where("status = ?", "completed").Count(&stats.CompletedOrders)
-	db.Model(&models.Order{}).Where("status = ?", "cancelled").Count(&stats.CancelledOrders)
-
-	// 人気商品トップ5
-	db.Model(&models.Order{}).
-		Select("product_id, SUM(quantity) as total_sold, SUM(total_price) as total_revenue").
-		Where("status = ?", "completed").
-		Group("product_id").
+	// pending は未会計（受付〜提供済み）、completed は会計済みの件数
+	orders().Where("status IN ?", models.OpenOrderStatuses).Count(&stats.PendingOrders)
+	orders().Where("status = ?", models.OrderStatusPaid).Count(&stats.CompletedOrders)
+	orders().Where("status = ?", models.OrderStatusCancelled).Count(&stats.CancelledOrders)
+	orders().Where("status = ?", models.OrderStatusRefunded).Count(&stats.RefundedOrders)
+
+	// 人気商品トップ5（会計済みの注文の明細行から集計）
+	db.Model(&models.OrderItem{}).
+		Select("order_items.product_id, SUM(order_items.quantity) as total_sold, SUM(order_items.line_total) as total_revenue").
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.store_id = ? AND orders.status = ? AND order_items.status <> ?", storeID, models.OrderStatusPaid, models.OrderStatusCancelled).
+		Group("order_items.product_id").
 		Order("total_sold DESC").
 		Limit(5).
 		Scan(&stats.TopProducts)
@@ -134,7 +133,9 @@ func GetDashboardStats(c *gin.Context, db *gorm.DB) {
 	}
 
 	// 最近の注文10件
-	db.Preload("Product").
+	db.Where("store_id = ?", storeID).
+		Preload("Items.Product").
+		Preload("Items.Options").
 		Preload("User").
 		Order("created_at DESC").
 		Limit(10).
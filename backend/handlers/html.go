@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"orderbase/models"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
@@ -16,11 +15,10 @@ type HTMLHandler struct {
 
 // HTMLページをデータベースに保存
 func (h *HTMLHandler) SaveHTMLPage(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	username := session.Get("user")
-	if userID == nil || username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
+	userID := currentUserID(c)
+	username := CurrentUser(c).Username
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -28,7 +26,7 @@ func (h *HTMLHandler) SaveHTMLPage(c *gin.Context) {
 	pageName := c.Param("page")
 
 	// URLのユーザー名とセッションのユーザー名が一致するか確認
-	if urlUsername != username.(string) {
+	if urlUsername != username {
 		c.JSON(http.StatusForbidden, gin.H{"error": "他のユーザーのページは保存できません"})
 		return
 	}
@@ -46,16 +44,17 @@ func (h *HTMLHandler) SaveHTMLPage(c *gin.Context) {
 
 	htmlContent := string(body)
 
-	// 既存のページがあるか確認（同じユーザーのページのみ）
+	// 既存のページがあるか確認（同じ店舗のページのみ）
 	var existingPage models.HTMLPage
-	result := h.DB.Where("name = ? AND user_id = ?", pageName, userID).First(&existingPage)
+	result := h.DB.Where("name = ? AND store_id = ?", pageName, storeID).First(&existingPage)
 
 	if result.Error == gorm.ErrRecordNotFound {
 		// 新規作成
 		newPage := models.HTMLPage{
 			Name:    pageName,
 			Content: htmlContent,
-			UserID:  userID.(uint),
+			StoreID: storeID,
+			UserID:  userID,
 		}
 		if err := h.DB.Create(&newPage).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存失敗"})
@@ -63,7 +62,7 @@ func (h *HTMLHandler) SaveHTMLPage(c *gin.Context) {
 		}
 		c.JSON(http.StatusOK, gin.H{"message": "保存成功", "id": newPage.ID})
 	} else {
-		// 既存ページを更新（自分のページのみ）
+		// 既存ページを更新（自分の店舗のページのみ）
 		existingPage.Content = htmlContent
 		if err := h.DB.Save(&existingPage).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
@@ -75,11 +74,9 @@ func (h *HTMLHandler) SaveHTMLPage(c *gin.Context) {
 
 // HTMLページを取得（API用）
 func (h *HTMLHandler) GetHTMLPage(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	username := session.Get("user")
-	if userID == nil || username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
+	username := CurrentUser(c).Username
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -87,7 +84,7 @@ func (h *HTMLHandler) GetHTMLPage(c *gin.Context) {
 	pageName := c.Param("page")
 
 	// URLのユーザー名とセッションのユーザー名が一致するか確認
-	if urlUsername != username.(string) {
+	if urlUsername != username {
 		c.JSON(http.StatusForbidden, gin.H{"error": "他のユーザーのページにアクセスできません"})
 		return
 	}
@@ -98,7 +95,7 @@ func (h *HTMLHandler) GetHTMLPage(c *gin.Context) {
 	}
 
 	var page models.HTMLPage
-	if err := h.DB.Where("name = ? AND user_id = ?", pageName, userID).First(&page).Error; err != nil {
+	if err := h.DB.Where("name = ? AND store_id = ?", pageName, storeID).First(&page).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "ページが見つかりません"})
 			return
@@ -120,9 +117,9 @@ func (h *HTMLHandler) RenderHTMLPage(c *gin.Context) {
 		return
 	}
 
-	// ユーザー名からユーザーIDを取得
-	var user models.User
-	if err := h.DB.Where("username = ?", urlUsername).First(&user).Error; err != nil {
+	// ユーザー名から店舗を取得
+	storeID, err := userStoreID(h.DB, urlUsername)
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.String(http.StatusNotFound, "ユーザーが見つかりません")
 			return
@@ -133,7 +130,7 @@ func (h *HTMLHandler) RenderHTMLPage(c *gin.Context) {
 
 	// ページを取得
 	var page models.HTMLPage
-	if err := h.DB.Where("name = ? AND user_id = ?", pageName, user.ID).First(&page).Error; err != nil {
+	if err := h.DB.Where("name = ? AND store_id = ?", pageName, storeID).First(&page).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.String(http.StatusNotFound, "ページが見つかりません")
 			return
@@ -152,15 +149,13 @@ func (h *HTMLHandler) RenderHTMLPage(c *gin.Context) {
 
 // 全HTMLページのリストを取得
 func (h *HTMLHandler) ListHTMLPages(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	var pages []models.HTMLPage
-	if err := h.DB.Where("user_id = ?", userID).Select("id, name, created_at, updated_at, user_id").Find(&pages).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).Select("id, name, created_at, updated_at, store_id, user_id").Find(&pages).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "取得失敗"})
 		return
 	}
@@ -170,11 +165,9 @@ func (h *HTMLHandler) ListHTMLPages(c *gin.Context) {
 
 // HTMLページを削除
 func (h *HTMLHandler) DeleteHTMLPage(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	username := session.Get("user")
-	if userID == nil || username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
+	username := CurrentUser(c).Username
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -182,7 +175,7 @@ func (h *HTMLHandler) DeleteHTMLPage(c *gin.Context) {
 	pageName := c.Param("page")
 
 	// URLのユーザー名とセッションのユーザー名が一致するか確認
-	if urlUsername != username.(string) {
+	if urlUsername != username {
 		c.JSON(http.StatusForbidden, gin.H{"error": "他のユーザーのページは削除できません"})
 		return
 	}
@@ -192,9 +185,9 @@ func (h *HTMLHandler) DeleteHTMLPage(c *gin.Context) {
 		return
 	}
 
-	// ページが存在し、かつユーザーの所有物であることを確認
+	// ページが存在し、かつ自分の店舗のページであることを確認
 	var page models.HTMLPage
-	if err := h.DB.Where("name = ? AND user_id = ?", pageName, userID).First(&page).Error; err != nil {
+	if err := h.DB.Where("name = ? AND store_id = ?", pageName, storeID).First(&page).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "ページが見つかりません"})
 			return
@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"orderbase/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// quantityEpsilon 食材の量の比較で無視する誤差（DB上は小数第6位で丸める）
+const quantityEpsilon = 1e-6
+
+type IngredientHandler struct {
+	DB *gorm.DB
+}
+
+// IngredientRequest 食材の登録・更新リクエスト
+type IngredientRequest struct {
+	Name         string   `json:"name"`
+	Unit         string   `json:"unit"`
+	OnHand       *float64 `json:"on_hand"` // 登録時のみ（以降は在庫調整で変更する）
+	ReorderLevel *float64 `json:"reorder_level"`
+}
+
+// IngredientStockRequest 食材の在庫調整リクエスト
+// on_hand（在庫量を指定）と delta（入荷・廃棄などの増減）はどちらか一方のみ指定する
+type IngredientStockRequest struct {
+	OnHand *float64 `json:"on_hand"`
+	Delta  *float64 `json:"delta"`
+	Note   string   `json:"note"`
+}
+
+// RecipeRequest レシピの設定リクエスト（指定した内容で置き換える）
+type RecipeRequest struct {
+	Items []struct {
+		IngredientID uint    `json:"ingredient_id" binding:"required"`
+		Quantity     float64 `json:"quantity" binding:"required,gt=0"`
+	} `json:"items" binding:"dive"`
+}
+
+// roundQuantity 食材の量を小数第6位で丸める
+func roundQuantity(q float64) float64 {
+	return math.Round(q*1e6) / 1e6
+}
+
+// moveIngredient 食材の在庫量を増減して台帳に記録する（在庫量が0未満になる場合は errInsufficientStock）
+// 注文での使用（sale）は提供済みの実際の使用量なので、在庫量が0未満になっても記録する
+func moveIngredient(tx *gorm.DB, ingredientID uint, delta float64, reason string, orderID, itemID *uint, actor statusActor, note string) (*models.IngredientMovement, error) {
+	delta = roundQuantity(delta)
+	query := tx.Model(&models.Ingredient{}).Where("id = ?", ingredientID)
+	if delta < 0 && reason != models.StockReasonSale {
+		query = query.Where("ROUND(on_hand + ?, 6) >= 0", delta)
+	}
+	result := query.Update("on_hand", gorm.Expr("ROUND(on_hand + ?, 6)", delta))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInsufficientStock
+	}
+
+	var ingredient models.Ingredient
+	if err := tx.Select("id", "on_hand").First(&ingredient, ingredientID).Error; err != nil {
+		return nil, err
+	}
+	movement := models.IngredientMovement{
+		IngredientID:  ingredientID,
+		Delta:         delta,
+		OnHandAfter:   ingredient.OnHand,
+		Reason:        reason,
+		OrderID:       orderID,
+		OrderItemID:   itemID,
+		Note:          note,
+		ChangedBy:     actor.UserID,
+		ChangedByName: actor.Name,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// consumeIngredients 提供・会計した明細の商品のレシピに従って食材を使用する
+// 台帳で使用済みの明細と取り消された明細は対象外のため、二重に使用することはない
+func consumeIngredients(tx *gorm.DB, items []models.OrderItem, actor statusActor) error {
+	for _, item := range items {
+		if item.Status == models.OrderStatusCancelled {
+			continue
+		}
+		var used int64
+		if err := tx.Model(&models.IngredientMovement{}).
+			Where("order_item_id = ? AND reason = ?", item.ID, models.StockReasonSale).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			continue
+		}
+
+		var recipe []models.RecipeItem
+		if err := tx.Where("product_id = ?", item.ProductID).Find(&recipe).Error; err != nil {
+			return err
+		}
+		for _, r := range recipe {
+			if _, err := moveIngredient(tx, r.IngredientID, -r.Quantity*float64(item.Quantity), models.StockReasonSale, &item.OrderID, &item.ID, actor, ""); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreIngredients 取り消された明細で使用した食材を戻す（台帳で使用済みの量だけ戻す）
+func restoreIngredients(tx *gorm.DB, items []models.OrderItem, actor statusActor, note string) error {
+	for _, item := range items {
+		var used []struct {
+			IngredientID uint
+			Net          float64
+		}
+		if err := tx.Model(&models.IngredientMovement{}).
+			Select("ingredient_id, SUM(delta) AS net").
+			Where("order_item_id = ?", item.ID).
+			Group("ingredient_id").
+			Scan(&used).Error; err != nil {
+			return err
+		}
+		for _, u := range used {
+			if roundQuantity(u.Net) >= 0 {
+				continue
+			}
+			if _, err := moveIngredient(tx, u.IngredientID, -u.Net, models.StockReasonCancel, &item.OrderID, &item.ID, actor, note); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// productsUsingIngredients 食材をレシピに含む商品のID
+func productsUsingIngredients(db *gorm.DB, ingredientIDs []uint) ([]uint, error) {
+	if len(ingredientIDs) == 0 {
+		return nil, nil
+	}
+	var productIDs []uint
+	err := db.Model(&models.RecipeItem{}).
+		Distinct("product_id").
+		Where("ingredient_id IN ?", ingredientIDs).
+		Pluck("product_id", &productIDs).Error
+	return productIDs, err
+}
+
+// loadIngredient URLのIDから店舗の食材を取得
+func (h *IngredientHandler) loadIngredient(c *gin.Context, storeID uint) (*models.Ingredient, bool) {
+	var ingredient models.Ingredient
+	if err := h.DB.Where("id = ? AND store_id = ?", c.Param("id"), storeID).First(&ingredient).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "食材が見つかりません"})
+		return nil, false
+	}
+	return &ingredient, true
+}
+
+// GetIngredients 自分の食材の一覧を取得
+func (h *IngredientHandler) GetIngredients(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var ingredients []models.Ingredient
+	if err := h.DB.Where("store_id = ?", storeID).Order("name ASC, id ASC").Find(&ingredients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ingredients": ingredients})
+}
+
+// CreateIngredient 食材を登録（初期在庫は台帳に記録する）
+func (h *IngredientHandler) CreateIngredient(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req IngredientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Name == "" || req.Unit == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "食材名と単位が必要です"})
+		return
+	}
+	if (req.OnHand != nil && *req.OnHand < 0) || (req.ReorderLevel != nil && *req.ReorderLevel < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫量と発注点は0以上で指定してください"})
+		return
+	}
+
+	ingredient := models.Ingredient{
+		StoreID: storeID,
+		Name:    req.Name,
+		Unit:    req.Unit,
+	}
+	if req.ReorderLevel != nil {
+		ingredient.ReorderLevel = roundQuantity(*req.ReorderLevel)
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ingredient).Error; err != nil {
+			return err
+		}
+		if req.OnHand == nil || *req.OnHand == 0 {
+			return nil
+		}
+		movement, err := moveIngredient(tx, ingredient.ID, *req.OnHand, models.StockReasonAdjust, nil, nil, actorFromSession(c), "初期在庫")
+		if err != nil {
+			return err
+		}
+		ingredient.OnHand = movement.OnHandAfter
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の登録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "食材を登録しました",
+		"ingredient": ingredient,
+	})
+}
+
+// UpdateIngredient 食材の名前・単位・発注点を更新
+func (h *IngredientHandler) UpdateIngredient(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ingredient, ok := h.loadIngredient(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req IngredientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.OnHand != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫量は在庫調整で変更してください"})
+		return
+	}
+	if req.Name != "" {
+		ingredient.Name = req.Name
+	}
+	if req.Unit != "" {
+		ingredient.Unit = req.Unit
+	}
+	if req.ReorderLevel != nil {
+		if *req.ReorderLevel < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "在庫量と発注点は0以上で指定してください"})
+			return
+		}
+		ingredient.ReorderLevel = roundQuantity(*req.ReorderLevel)
+	}
+
+	if err := h.DB.Model(ingredient).Updates(map[string]interface{}{
+		"name":          ingredient.Name,
+		"unit":          ingredient.Unit,
+		"reorder_level": ingredient.ReorderLevel,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の更新に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "食材を更新しました",
+		"ingredient": ingredient,
+	})
+}
+
+// DeleteIngredient 食材を削除（レシピで使用中の場合は削除できない）
+func (h *IngredientHandler) DeleteIngredient(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ingredient, ok := h.loadIngredient(c, storeID)
+	if !ok {
+		return
+	}
+
+	var used int64
+	if err := h.DB.Model(&models.RecipeItem{}).Where("ingredient_id = ?", ingredient.ID).Count(&used).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の削除に失敗しました"})
+		return
+	}
+	if used > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "レシピで使用中の食材は削除できません"})
+		return
+	}
+
+	if err := h.DB.Delete(ingredient).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "食材を削除しました"})
+}
+
+// AdjustIngredientStock 入荷・棚卸し・廃棄などで食材の在庫量を調整する（台帳に記録する）
+func (h *IngredientHandler) AdjustIngredientStock(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	ingredient, ok := h.loadIngredient(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req IngredientStockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if (req.OnHand == nil) == (req.Delta == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "on_hand と delta のどちらか一方を指定してください"})
+		return
+	}
+
+	delta := 0.0
+	if req.OnHand != nil {
+		delta = *req.OnHand - ingredient.OnHand
+	} else {
+		delta = *req.Delta
+	}
+	if roundQuantity(delta) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "在庫量は変わりません", "ingredient": ingredient})
+		return
+	}
+
+	var movement *models.IngredientMovement
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		movement, err = moveIngredient(tx, ingredient.ID, delta, models.StockReasonAdjust, nil, nil, actorFromSession(c), req.Note)
+		return err
+	})
+	if errors.Is(err, errInsufficientStock) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫量が0未満になります"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "在庫の更新に失敗しました"})
+		return
+	}
+	ingredient.OnHand = movement.OnHandAfter
+
+	// 食材を使う商品の注文可否が変わる
+	if productIDs, err := productsUsingIngredients(h.DB, []uint{ingredient.ID}); err == nil {
+		publishStockChanges(h.DB, productIDs)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "在庫を更新しました",
+		"ingredient": ingredient,
+		"movement":   movement,
+	})
+}
+
+// GetIngredientMovements 食材の在庫台帳を新しい順に取得（?ingredient_id= で食材を絞り込む）
+func (h *IngredientHandler) GetIngredientMovements(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	limit := stockMovementsDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不正な件数です"})
+			return
+		}
+		if n > stockMovementsMaxLimit {
+			n = stockMovementsMaxLimit
+		}
+		limit = n
+	}
+
+	query := h.DB.Joins("JOIN ingredients ON ingredients.id = ingredient_movements.ingredient_id").
+		Where("ingredients.store_id = ?", storeID)
+	if v := c.Query("ingredient_id"); v != "" {
+		query = query.Where("ingredient_movements.ingredient_id = ?", v)
+	}
+
+	var movements []models.IngredientMovement
+	if err := query.Order("ingredient_movements.id DESC").
+		Limit(limit).
+		Find(&movements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "在庫台帳の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"movements": movements})
+}
+
+// GetLowStockReport 発注点を下回った食材と、食材不足で注文できない商品の一覧
+func (h *IngredientHandler) GetLowStockReport(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var ingredients []models.Ingredient
+	if err := h.DB.Where("store_id = ? AND on_hand <= reorder_level", storeID).
+		Order("on_hand - reorder_level ASC, name ASC").
+		Find(&ingredients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "食材の取得に失敗しました"})
+		return
+	}
+
+	reorder := make([]gin.H, 0, len(ingredients))
+	for _, i := range ingredients {
+		reorder = append(reorder, gin.H{
+			"ingredient_id": i.ID,
+			"name":          i.Name,
+			"unit":          i.Unit,
+			"on_hand":       i.OnHand,
+			"reorder_level": i.ReorderLevel,
+			"shortfall":     roundQuantity(i.ReorderLevel - i.OnHand), // 発注点まで戻すのに必要な量
+		})
+	}
+
+	var products []models.Product
+	if err := h.DB.Where("store_id = ?", storeID).
+		Preload("Recipe.Ingredient").
+		Order("sort_order ASC, id ASC").
+		Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品取得失敗"})
+		return
+	}
+	unavailable := make([]gin.H, 0)
+	for _, p := range products {
+		if productAvailable(p) {
+			continue
+		}
+		unavailable = append(unavailable, gin.H{
+			"product_id": p.ID,
+			"name":       p.Name,
+			"sold_out":   p.SoldOut,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reorder":              reorder,
+		"unavailable_products": unavailable,
+	})
+}
+
+// GetRecipe 商品のレシピを取得
+func (h *IngredientHandler) GetRecipe(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	product, err := findOwnedProduct(h.DB, storeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+		return
+	}
+
+	var recipe []models.RecipeItem
+	if err := h.DB.Where("product_id = ?", product.ID).
+		Preload("Ingredient").
+		Order("id ASC").
+		Find(&recipe).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "レシピの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product_id": product.ID, "recipe": recipe})
+}
+
+// SetRecipe 商品のレシピを置き換える（空にするとレシピなし）
+func (h *IngredientHandler) SetRecipe(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	product, err := findOwnedProduct(h.DB, storeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+		return
+	}
+
+	var req RecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "数量は0より大きい値で指定してください"})
+		return
+	}
+
+	recipe := make([]models.RecipeItem, 0, len(req.Items))
+	seen := make(map[uint]bool)
+	for _, item := range req.Items {
+		if seen[item.IngredientID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "同じ食材が重複しています"})
+			return
+		}
+		seen[item.IngredientID] = true
+
+		var ingredient models.Ingredient
+		if err := h.DB.Where("id = ? AND store_id = ?", item.IngredientID, storeID).First(&ingredient).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "食材が見つかりません", "ingredient_id": item.IngredientID})
+			return
+		}
+		recipe = append(recipe, models.RecipeItem{
+			ProductID:    product.ID,
+			IngredientID: ingredient.ID,
+			Quantity:     roundQuantity(item.Quantity),
+			Ingredient:   ingredient,
+		})
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.RecipeItem{}).Error; err != nil {
+			return err
+		}
+		if len(recipe) == 0 {
+			return nil
+		}
+		return tx.Omit("Ingredient").Create(&recipe).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "レシピの保存に失敗しました"})
+		return
+	}
+	publishStockStatus(h.DB, product.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "レシピを保存しました",
+		"product_id": product.ID,
+		"recipe":     recipe,
+	})
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"orderbase/models"
+	"orderbase/payments"
+	"orderbase/payments/paymenttest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// addRecipeProduct 1つあたり perUnit の食材を使う商品と、在庫量 onHand の食材を作成
+func (f *checkoutFixture) addRecipeProduct(t *testing.T, onHand, perUnit float64) (models.Product, models.Ingredient) {
+	t.Helper()
+	ingredient := models.Ingredient{StoreID: f.store.ID, Name: "麺", Unit: "玉", OnHand: onHand}
+	mustCreate(t, f.db, &ingredient)
+	product := models.Product{StoreID: f.store.ID, Name: "ラーメン", Price: 800}
+	mustCreate(t, f.db, &product)
+	mustCreate(t, f.db, &models.RecipeItem{ProductID: product.ID, IngredientID: ingredient.ID, Quantity: perUnit})
+	return product, ingredient
+}
+
+func (f *checkoutFixture) ingredientOnHand(t *testing.T, id uint) float64 {
+	t.Helper()
+	var ingredient models.Ingredient
+	if err := f.db.First(&ingredient, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return ingredient.OnHand
+}
+
+func (f *checkoutFixture) expectOnHand(t *testing.T, id uint, want float64, when string) {
+	t.Helper()
+	if got := f.ingredientOnHand(t, id); math.Abs(got-want) > quantityEpsilon {
+		t.Errorf("%s: on_hand = %v, want %v", when, got, want)
+	}
+}
+
+func TestIngredientsConsumedWhenServedAndRestoredOnCancel(t *testing.T) {
+	f := newCheckoutFixture(t)
+	product, ingredient := f.addRecipeProduct(t, 10, 2)
+	order := f.addOrder(t, 800)
+	second := models.OrderItem{OrderID: order.ID, ProductID: product.ID, Quantity: 2, UnitPrice: 800, LineTotal: 1600, TaxRate: 10, Status: models.OrderStatusReceived}
+	mustCreate(t, f.db, &second)
+	if err := f.db.Model(&order.Items[0]).Update("product_id", product.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	f.expectOnHand(t, ingredient.ID, 10, "注文時")
+
+	orderPath := "/orders/" + strconv.FormatUint(uint64(order.ID), 10)
+	itemPath := orderPath + "/items/" + strconv.FormatUint(uint64(order.Items[0].ID), 10) + "/status"
+	if w := f.request(t, http.MethodPatch, itemPath, gin.H{"status": models.OrderStatusServed}); w.Code != http.StatusOK {
+		t.Fatalf("item status = %d, body = %s", w.Code, w.Body)
+	}
+	f.expectOnHand(t, ingredient.ID, 8, "1行目の提供後")
+
+	// 提供済みの行は二重に使用しない
+	if w := f.request(t, http.MethodPatch, orderPath+"/status", gin.H{"status": models.OrderStatusServed}); w.Code != http.StatusOK {
+		t.Fatalf("order status = %d, body = %s", w.Code, w.Body)
+	}
+	f.expectOnHand(t, ingredient.ID, 4, "注文の提供後")
+
+	if w := f.request(t, http.MethodPatch, orderPath+"/status", gin.H{"status": models.OrderStatusCancelled}); w.Code != http.StatusOK {
+		t.Fatalf("cancel status = %d, body = %s", w.Code, w.Body)
+	}
+	f.expectOnHand(t, ingredient.ID, 10, "取り消し後")
+}
+
+func TestIngredientsConsumedOnCheckout(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, card)
+	product, ingredient := f.addRecipeProduct(t, 1, 2)
+	order := f.addTakeoutOrder(t, 540)
+	if err := f.db.Model(&order.Items[0]).Update("product_id", product.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 提供前に会計した場合も使用し、記録上の在庫が足りなくても会計は止めない
+	if w := f.checkoutOrder(t, order.ID, PaymentRequest{Method: payments.MethodCard, Amount: 540}); w.Code != http.StatusOK {
+		t.Fatalf("checkout status = %d, body = %s", w.Code, w.Body)
+	}
+	f.expectOnHand(t, ingredient.ID, -1, "会計後")
+
+	var movements []models.IngredientMovement
+	if err := f.db.Where("order_item_id = ?", order.Items[0].ID).Find(&movements).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(movements) != 1 || movements[0].Reason != models.StockReasonSale {
+		t.Errorf("movements = %+v, want one sale", movements)
+	}
+}
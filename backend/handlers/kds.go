@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/events"
+	"orderbase/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 呼び戻し候補として返す準備完了済み明細の件数
+const kdsBumpedLimit = 20
+
+type KDSHandler struct {
+	DB *gorm.DB
+}
+
+// KDSItem キッチンディスプレイに表示する明細行
+type KDSItem struct {
+	models.OrderItem
+	TableNumber *int      `json:"table_number,omitempty"`
+	OrderedAt   time.Time `json:"ordered_at"`
+	AgeSeconds  int64     `json:"age_seconds"` // 注文からの経過秒数
+}
+
+// stationParam URLの調理場所を検証
+func stationParam(c *gin.Context) (string, bool) {
+	station := c.Param("station")
+	if !models.IsValidStation(station) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "調理場所が見つかりません"})
+		return "", false
+	}
+	return station, true
+}
+
+// toKDSItems 明細行に注文情報（テーブル番号・経過時間）を付与
+func (h *KDSHandler) toKDSItems(items []models.OrderItem) ([]KDSItem, error) {
+	orderIDs := make([]uint, 0, len(items))
+	for _, item := range items {
+		orderIDs = append(orderIDs, item.OrderID)
+	}
+
+	var orders []models.Order
+	if len(orderIDs) > 0 {
+		if err := h.DB.Preload("Table").Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+			return nil, err
+		}
+	}
+	orderMap := make(map[uint]models.Order, len(orders))
+	for _, order := range orders {
+		orderMap[order.ID] = order
+	}
+
+	now := time.Now()
+	result := make([]KDSItem, 0, len(items))
+	for _, item := range items {
+		order := orderMap[item.OrderID]
+		kdsItem := KDSItem{
+			OrderItem:  item,
+			OrderedAt:  order.CreatedAt,
+			AgeSeconds: int64(now.Sub(order.CreatedAt).Seconds()),
+		}
+		if order.Table != nil {
+			kdsItem.TableNumber = &order.Table.TableNumber
+		}
+		result = append(result, kdsItem)
+	}
+	return result, nil
+}
+
+// GetStationItems 調理場所の未完了明細を古い順に取得
+func (h *KDSHandler) GetStationItems(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	station, ok := stationParam(c)
+	if !ok {
+		return
+	}
+
+	var items []models.OrderItem
+	if err := h.DB.Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.store_id = ? AND order_items.station = ? AND order_items.status IN ? AND orders.status IN ?",
+			storeID, station,
+			[]string{models.OrderStatusReceived, models.OrderStatusPreparing},
+			models.OpenOrderStatuses).
+		Preload("Product").
+		Preload("Options").
+		Order("orders.created_at ASC, order_items.id ASC").
+		Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "明細の取得に失敗しました"})
+		return
+	}
+
+	kdsItems, err := h.toKDSItems(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"station": station,
+		"items":   kdsItems,
+	})
+}
+
+// GetBumpedItems 調理場所で準備完了にした明細を新しい順に取得（呼び戻し用）
+func (h *KDSHandler) GetBumpedItems(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	station, ok := stationParam(c)
+	if !ok {
+		return
+	}
+
+	var items []models.OrderItem
+	if err := h.DB.Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.store_id = ? AND order_items.station = ? AND order_items.status = ? AND orders.status IN ?",
+			storeID, station, models.OrderStatusReady, models.OpenOrderStatuses).
+		Preload("Product").
+		Preload("Options").
+		Order("order_items.updated_at DESC").
+		Limit(kdsBumpedLimit).
+		Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "明細の取得に失敗しました"})
+		return
+	}
+
+	kdsItems, err := h.toKDSItems(items)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"station": station,
+		"items":   kdsItems,
+	})
+}
+
+// BumpItem 明細を準備完了にする（全調理場所が完了すると注文も準備完了になる）
+func (h *KDSHandler) BumpItem(c *gin.Context) {
+	h.changeStationItemStatus(c, models.OrderStatusReady, "準備完了にしました")
+}
+
+// RecallItem 準備完了にした明細を調理中に戻す
+func (h *KDSHandler) RecallItem(c *gin.Context) {
+	h.changeStationItemStatus(c, models.OrderStatusPreparing, "呼び戻しました")
+}
+
+// changeStationItemStatus 調理場所の明細ステータスを変更
+func (h *KDSHandler) changeStationItemStatus(c *gin.Context, status string, message string) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	station, ok := stationParam(c)
+	if !ok {
+		return
+	}
+
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var item models.OrderItem
+	if err := h.DB.Where("id = ? AND station = ?", itemID, station).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文明細が見つかりません"})
+		return
+	}
+
+	var order models.Order
+	if err := h.DB.Where("store_id = ?", storeID).First(&order, item.OrderID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+		return
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		return changeOrderItemStatus(tx, &order, &item, status, actorFromSession(c), "KDS: "+station)
+	})
+	if transitionErr, ok := err.(*statusTransitionError); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ステータスの更新に失敗しました"})
+		return
+	}
+
+	h.DB.Preload("Items.Product").Preload("Items.Options").First(&order, order.ID)
+	events.Publish(order.StoreID, events.OrderStatusChanged, order)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"item":    item,
+		"order":   order,
+	})
+}
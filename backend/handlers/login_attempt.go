@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type LoginAttemptHandler struct {
+	DB *gorm.DB
+}
+
+// 存在しないユーザーのログインでも照合にかかる時間が変わらないようにするためのハッシュ
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("orderbase-dummy-password"), bcrypt.DefaultCost)
+
+// ログイン履歴の取得件数
+const (
+	defaultLoginAttemptLimit = 100
+	maxLoginAttemptLimit     = 500
+)
+
+// recordLoginAttempt ログインの試行を記録（reason が空の場合は成功）
+func recordLoginAttempt(db *gorm.DB, c *gin.Context, user *models.User, username, method, reason string) {
+	attempt := models.LoginAttempt{
+		Username:  username,
+		Method:    method,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Success:   reason == "",
+		Reason:    reason,
+	}
+	if user != nil {
+		attempt.UserID = &user.ID
+		attempt.StoreID = user.StoreID
+	}
+	db.Create(&attempt)
+}
+
+// respondLoginFailed ログイン失敗のレスポンス（ユーザーの有無やパスワードの誤りを区別しない）
+func respondLoginFailed(c *gin.Context) {
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザー名またはパスワードが違います"})
+}
+
+// respondTooManyAttempts 試行が多すぎる場合のレスポンス（次に試行できるまでの秒数を Retry-After で返す）
+func respondTooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int((wait + time.Second - 1) / time.Second)
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "ログインの試行が多すぎます。しばらくしてから再度お試しください",
+		"retry_after": seconds,
+	})
+}
+
+// GetLoginAttempts 店舗のスタッフのログイン履歴を取得（新しい順）
+// username・success（true/false）で絞り込み、limit で件数を指定できる
+func (h *LoginAttemptHandler) GetLoginAttempts(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Where("store_id = ?", storeID)
+	if username := c.Query("username"); username != "" {
+		query = query.Where("username = ?", username)
+	}
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "success の値が不正です"})
+			return
+		}
+		query = query.Where("success = ?", value)
+	}
+	limit := defaultLoginAttemptLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit の値が不正です"})
+			return
+		}
+		if n > maxLoginAttemptLimit {
+			n = maxLoginAttemptLimit
+		}
+		limit = n
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Order("id DESC").Limit(limit).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログイン履歴の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
@@ -6,14 +6,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"orderbase/models"
+	"orderbase/security"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type OpenAIHandler struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Secrets *security.Keyring // 保存したAPIキーの復号に使う
 }
 
 type ChatRequest struct {
@@ -45,21 +46,15 @@ type OpenAIResponse struct {
 
 // OpenAI APIを使ってチャット応答を生成
 func (h *OpenAIHandler) ChatCompletion(c *gin.Context) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
 	// ユーザーのAPIキーを取得
-	var user models.User
-	if err := h.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	user := CurrentUser(c)
+	apiKey, err := h.Secrets.Decrypt(user.OpenAIKey, models.OpenAIKeyContext(user.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "APIキーの復号に失敗しました"})
 		return
 	}
 
-	if user.OpenAIKey == "" {
+	if apiKey == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "OpenAI APIキーが設定されていません"})
 		return
 	}
@@ -113,7 +108,7 @@ func (h *OpenAIHandler) ChatCompletion(c *gin.Context) {
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+user.OpenAIKey)
+	httpReq.Header.Set("Authorization", "Bearer "+apiKey)
 
 	client := &http.Client{}
 	resp, err := client.Do(httpReq)
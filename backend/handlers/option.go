@@ -0,0 +1,508 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"orderbase/models"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OptionHandler struct {
+	DB *gorm.DB
+}
+
+// OptionGroupRequest オプショングループ登録・更新リクエスト
+type OptionGroupRequest struct {
+	Name      string          `json:"name"`
+	Required  *bool           `json:"required"`
+	MinSelect *int            `json:"min_select"`
+	MaxSelect *int            `json:"max_select"` // 0 は無制限
+	SortOrder *int            `json:"sort_order"`
+	Options   []OptionRequest `json:"options"` // 登録時のみ（選択肢をまとめて作成）
+}
+
+// OptionRequest オプション登録・更新リクエスト
+type OptionRequest struct {
+	Name       string `json:"name"`
+	PriceDelta *int   `json:"price_delta"`
+	Available  *bool  `json:"available"`
+	SortOrder  *int   `json:"sort_order"`
+}
+
+// orderBySortOrder 表示順で並べる（Preload用）
+func orderBySortOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("sort_order ASC, id ASC")
+}
+
+// loadProductForOrder 注文に必要な情報（オプショングループと選択肢、レシピの食材）を含めて商品を取得
+func loadProductForOrder(db *gorm.DB, productID uint) (models.Product, error) {
+	var product models.Product
+	err := db.Preload("OptionGroups", orderBySortOrder).
+		Preload("OptionGroups.Options", orderBySortOrder).
+		Preload("Recipe.Ingredient").
+		First(&product, productID).Error
+	return product, err
+}
+
+// selectOptions 選択されたオプションを検証し、注文明細に保存するスナップショットを返す
+// 商品のオプショングループと選択肢は事前に読み込んでおく
+func selectOptions(product models.Product, optionIDs []uint) ([]models.OrderItemOption, string) {
+	selected := make(map[uint]bool, len(optionIDs))
+	for _, id := range optionIDs {
+		if selected[id] {
+			return nil, "同じオプションが重複して選択されています"
+		}
+		selected[id] = true
+	}
+
+	var result []models.OrderItemOption
+	for _, group := range product.OptionGroups {
+		count := 0
+		for _, option := range group.Options {
+			if !selected[option.ID] {
+				continue
+			}
+			if !option.Available {
+				return nil, fmt.Sprintf("「%s」は現在選択できません", option.Name)
+			}
+			delete(selected, option.ID)
+			count++
+			result = append(result, models.OrderItemOption{
+				OptionID:   option.ID,
+				GroupName:  group.Name,
+				Name:       option.Name,
+				PriceDelta: option.PriceDelta,
+			})
+		}
+		if count < group.MinSelections() {
+			return nil, fmt.Sprintf("「%s」を%d個以上選択してください", group.Name, group.MinSelections())
+		}
+		if group.MaxSelect > 0 && count > group.MaxSelect {
+			return nil, fmt.Sprintf("「%s」は%d個まで選択できます", group.Name, group.MaxSelect)
+		}
+	}
+	if len(selected) > 0 {
+		return nil, "この商品のオプションではありません"
+	}
+	return result, ""
+}
+
+// optionKey 選択オプションのIDを順序によらない文字列にする
+func optionKey(optionIDs []uint) string {
+	ids := append([]uint(nil), optionIDs...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// optionsPrice オプションの加算額の合計
+func optionsPrice(options []models.OrderItemOption) int {
+	total := 0
+	for _, o := range options {
+		total += o.PriceDelta
+	}
+	return total
+}
+
+// preloadCartItems カート内商品の表示に必要なリレーションを読み込む
+func preloadCartItems(db *gorm.DB) *gorm.DB {
+	return db.Preload("Product").Preload("Options.Option")
+}
+
+// priceCartItem カート内商品のオプション込みの単価と小計を計算
+func priceCartItem(item *models.CartItem) {
+	price := item.Product.Price
+	for _, o := range item.Options {
+		price += o.Option.PriceDelta
+	}
+	item.UnitPrice = price
+	item.LineTotal = price * item.Quantity
+}
+
+// priceCartItems カート内商品すべての単価と小計を計算
+func priceCartItems(items []models.CartItem) {
+	for i := range items {
+		priceCartItem(&items[i])
+	}
+}
+
+// optionLabels 伝票・領収書に印字するオプション名
+func optionLabels(options []models.OrderItemOption) []string {
+	labels := make([]string, 0, len(options))
+	for _, o := range options {
+		labels = append(labels, o.Name)
+	}
+	return labels
+}
+
+// findOwnedProduct 店舗の商品を取得
+func findOwnedProduct(db *gorm.DB, storeID uint, productID string) (*models.Product, error) {
+	var product models.Product
+	if err := db.Where("id = ? AND store_id = ?", productID, storeID).First(&product).Error; err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// loadOptionGroup URLのIDから店舗の商品のオプショングループを取得
+func (h *OptionHandler) loadOptionGroup(c *gin.Context, storeID uint) (*models.OptionGroup, bool) {
+	var group models.OptionGroup
+	if err := h.DB.Joins("JOIN products ON products.id = option_groups.product_id").
+		Where("option_groups.id = ? AND products.store_id = ?", c.Param("id"), storeID).
+		First(&group).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "オプショングループが見つかりません"})
+		return nil, false
+	}
+	return &group, true
+}
+
+// loadOption URLのIDから店舗の商品のオプションを取得
+func (h *OptionHandler) loadOption(c *gin.Context, storeID uint) (*models.Option, bool) {
+	var option models.Option
+	if err := h.DB.Joins("JOIN option_groups ON option_groups.id = options.group_id").
+		Joins("JOIN products ON products.id = option_groups.product_id").
+		Where("options.id = ? AND products.store_id = ?", c.Param("id"), storeID).
+		First(&option).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "オプションが見つかりません"})
+		return nil, false
+	}
+	return &option, true
+}
+
+// validateOptionGroup 選択数の設定を検証
+func validateOptionGroup(group models.OptionGroup) string {
+	if group.Name == "" {
+		return "グループ名が必要です"
+	}
+	if group.MinSelect < 0 || group.MaxSelect < 0 {
+		return "選択数は0以上で指定してください"
+	}
+	if group.MaxSelect > 0 && group.MinSelections() > group.MaxSelect {
+		return "最低選択数が最大選択数を超えています"
+	}
+	return ""
+}
+
+// applyOptionRequest リクエストの値をオプションに反映（省略された項目は変更しない）
+func applyOptionRequest(option *models.Option, req OptionRequest) {
+	if req.Name != "" {
+		option.Name = req.Name
+	}
+	if req.PriceDelta != nil {
+		option.PriceDelta = *req.PriceDelta
+	}
+	if req.Available != nil {
+		option.Available = *req.Available
+	}
+	if req.SortOrder != nil {
+		option.SortOrder = *req.SortOrder
+	}
+}
+
+// createOption オプションを作成（default:true のため、品切れで作成する場合は作成後に更新する）
+func createOption(tx *gorm.DB, option *models.Option) error {
+	available := option.Available
+	if err := tx.Create(option).Error; err != nil {
+		return err
+	}
+	if !available {
+		option.Available = false
+		return tx.Model(option).Update("available", false).Error
+	}
+	return nil
+}
+
+// GetOptionGroups 商品のオプショングループ一覧を取得
+func (h *OptionHandler) GetOptionGroups(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	product, err := findOwnedProduct(h.DB, storeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+		return
+	}
+
+	var groups []models.OptionGroup
+	if err := h.DB.Where("product_id = ?", product.ID).
+		Preload("Options", orderBySortOrder).
+		Order("sort_order ASC, id ASC").
+		Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプションの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"option_groups": groups})
+}
+
+// CreateOptionGroup 商品にオプショングループを作成（選択肢もまとめて登録できる）
+func (h *OptionHandler) CreateOptionGroup(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	product, err := findOwnedProduct(h.DB, storeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+		return
+	}
+
+	var req OptionGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	group := models.OptionGroup{ProductID: product.ID, Name: req.Name}
+	if req.Required != nil {
+		group.Required = *req.Required
+	}
+	if req.MinSelect != nil {
+		group.MinSelect = *req.MinSelect
+	}
+	if req.MaxSelect != nil {
+		group.MaxSelect = *req.MaxSelect
+	}
+	if req.SortOrder != nil {
+		group.SortOrder = *req.SortOrder
+	} else {
+		var count int64
+		h.DB.Model(&models.OptionGroup{}).Where("product_id = ?", product.ID).Count(&count)
+		group.SortOrder = int(count)
+	}
+	if msg := validateOptionGroup(group); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	options := make([]models.Option, 0, len(req.Options))
+	for i, optReq := range req.Options {
+		option := models.Option{Available: true, SortOrder: i}
+		applyOptionRequest(&option, optReq)
+		if option.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "オプション名が必要です"})
+			return
+		}
+		options = append(options, option)
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		for i := range options {
+			options[i].GroupID = group.ID
+			if err := createOption(tx, &options[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプショングループの作成に失敗しました"})
+		return
+	}
+	group.Options = options
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":      "オプショングループを作成しました",
+		"option_group": group,
+	})
+}
+
+// UpdateOptionGroup オプショングループの名前・選択数・表示順を更新
+func (h *OptionHandler) UpdateOptionGroup(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	group, ok := h.loadOptionGroup(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req OptionGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Name != "" {
+		group.Name = req.Name
+	}
+	if req.Required != nil {
+		group.Required = *req.Required
+	}
+	if req.MinSelect != nil {
+		group.MinSelect = *req.MinSelect
+	}
+	if req.MaxSelect != nil {
+		group.MaxSelect = *req.MaxSelect
+	}
+	if req.SortOrder != nil {
+		group.SortOrder = *req.SortOrder
+	}
+	if msg := validateOptionGroup(*group); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.DB.Model(group).Updates(map[string]interface{}{
+		"name":       group.Name,
+		"required":   group.Required,
+		"min_select": group.MinSelect,
+		"max_select": group.MaxSelect,
+		"sort_order": group.SortOrder,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプショングループの更新に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "オプショングループを更新しました",
+		"option_group": group,
+	})
+}
+
+// DeleteOptionGroup オプショングループと選択肢を削除
+// カート内の選択は取り除き、注文済みの明細はスナップショットを残す
+func (h *OptionHandler) DeleteOptionGroup(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	group, ok := h.loadOptionGroup(c, storeID)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		optionIDs := tx.Model(&models.Option{}).Select("id").Where("group_id = ?", group.ID)
+		if err := tx.Where("option_id IN (?)", optionIDs).Delete(&models.CartItemOption{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&models.Option{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプショングループの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "オプショングループを削除しました"})
+}
+
+// CreateOption オプショングループに選択肢を追加
+func (h *OptionHandler) CreateOption(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	group, ok := h.loadOptionGroup(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req OptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	var count int64
+	h.DB.Model(&models.Option{}).Where("group_id = ?", group.ID).Count(&count)
+	option := models.Option{GroupID: group.ID, Available: true, SortOrder: int(count)}
+	applyOptionRequest(&option, req)
+	if option.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "オプション名が必要です"})
+		return
+	}
+
+	if err := h.DB.Transaction(func(tx *gorm.DB) error {
+		return createOption(tx, &option)
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプションの作成に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "オプションを作成しました",
+		"option":  option,
+	})
+}
+
+// UpdateOption 選択肢の名前・加算額・品切れ・表示順を更新
+func (h *OptionHandler) UpdateOption(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	option, ok := h.loadOption(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req OptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	applyOptionRequest(option, req)
+
+	if err := h.DB.Model(option).Updates(map[string]interface{}{
+		"name":        option.Name,
+		"price_delta": option.PriceDelta,
+		"available":   option.Available,
+		"sort_order":  option.SortOrder,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプションの更新に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "オプションを更新しました",
+		"option":  option,
+	})
+}
+
+// DeleteOption 選択肢を削除（カート内の選択は取り除く）
+func (h *OptionHandler) DeleteOption(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	option, ok := h.loadOption(c, storeID)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("option_id = ?", option.ID).Delete(&models.CartItemOption{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(option).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "オプションの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "オプションを削除しました"})
+}
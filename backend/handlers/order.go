@@ -1,27 +1,58 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"orderbase/billing"
+	"orderbase/events"
 	"orderbase/models"
+	"orderbase/printing"
 	"strconv"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// CreateOrderItemRequest 注文明細行リクエスト
+type CreateOrderItemRequest struct {
+	ProductID uint   `json:"product_id" binding:"required"`
+	Quantity  int    `json:"quantity" binding:"required,min=1"`
+	OptionIDs []uint `json:"option_ids"` // 選択したオプション
+}
+
 // CreateOrderRequest 注文作成リクエスト
 type CreateOrderRequest struct {
-	ProductID uint `json:"product_id" binding:"required"`
-	Quantity  int  `json:"quantity" binding:"required,min=1"`
+	Items        []CreateOrderItemRequest `json:"items" binding:"required,min=1,dive"`
+	DiningOption string                   `json:"dining_option"` // eat_in（デフォルト）または takeout
+}
+
+// newOrderItem 商品から注文明細行を作成（注文時点の価格・調理場所・税率・選択オプションを保存）
+// options は selectOptions で検証済みのもの
+func newOrderItem(product models.Product, quantity int, diningOption string, options []models.OrderItemOption) models.OrderItem {
+	station := product.Station
+	if station == "" {
+		station = models.StationKitchen
+	}
+	unitPrice := product.Price + optionsPrice(options)
+	return models.OrderItem{
+		ProductID: product.ID,
+		Quantity:  quantity,
+		UnitPrice: unitPrice,
+		LineTotal: unitPrice * quantity,
+		TaxRate:   billing.TaxRate(product.TaxCategory, diningOption),
+		Status:    models.OrderStatusReceived,
+		Station:   station,
+		Options:   options,
+	}
 }
 
 // CreateOrder 注文を作成
-func CreateOrder(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+func CreateOrder(c *gin.Context, db *gorm.DB, spooler *printing.Spooler) {
+	userID := currentUserID(c)
+
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -31,30 +62,87 @@ func CreateOrder(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	// 商品情報を取得
-	var product models.Product
-	if err := db.Where("id = ? AND user_id = ?", req.ProductID, userID).First(&product).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+	diningOption, ok := diningOptionOrDefault(req.DiningOption)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な飲食の区分です"})
 		return
 	}
 
-	// 注文を作成
-	uidUint := userID.(uint)
+	// 商品情報を取得して明細行を作成
+	uidUint := userID
+	includesTax, err := pricesIncludeTax(db, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗情報の取得に失敗しました"})
+		return
+	}
 	order := models.Order{
-		UserID:     &uidUint,
-		ProductID:  req.ProductID,
-		Quantity:   req.Quantity,
-		TotalPrice: product.Price * req.Quantity,
-		Status:     "pending",
+		StoreID:          storeID,
+		UserID:           &uidUint,
+		Status:           models.OrderStatusReceived,
+		DiningOption:     diningOption,
+		PriceIncludesTax: includesTax,
+	}
+	stock := newStockChecker()
+	var shortages []StockShortage
+	for _, reqItem := range req.Items {
+		product, err := loadProductForOrder(db, reqItem.ProductID)
+		if err != nil || product.StoreID != storeID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+			return
+		}
+		options, msg := selectOptions(product, reqItem.OptionIDs)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg, "product_id": product.ID})
+			return
+		}
+		if available, ok := stock.take(product, reqItem.Quantity); !ok {
+			shortages = append(shortages, StockShortage{
+				ProductID: product.ID,
+				Name:      product.Name,
+				Requested: reqItem.Quantity,
+				Available: available,
+			})
+		}
+		order.Items = append(order.Items, newOrderItem(product, reqItem.Quantity, diningOption, options))
 	}
+	if len(shortages) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "在庫が不足している商品があります", "shortages": shortages})
+		return
+	}
+	applyOrderTax(&order)
 
-	if err := db.Create(&order).Error; err != nil {
+	// 注文ヘッダーと明細行をまとめて作成し、在庫を減らす
+	actor := actorFromSession(c)
+	var changed []uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		if err := recordOrderStatusEvent(tx, order.ID, nil, "", order.Status, actor, "注文作成"); err != nil {
+			return err
+		}
+		var err error
+		changed, err = deductStock(tx, &order, actor)
+		return err
+	})
+	var shortage *stockShortageError
+	if errors.As(err, &shortage) {
+		c.JSON(http.StatusConflict, gin.H{"error": "在庫が不足している商品があります", "shortages": shortage.Shortages})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の作成に失敗しました"})
 		return
 	}
 
 	// 商品情報を含めて返す
-	db.Preload("Product").First(&order, order.ID)
+	db.Preload("Items.Product").Preload("Items.Options").First(&order, order.ID)
+	order.Tax = orderTax(order)
+	events.Publish(order.StoreID, events.OrderCreated, order)
+	publishStockChanges(db, changed)
+	if err := queueKitchenTickets(db, spooler, order); err != nil {
+		log.Printf("調理伝票の印刷ジョブの登録に失敗しました（注文 #%d）: %v", order.ID, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "注文を作成しました",
@@ -62,18 +150,17 @@ func CreateOrder(c *gin.Context, db *gorm.DB) {
 	})
 }
 
-// GetOrders 注文一覧を取得（ダッシュボード用：全注文を表示）
+// GetOrders 注文一覧を取得（ダッシュボード用：店舗の全注文を表示）
 func GetOrders(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
-	// ダッシュボードでは全注文を表示（ゲスト購入を含む）
+	// ダッシュボードでは店舗の全注文を表示（ゲスト購入を含む）
 	var orders []models.Order
-	if err := db.Preload("Product").
+	if err := db.Where("store_id = ?", storeID).
+		Preload("Items.Product").Preload("Items.Options").
 		Preload("User").
 		Preload("Table").
 		Order("created_at DESC").
@@ -87,10 +174,8 @@ func GetOrders(c *gin.Context, db *gorm.DB) {
 
 // GetOrderByID 注文詳細を取得
 func GetOrderByID(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -101,22 +186,86 @@ func GetOrderByID(c *gin.Context, db *gorm.DB) {
 	}
 
 	var order models.Order
-	if err := db.Where("id = ? AND user_id = ?", orderID, userID).
-		Preload("Product").
+	if err := db.Where("id = ? AND store_id = ?", orderID, storeID).
+		Preload("Items.Product").
+		Preload("Items.Options").
 		First(&order).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
 		return
 	}
+	order.Tax = orderTax(order)
 
 	c.JSON(http.StatusOK, order)
 }
 
-// UpdateOrderStatus 注文ステータスを更新（ゲスト注文を含む全注文対応）
+// UpdateOrderStatus 注文ステータスを更新（ゲスト注文を含む全注文対応。会計済み・返金済みへの変更は除く）
 func UpdateOrderStatus(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var req struct {
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	status := models.NormalizeOrderStatus(req.Status)
+	if !models.IsValidOrderStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なステータスです"})
+		return
+	}
+	// 会計済み・返金済みへの変更は支払いの記録を伴うため、会計・返金の操作でのみ行う（旧ステータスの completed も含む）
+	if status == models.OrderStatusPaid || status == models.OrderStatusRefunded {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "会計済み・返金済みへの変更は会計・返金から行ってください"})
+		return
+	}
+
+	// ゲスト注文も含めて店舗の全注文を更新可能に
+	var order models.Order
+	if err := db.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return changeOrderStatus(tx, &order, status, actorFromSession(c), req.Note)
+	})
+	if transitionErr, ok := err.(*statusTransitionError); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+		return
+	}
+	if errors.Is(err, errSplitPaymentStarted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ステータスの更新に失敗しました"})
+		return
+	}
+
+	db.Preload("Items.Product").Preload("Items.Options").First(&order, order.ID)
+	events.Publish(order.StoreID, events.OrderStatusChanged, order)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "ステータスを更新しました",
+		"order":   order,
+	})
+}
+
+// UpdateOrderItemStatus 注文明細行のステータスを更新
+func UpdateOrderItemStatus(c *gin.Context, db *gorm.DB) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -125,28 +274,58 @@ func UpdateOrderStatus(c *gin.Context, db *gorm.DB) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
 		return
 	}
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
 
 	var req struct {
-		Status string `json:"status" binding:"required,oneof=pending completed cancelled"`
+		Status string `json:"status" binding:"required"`
+		Note   string `json:"note"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
 		return
 	}
+	status := models.NormalizeOrderStatus(req.Status)
+	if !models.IsValidOrderStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なステータスです"})
+		return
+	}
 
-	// ゲスト注文も含めて全注文を更新可能に
 	var order models.Order
-	if err := db.Where("id = ?", orderID).First(&order).Error; err != nil {
+	if err := db.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
 		return
 	}
 
-	order.Status = req.Status
-	if err := db.Save(&order).Error; err != nil {
+	var item models.OrderItem
+	if err := db.Where("id = ? AND order_id = ?", itemID, order.ID).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文明細が見つかりません"})
+		return
+	}
+
+	// 明細行を更新し、注文全体の合計とステータスを再計算
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return changeOrderItemStatus(tx, &order, &item, status, actorFromSession(c), req.Note)
+	})
+	if transitionErr, ok := err.(*statusTransitionError); ok {
+		c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+		return
+	}
+	if errors.Is(err, errSplitPaymentStarted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ステータスの更新に失敗しました"})
 		return
 	}
 
+	db.Preload("Items.Product").Preload("Items.Options").First(&order, order.ID)
+	events.Publish(order.StoreID, events.OrderStatusChanged, order)
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "ステータスを更新しました",
 		"order":   order,
@@ -155,10 +334,8 @@ func UpdateOrderStatus(c *gin.Context, db *gorm.DB) {
 
 // DeleteOrder 注文を削除
 func DeleteOrder(c *gin.Context, db *gorm.DB) {
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -169,12 +346,46 @@ func DeleteOrder(c *gin.Context, db *gorm.DB) {
 	}
 
 	var order models.Order
-	if err := db.Where("id = ? AND user_id = ?", orderID, userID).First(&order).Error; err != nil {
+	if err := db.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
 		return
 	}
+	// 会計済みの注文は支払い記録と売上が残るため削除しない（返金で取り消す）
+	if order.Status == models.OrderStatusPaid || order.Status == models.OrderStatusRefunded {
+		c.JSON(http.StatusConflict, gin.H{"error": "会計済みの注文は削除できません"})
+		return
+	}
 
-	if err := db.Delete(&order).Error; err != nil {
+	// 提供前の明細だけ在庫を戻し（提供済みは消費済み）、明細とオプションごと削除
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureSplitPaymentNotStarted(tx, order.TableSessionID); err != nil {
+			return err
+		}
+		var items []models.OrderItem
+		if err := tx.Where("order_id = ? AND status NOT IN ?", order.ID,
+			[]string{models.OrderStatusCancelled, models.OrderStatusServed, models.OrderStatusPaid}).Find(&items).Error; err != nil {
+			return err
+		}
+		if err := restoreStock(tx, items, actorFromSession(c), "注文の削除"); err != nil {
+			return err
+		}
+		itemIDs := tx.Model(&models.OrderItem{}).Select("id").Where("order_id = ?", order.ID)
+		if err := tx.Where("order_item_id IN (?)", itemIDs).Delete(&models.OrderItemOption{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", order.ID).Delete(&models.OrderStatusEvent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&order).Error
+	})
+	if errors.Is(err, errSplitPaymentStarted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "削除に失敗しました"})
 		return
 	}
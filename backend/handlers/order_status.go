@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"orderbase/billing"
+	"orderbase/models"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// statusActor ステータスを変更した人
+type statusActor struct {
+	UserID     *uint
+	Name       string
+	TerminalID *uint // 共有端末でPINログインしている場合
+}
+
+// actorFromSession ログイン中のユーザーから変更者を取得（ゲストの場合は空）
+func actorFromSession(c *gin.Context) statusActor {
+	session := sessions.Default(c)
+	var actor statusActor
+	if user := CurrentUser(c); user != nil {
+		actor.UserID = &user.ID
+		actor.Name = user.Username
+	} else if uid, ok := session.Get("user_id").(uint); ok {
+		// ログイン不要のルート（カートなど）ではセッションから取得する
+		actor.UserID = &uid
+		actor.Name, _ = session.Get("user").(string)
+	}
+	if terminalID, ok := session.Get("terminal_id").(uint); ok {
+		actor.TerminalID = &terminalID
+	}
+	return actor
+}
+
+// statusTransitionError 許可されていないステータス遷移
+type statusTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *statusTransitionError) Error() string {
+	return fmt.Sprintf("ステータスを「%s」から「%s」に変更できません", e.From, e.To)
+}
+
+// recordOrderStatusEvent ステータス変更履歴を記録
+func recordOrderStatusEvent(tx *gorm.DB, orderID uint, itemID *uint, from, to string, actor statusActor, note string) error {
+	event := models.OrderStatusEvent{
+		OrderID:       orderID,
+		OrderItemID:   itemID,
+		FromStatus:    from,
+		ToStatus:      to,
+		ChangedBy:     actor.UserID,
+		ChangedByName: actor.Name,
+		TerminalID:    actor.TerminalID,
+		Note:          note,
+	}
+	return tx.Create(&event).Error
+}
+
+// changeOrderStatus 注文ステータスを遷移させ、明細行にも反映する
+func changeOrderStatus(tx *gorm.DB, order *models.Order, to string, actor statusActor, note string) error {
+	from := order.Status
+	if !models.CanTransitionOrderStatus(from, to) {
+		return &statusTransitionError{From: from, To: to}
+	}
+
+	if err := tx.Model(order).Update("status", to).Error; err != nil {
+		return err
+	}
+	if err := recordOrderStatusEvent(tx, order.ID, nil, from, to, actor, note); err != nil {
+		return err
+	}
+
+	// 明細行への反映（取り消しは全行、進行は served を上限に遅れている行のみ）
+	itemQuery := tx.Model(&models.OrderItem{}).Where("order_id = ? AND status <> ?", order.ID, models.OrderStatusCancelled)
+	switch {
+	case to == models.OrderStatusCancelled:
+		if err := ensureSplitPaymentNotStarted(tx, order.TableSessionID); err != nil {
+			return err
+		}
+		// 取り消す明細の在庫を戻す
+		var items []models.OrderItem
+		if err := tx.Where("order_id = ? AND status <> ?", order.ID, models.OrderStatusCancelled).Find(&items).Error; err != nil {
+			return err
+		}
+		if err := restoreStock(tx, items, actor, "注文の取り消し"); err != nil {
+			return err
+		}
+		if err := itemQuery.Update("status", models.OrderStatusCancelled).Error; err != nil {
+			return err
+		}
+	case models.OrderStatusStage(to) >= 0:
+		itemStatus := to
+		if models.OrderStatusStage(to) > models.OrderStatusStage(models.OrderStatusServed) {
+			itemStatus = models.OrderStatusServed
+		}
+		var behind []string
+		for _, s := range models.OpenOrderStatuses {
+			if models.OrderStatusStage(s) < models.OrderStatusStage(itemStatus) {
+				behind = append(behind, s)
+			}
+		}
+		if len(behind) > 0 {
+			if err := itemQuery.Where("status IN ?", behind).Update("status", itemStatus).Error; err != nil {
+				return err
+			}
+		}
+		// 提供・会計した明細の食材を使用する
+		if itemStatus == models.OrderStatusServed {
+			var items []models.OrderItem
+			if err := tx.Where("order_id = ? AND status <> ?", order.ID, models.OrderStatusCancelled).Find(&items).Error; err != nil {
+				return err
+			}
+			if err := consumeIngredients(tx, items, actor); err != nil {
+				return err
+			}
+		}
+	}
+
+	order.Status = to
+	return nil
+}
+
+// changeOrderItemStatus 明細行のステータスを遷移させ、注文全体を再計算する
+func changeOrderItemStatus(tx *gorm.DB, order *models.Order, item *models.OrderItem, to string, actor statusActor, note string) error {
+	// 会計済み・取消済みの注文の明細は変更できない
+	if models.OrderStatusStage(order.Status) < 0 || order.Status == models.OrderStatusPaid {
+		return &statusTransitionError{From: order.Status, To: to}
+	}
+
+	from := item.Status
+	if !models.CanTransitionOrderItemStatus(from, to) {
+		return &statusTransitionError{From: from, To: to}
+	}
+	if to == models.OrderStatusCancelled {
+		if err := ensureSplitPaymentNotStarted(tx, order.TableSessionID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(item).Update("status", to).Error; err != nil {
+		return err
+	}
+	if err := recordOrderStatusEvent(tx, order.ID, &item.ID, from, to, actor, note); err != nil {
+		return err
+	}
+	item.Status = to
+	switch to {
+	case models.OrderStatusCancelled:
+		if err := restoreStock(tx, []models.OrderItem{*item}, actor, "明細の取り消し"); err != nil {
+			return err
+		}
+	case models.OrderStatusServed:
+		if err := consumeIngredients(tx, []models.OrderItem{*item}, actor); err != nil {
+			return err
+		}
+	}
+
+	return recalculateOrder(tx, order, actor)
+}
+
+// recalculateOrder 明細行から注文の合計金額とステータスを再計算
+// 全明細が取り消されたら注文を取り消し、全明細が進んだ工程まで注文を進める
+// 準備完了後に明細が呼び戻された場合は調理中に戻す
+func recalculateOrder(tx *gorm.DB, order *models.Order, actor statusActor) error {
+	var items []models.OrderItem
+	if err := tx.Where("order_id = ?", order.ID).Find(&items).Error; err != nil {
+		return err
+	}
+
+	minStage := -1
+	for _, item := range items {
+		if item.Status == models.OrderStatusCancelled {
+			continue
+		}
+		stage := models.OrderStatusStage(item.Status)
+		if minStage < 0 || stage < minStage {
+			minStage = stage
+		}
+	}
+
+	// 取り消された明細を除いて金額と税額を再計算
+	summary := billing.CalculateTax(taxLines(items, order.PriceIncludesTax))
+	if summary.Total != order.TotalPrice || summary.Tax != order.TaxAmount {
+		if err := tx.Model(order).Updates(map[string]interface{}{
+			"total_price": summary.Total,
+			"subtotal":    summary.Subtotal,
+			"tax_amount":  summary.Tax,
+		}).Error; err != nil {
+			return err
+		}
+		order.TotalPrice = summary.Total
+		order.Subtotal = summary.Subtotal
+		order.TaxAmount = summary.Tax
+	}
+
+	if minStage < 0 {
+		return changeOrderStatus(tx, order, models.OrderStatusCancelled, actor, "全明細の取り消しにより自動更新")
+	}
+	if minStage > models.OrderStatusStage(order.Status) {
+		return changeOrderStatus(tx, order, models.OpenOrderStatuses[minStage], actor, "明細の進捗により自動更新")
+	}
+	if order.Status == models.OrderStatusReady && minStage < models.OrderStatusStage(models.OrderStatusReady) {
+		return changeOrderStatus(tx, order, models.OrderStatusPreparing, actor, "明細の呼び戻しにより自動更新")
+	}
+	return nil
+}
+
+// GetOrderTimeline 注文のステータス変更履歴を取得
+func GetOrderTimeline(c *gin.Context, db *gorm.DB) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var order models.Order
+	if err := db.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+		return
+	}
+
+	var timeline []models.OrderStatusEvent
+	if err := db.Where("order_id = ?", order.ID).
+		Order("created_at ASC, id ASC").
+		Find(&timeline).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "履歴の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_id": order.ID,
+		"status":   order.Status,
+		"timeline": timeline,
+	})
+}
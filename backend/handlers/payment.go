@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"orderbase/billing"
+	"orderbase/events"
+	"orderbase/models"
+	"orderbase/payments"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PaymentHandler struct {
+	DB        *gorm.DB
+	Providers *payments.Registry
+}
+
+// errBillChanged 決済中に注文が追加・取り消しされ、請求額が決済した金額と一致しなくなった
+var errBillChanged = errors.New("会計中に注文内容が変わりました。請求を確認してもう一度会計してください")
+
+// PaymentRequest 会計時の支払い1件分
+type PaymentRequest struct {
+	Method    string `json:"method" binding:"required"`
+	Amount    int    `json:"amount" binding:"required,min=1"`
+	Tendered  int    `json:"tendered"`  // お預かり金額（現金のみ）
+	Reference string `json:"reference"` // 決済端末の取引番号（カード・QRは必須）
+}
+
+// chargePayments 支払いを順に決済し、失敗した場合はそれまでの決済を取り消す
+func (h *PaymentHandler) chargePayments(c *gin.Context, reqs []PaymentRequest) ([]payments.Result, error) {
+	results := make([]payments.Result, 0, len(reqs))
+	for _, req := range reqs {
+		provider, err := h.Providers.Get(req.Method)
+		if err == nil {
+			var result payments.Result
+			result, err = provider.Charge(c.Request.Context(), payments.Request{
+				Method:    req.Method,
+				Amount:    req.Amount,
+				Tendered:  req.Tendered,
+				Reference: req.Reference,
+			})
+			if err == nil {
+				results = append(results, result)
+				continue
+			}
+		}
+		h.refundPayments(c, results)
+		return nil, err
+	}
+	return results, nil
+}
+
+// refundPayments 決済済みの支払いを取り消す
+func (h *PaymentHandler) refundPayments(c *gin.Context, results []payments.Result) {
+	for _, result := range results {
+		provider, err := h.Providers.Get(result.Method)
+		if err == nil {
+			err = provider.Refund(c.Request.Context(), result)
+		}
+		if err != nil {
+			log.Printf("決済の取り消しに失敗しました（%s %s）: %v", result.Method, result.Reference, err)
+		}
+	}
+}
+
+// currentBill テーブルの利用中セッションと未会計の注文・請求額を取得（取得できない場合はレスポンス済み）
+func (h *PaymentHandler) currentBill(c *gin.Context, table *models.Table) (*models.TableSession, []models.Order, billing.TaxSummary, bool) {
+	current, err := findOpenTableSession(h.DB, table.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの取得に失敗しました"})
+		return nil, nil, billing.TaxSummary{}, false
+	}
+	if current == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "利用中のセッションがありません"})
+		return nil, nil, billing.TaxSummary{}, false
+	}
+
+	orders, err := loadOpenOrders(h.DB, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+		return nil, nil, billing.TaxSummary{}, false
+	}
+
+	// 請求額は注文をまとめて税率ごとに端数処理した金額
+	return current, orders, billTax(orders), true
+}
+
+// loadOpenOrders 来店セッションの未会計の注文を明細付きで取得
+// 会計のトランザクション内でも取得し直し、決済中に追加された注文を残したまま会計しないようにする
+func loadOpenOrders(db *gorm.DB, tableSessionID uint) ([]models.Order, error) {
+	var orders []models.Order
+	err := db.Where("table_session_id = ? AND status IN ?", tableSessionID, models.OpenOrderStatuses).
+		Preload("Items", "status <> ?", models.OrderStatusCancelled).
+		Preload("Items.Product").
+		Preload("Items.Options").
+		Order("created_at ASC").
+		Find(&orders).Error
+	return orders, err
+}
+
+// recordPayments 決済結果を支払い記録として保存（target には支払い対象のテーブル・来店・注文などを設定しておく）
+func recordPayments(tx *gorm.DB, results []payments.Result, target models.Payment, actor statusActor) ([]models.Payment, error) {
+	records := make([]models.Payment, 0, len(results))
+	for _, result := range results {
+		payment := target
+		payment.Method = result.Method
+		payment.Amount = result.Amount
+		payment.Tendered = result.Tendered
+		payment.Change = result.Change
+		payment.Reference = result.Reference
+		payment.Status = models.PaymentCompleted
+		payment.ProcessedBy = actor.UserID
+		if err := tx.Create(&payment).Error; err != nil {
+			return nil, err
+		}
+		records = append(records, payment)
+	}
+	return records, nil
+}
+
+// settleTable 注文を会計済みにし、来店セッションを終了してテーブルを解放
+func settleTable(tx *gorm.DB, table *models.Table, tableSession *models.TableSession, orders []models.Order, actor statusActor) error {
+	for i := range orders {
+		if err := changeOrderStatus(tx, &orders[i], models.OrderStatusPaid, actor, "会計"); err != nil {
+			return err
+		}
+	}
+
+	if err := closeTableSession(tx, tableSession); err != nil {
+		return err
+	}
+	if err := revokeTableTokens(tx, table.ID); err != nil {
+		return err
+	}
+	return tx.Model(table).Update("status", "inactive").Error
+}
+
+// publishSettlement 会計完了をキッチン・ホールに通知
+func publishSettlement(table *models.Table, orders []models.Order) {
+	for _, order := range orders {
+		events.Publish(order.StoreID, events.OrderStatusChanged, order)
+	}
+	events.Publish(table.StoreID, events.TableChanged, table)
+}
+
+// totalChange おつりの合計
+func totalChange(records []models.Payment) int {
+	change := 0
+	for _, record := range records {
+		change += record.Change
+	}
+	return change
+}
+
+// CheckoutTable テーブルの会計（支払いの記録・注文の完了・テーブルの解放をまとめて実行）
+func (h *PaymentHandler) CheckoutTable(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Payments []PaymentRequest `json:"payments" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	current, orders, bill, ok := h.currentBill(c, table)
+	if !ok {
+		return
+	}
+	billTotal := bill.Total
+	if len(orders) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "未会計の注文がありません"})
+		return
+	}
+
+	// 分割会計の支払いが始まっている場合は一括会計できない
+	split, err := findOpenBillSplit(h.DB, current.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分割会計の取得に失敗しました"})
+		return
+	}
+	if split != nil && billSplitHasPayments(split) {
+		c.JSON(http.StatusConflict, gin.H{"error": "分割会計の途中です"})
+		return
+	}
+
+	paidTotal := 0
+	for _, p := range req.Payments {
+		paidTotal += p.Amount
+	}
+	if paidTotal != billTotal {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      fmt.Sprintf("支払額の合計（¥%d）が請求額（¥%d）と一致しません", paidTotal, billTotal),
+			"bill_total": billTotal,
+		})
+		return
+	}
+
+	// 決済（失敗した場合はDBを変更しない）
+	results, err := h.chargePayments(c, req.Payments)
+	if err != nil {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 支払いの記録・注文の完了・テーブルの解放をまとめて実行
+	actor := actorFromSession(c)
+	var records []models.Payment
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// 未払いのままの分割は取り消す
+		if split != nil {
+			if err := tx.Model(split).Update("status", models.BillSplitCancelled).Error; err != nil {
+				return err
+			}
+		}
+
+		var err error
+		records, err = recordPayments(tx, results, models.Payment{TableID: &table.ID, TableSessionID: &current.ID}, actor)
+		if err != nil {
+			return err
+		}
+
+		// 決済中に追加・取り消しされた注文や、同時に行われた会計を検出するため取得し直して照合する
+		if orders, err = loadOpenOrders(tx, current.ID); err != nil {
+			return err
+		}
+		if billTax(orders).Total != billTotal {
+			return errBillChanged
+		}
+		return settleTable(tx, table, current, orders, actor)
+	})
+	if err != nil {
+		h.refundPayments(c, results)
+		if transitionErr, ok := err.(*statusTransitionError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+			return
+		}
+		if errors.Is(err, errBillChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "会計処理に失敗しました"})
+		return
+	}
+
+	publishSettlement(table, orders)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "会計が完了しました",
+		"bill_total": billTotal,
+		"tax":        bill,
+		"change":     totalChange(records),
+		"payments":   records,
+		"session_id": current.ID,
+	})
+}
+
+// errOrderNotOpen 会計しようとした注文が会計済み・取り消し済みになっていた
+var errOrderNotOpen = errors.New("この注文は会計済みか取り消されています")
+
+// loadOpenOrder 未会計の注文を明細付きで取得
+func loadOpenOrder(db *gorm.DB, orderID uint) (*models.Order, error) {
+	var order models.Order
+	err := db.Where("id = ? AND status IN ?", orderID, models.OpenOrderStatuses).
+		Preload("Items", "status <> ?", models.OrderStatusCancelled).
+		First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errOrderNotOpen
+	}
+	return &order, err
+}
+
+// CheckoutOrder 来店（テーブルセッション）に紐づかない注文の会計（持ち帰り・QRなしのカート注文など）
+func (h *PaymentHandler) CheckoutOrder(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var req struct {
+		Payments []PaymentRequest `json:"payments" binding:"required,min=1,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	var order models.Order
+	if err := h.DB.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+		return
+	}
+	if order.TableSessionID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "テーブルの注文はテーブルの会計から行ってください"})
+		return
+	}
+	open, err := loadOpenOrder(h.DB, order.ID)
+	if errors.Is(err, errOrderNotOpen) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+		return
+	}
+	bill := billTax([]models.Order{*open})
+	billTotal := bill.Total
+
+	paidTotal := 0
+	for _, p := range req.Payments {
+		paidTotal += p.Amount
+	}
+	if paidTotal != billTotal {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      fmt.Sprintf("支払額の合計（¥%d）が請求額（¥%d）と一致しません", paidTotal, billTotal),
+			"bill_total": billTotal,
+		})
+		return
+	}
+
+	results, err := h.chargePayments(c, req.Payments)
+	if err != nil {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+		return
+	}
+
+	actor := actorFromSession(c)
+	var records []models.Payment
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		// 同じ注文が同時に会計された場合はどちらか一方のみ成功させる
+		result := tx.Model(&models.Order{}).
+			Where("id = ? AND status IN ?", open.ID, models.OpenOrderStatuses).
+			Update("updated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errOrderNotOpen
+		}
+
+		// 決済中に明細が取り消された場合を検出するため取得し直して照合する
+		var err error
+		if open, err = loadOpenOrder(tx, open.ID); err != nil {
+			return err
+		}
+		if billTax([]models.Order{*open}).Total != billTotal {
+			return errBillChanged
+		}
+
+		records, err = recordPayments(tx, results, models.Payment{TableID: open.TableID, OrderID: &open.ID}, actor)
+		if err != nil {
+			return err
+		}
+		return changeOrderStatus(tx, open, models.OrderStatusPaid, actor, "会計")
+	})
+	if err != nil {
+		h.refundPayments(c, results)
+		if transitionErr, ok := err.(*statusTransitionError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+			return
+		}
+		if errors.Is(err, errOrderNotOpen) || errors.Is(err, errBillChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "会計処理に失敗しました"})
+		return
+	}
+
+	events.Publish(open.StoreID, events.OrderStatusChanged, open)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "会計が完了しました",
+		"bill_total": billTotal,
+		"tax":        bill,
+		"change":     totalChange(records),
+		"payments":   records,
+		"order_id":   open.ID,
+	})
+}
+
+// errAlreadyRefunded 返金しようとした支払いが同時に返金された
+var errAlreadyRefunded = errors.New("この会計はすでに返金されています")
+
+// RefundOrder 会計済みの注文を返金（テーブルの会計はまとめて支払われたため、同じ来店の注文をすべて返金する）
+func (h *PaymentHandler) RefundOrder(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"` // 返金理由（履歴に記録）
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	var order models.Order
+	if err := h.DB.Where("id = ? AND store_id = ?", orderID, storeID).First(&order).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+		return
+	}
+	if order.Status != models.OrderStatusPaid {
+		c.JSON(http.StatusConflict, gin.H{"error": "会計済みの注文のみ返金できます"})
+		return
+	}
+
+	// 返金する支払いと注文（来店単位の会計か注文単位の会計か）
+	paymentQuery := func(tx *gorm.DB) *gorm.DB {
+		if order.TableSessionID != nil {
+			return tx.Where("table_session_id = ?", *order.TableSessionID)
+		}
+		return tx.Where("order_id = ?", order.ID)
+	}
+	orderQuery := func(tx *gorm.DB) *gorm.DB {
+		if order.TableSessionID != nil {
+			return tx.Where("table_session_id = ?", *order.TableSessionID)
+		}
+		return tx.Where("id = ?", order.ID)
+	}
+
+	note := "返金"
+	if req.Reason != "" {
+		note = "返金: " + req.Reason
+	}
+	actor := actorFromSession(c)
+	var refunded []models.Payment
+	var orders []models.Order
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := paymentQuery(tx).Where("status = ?", models.PaymentCompleted).Find(&refunded).Error; err != nil {
+			return err
+		}
+		// 同じ会計が同時に返金された場合はどちらか一方のみ成功させる
+		if len(refunded) > 0 {
+			ids := make([]uint, len(refunded))
+			for i, payment := range refunded {
+				ids[i] = payment.ID
+			}
+			result := tx.Model(&models.Payment{}).
+				Where("id IN ? AND status = ?", ids, models.PaymentCompleted).
+				Update("status", models.PaymentRefunded)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected != int64(len(ids)) {
+				return errAlreadyRefunded
+			}
+		}
+
+		if err := orderQuery(tx).Where("status = ?", models.OrderStatusPaid).Find(&orders).Error; err != nil {
+			return err
+		}
+		if len(orders) == 0 {
+			return errAlreadyRefunded
+		}
+		for i := range orders {
+			if err := changeOrderStatus(tx, &orders[i], models.OrderStatusRefunded, actor, note); err != nil {
+				return err
+			}
+		}
+
+		// 決済の取り消しに失敗した場合は返金の記録も残さない
+		for i := range refunded {
+			payment := &refunded[i]
+			provider, err := h.Providers.Get(payment.Method)
+			if err != nil {
+				return err
+			}
+			if err := provider.Refund(c.Request.Context(), payments.Result{
+				Method:    payment.Method,
+				Amount:    payment.Amount,
+				Tendered:  payment.Tendered,
+				Change:    payment.Change,
+				Reference: payment.Reference,
+			}); err != nil {
+				return fmt.Errorf("%s %s: %w", payment.Method, payment.Reference, err)
+			}
+			payment.Status = models.PaymentRefunded
+		}
+		return nil
+	})
+	if err != nil {
+		if transitionErr, ok := err.(*statusTransitionError); ok {
+			c.JSON(http.StatusConflict, gin.H{"error": transitionErr.Error()})
+			return
+		}
+		if errors.Is(err, errAlreadyRefunded) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("返金に失敗しました（注文 %d）: %v", order.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "決済の取り消しに失敗しました"})
+		return
+	}
+
+	for _, o := range orders {
+		events.Publish(o.StoreID, events.OrderStatusChanged, o)
+	}
+
+	amount := 0
+	for _, payment := range refunded {
+		amount += payment.Amount
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "返金しました",
+		"amount":   amount,
+		"payments": refunded,
+		"orders":   orders,
+	})
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"orderbase/models"
+	"orderbase/payments"
+	"orderbase/payments/paymenttest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// hookProvider 決済の途中で1回だけ処理を差し込める疑似Provider（会計中の注文の追加・同時の会計の再現用）
+type hookProvider struct {
+	*paymenttest.FakeProvider
+	onCharge func()
+}
+
+func (p *hookProvider) Charge(ctx context.Context, req payments.Request) (payments.Result, error) {
+	if hook := p.onCharge; hook != nil {
+		p.onCharge = nil
+		hook()
+	}
+	return p.FakeProvider.Charge(ctx, req)
+}
+
+// checkoutFixture 利用中のテーブルと会計APIのルーター
+type checkoutFixture struct {
+	db      *gorm.DB
+	router  *gin.Engine
+	store   models.Store
+	table   models.Table
+	session models.TableSession
+}
+
+// newCheckoutFixture 1人が着席したテーブルを作成し、指定したProviderで会計できるルーターを用意
+func newCheckoutFixture(t *testing.T, providers ...payments.Provider) *checkoutFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(
+		&models.Store{}, &models.User{}, &models.Product{}, &models.Table{}, &models.TableSession{}, &models.TableToken{},
+		&models.Order{}, &models.OrderItem{}, &models.OrderItemOption{}, &models.OrderStatusEvent{},
+		&models.Payment{}, &models.BillSplit{}, &models.BillSplitPart{}, &models.BillSplitItem{},
+		&models.StockMovement{}, &models.Ingredient{}, &models.RecipeItem{}, &models.IngredientMovement{},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &checkoutFixture{db: db}
+	f.store = models.Store{Name: "テスト店舗", PriceIncludesTax: true}
+	mustCreate(t, db, &f.store)
+	user := models.User{Username: "cashier", StoreID: &f.store.ID, Role: models.RoleStaff}
+	mustCreate(t, db, &user)
+	f.table = models.Table{StoreID: f.store.ID, TableNumber: 1, Capacity: 4, Status: "active"}
+	mustCreate(t, db, &f.table)
+	f.session = models.TableSession{TableID: f.table.ID, PartySize: 2, Status: models.TableSessionOpen, OpenedAt: time.Now()}
+	mustCreate(t, db, &f.session)
+
+	h := &PaymentHandler{DB: db, Providers: payments.NewRegistry(providers...)}
+	f.router = gin.New()
+	f.router.Use(sessions.Sessions("test", cookie.NewStore([]byte("test-session-secret"))))
+	f.router.Use(func(c *gin.Context) {
+		c.Set(currentUserKey, &user)
+		c.Next()
+	})
+	f.router.POST("/tables/:id/checkout", h.CheckoutTable)
+	f.router.POST("/orders/:id/checkout", h.CheckoutOrder)
+	f.router.POST("/orders/:id/refund", h.RefundOrder)
+	f.router.POST("/tables/:id/splits", h.CreateBillSplit)
+	f.router.DELETE("/tables/:id/splits/:split_id", h.CancelBillSplit)
+	f.router.POST("/tables/:id/splits/:split_id/parts/:part_id/pay", h.PayBillSplitPart)
+	f.router.PATCH("/orders/:id/status", func(c *gin.Context) { UpdateOrderStatus(c, db) })
+	f.router.PATCH("/orders/:id/items/:item_id/status", func(c *gin.Context) { UpdateOrderItemStatus(c, db) })
+	f.router.DELETE("/orders/:id", func(c *gin.Context) { DeleteOrder(c, db) })
+	return f
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addOrder 利用中のセッションに税込金額の注文を追加
+func (f *checkoutFixture) addOrder(t *testing.T, amount int) models.Order {
+	t.Helper()
+	order := models.Order{
+		StoreID:          f.store.ID,
+		TableID:          &f.table.ID,
+		TableSessionID:   &f.session.ID,
+		PriceIncludesTax: true,
+		Status:           models.OrderStatusReceived,
+		Items: []models.OrderItem{{
+			Quantity:  1,
+			UnitPrice: amount,
+			LineTotal: amount,
+			TaxRate:   10,
+			Status:    models.OrderStatusReceived,
+		}},
+	}
+	applyOrderTax(&order)
+	mustCreate(t, f.db, &order)
+	return order
+}
+
+// addTakeoutOrder 来店に紐づかない税込金額の注文を追加
+func (f *checkoutFixture) addTakeoutOrder(t *testing.T, amount int) models.Order {
+	t.Helper()
+	order := models.Order{
+		StoreID:          f.store.ID,
+		DiningOption:     "takeout",
+		PriceIncludesTax: true,
+		Status:           models.OrderStatusReceived,
+		Items: []models.OrderItem{{
+			Quantity:  1,
+			UnitPrice: amount,
+			LineTotal: amount,
+			TaxRate:   8,
+			Status:    models.OrderStatusReceived,
+		}},
+	}
+	applyOrderTax(&order)
+	mustCreate(t, f.db, &order)
+	return order
+}
+
+// checkout テーブルの会計APIを呼び出す
+func (f *checkoutFixture) checkout(t *testing.T, reqs ...PaymentRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.post(t, "/tables/"+strconv.FormatUint(uint64(f.table.ID), 10)+"/checkout", gin.H{"payments": reqs})
+}
+
+// checkoutOrder 注文単位の会計APIを呼び出す
+func (f *checkoutFixture) checkoutOrder(t *testing.T, orderID uint, reqs ...PaymentRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.post(t, "/orders/"+strconv.FormatUint(uint64(orderID), 10)+"/checkout", gin.H{"payments": reqs})
+}
+
+func (f *checkoutFixture) post(t *testing.T, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.request(t, http.MethodPost, path, payload)
+}
+
+// request JSONのリクエストをルーターに送る
+func (f *checkoutFixture) request(t *testing.T, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, req)
+	return w
+}
+
+func (f *checkoutFixture) countPayments(t *testing.T) int64 {
+	t.Helper()
+	var count int64
+	if err := f.db.Model(&models.Payment{}).Count(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func (f *checkoutFixture) orderStatus(t *testing.T, orderID uint) string {
+	t.Helper()
+	var order models.Order
+	if err := f.db.First(&order, orderID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return order.Status
+}
+
+func (f *checkoutFixture) sessionStatus(t *testing.T) string {
+	t.Helper()
+	var session models.TableSession
+	if err := f.db.First(&session, f.session.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	return session.Status
+}
+
+func TestCheckoutTable(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, payments.CashProvider{}, card)
+	order := f.addOrder(t, 1000)
+
+	w := f.checkout(t,
+		PaymentRequest{Method: payments.MethodCash, Amount: 600, Tendered: 1000},
+		PaymentRequest{Method: payments.MethodCard, Amount: 400},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	var res struct {
+		BillTotal int `json:"bill_total"`
+		Change    int `json:"change"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.BillTotal != 1000 || res.Change != 400 {
+		t.Errorf("bill_total = %d, change = %d; want 1000, 400", res.BillTotal, res.Change)
+	}
+
+	if len(card.Charges) != 1 || len(card.Refunded) != 0 {
+		t.Errorf("card charges = %d, refunds = %d; want 1, 0", len(card.Charges), len(card.Refunded))
+	}
+	if got := f.countPayments(t); got != 2 {
+		t.Errorf("payments = %d, want 2", got)
+	}
+	if got := f.orderStatus(t, order.ID); got != models.OrderStatusPaid {
+		t.Errorf("order status = %q, want %q", got, models.OrderStatusPaid)
+	}
+	if got := f.sessionStatus(t); got != models.TableSessionClosed {
+		t.Errorf("session status = %q, want %q", got, models.TableSessionClosed)
+	}
+}
+
+func TestCheckoutTableRefundsWhenLaterPaymentDeclined(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	qr := paymenttest.NewFakeProvider(payments.MethodQR)
+	qr.Decline = true
+	f := newCheckoutFixture(t, card, qr)
+	order := f.addOrder(t, 1000)
+
+	w := f.checkout(t,
+		PaymentRequest{Method: payments.MethodCard, Amount: 600},
+		PaymentRequest{Method: payments.MethodQR, Amount: 400},
+	)
+	if w.Code != http.StatusPaymentRequired {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// 先に決済したカードは取り消され、会計は記録されない
+	if len(card.Charges) != 1 || len(card.Refunded) != 1 {
+		t.Errorf("card charges = %d, refunds = %d; want 1, 1", len(card.Charges), len(card.Refunded))
+	}
+	if got := f.countPayments(t); got != 0 {
+		t.Errorf("payments = %d, want 0", got)
+	}
+	if got := f.orderStatus(t, order.ID); got != models.OrderStatusReceived {
+		t.Errorf("order status = %q, want %q", got, models.OrderStatusReceived)
+	}
+	if got := f.sessionStatus(t); got != models.TableSessionOpen {
+		t.Errorf("session status = %q, want %q", got, models.TableSessionOpen)
+	}
+}
+
+func TestCheckoutTableRefundsWhenOrderAddedDuringCharge(t *testing.T) {
+	card := &hookProvider{FakeProvider: paymenttest.NewFakeProvider(payments.MethodCard)}
+	f := newCheckoutFixture(t, card)
+	order := f.addOrder(t, 1000)
+
+	var added models.Order
+	card.onCharge = func() { added = f.addOrder(t, 500) }
+
+	w := f.checkout(t, PaymentRequest{Method: payments.MethodCard, Amount: 1000})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// 決済後に追加された注文を残したまま会計せず、決済を取り消す
+	if len(card.Refunded) != 1 {
+		t.Errorf("card refunds = %d, want 1", len(card.Refunded))
+	}
+	if got := f.countPayments(t); got != 0 {
+		t.Errorf("payments = %d, want 0", got)
+	}
+	for _, id := range []uint{order.ID, added.ID} {
+		if got := f.orderStatus(t, id); got != models.OrderStatusReceived {
+			t.Errorf("order %d status = %q, want %q", id, got, models.OrderStatusReceived)
+		}
+	}
+	if got := f.sessionStatus(t); got != models.TableSessionOpen {
+		t.Errorf("session status = %q, want %q", got, models.TableSessionOpen)
+	}
+}
+
+func TestCheckoutTableTwice(t *testing.T) {
+	card := &hookProvider{FakeProvider: paymenttest.NewFakeProvider(payments.MethodCard)}
+	f := newCheckoutFixture(t, card)
+	f.addOrder(t, 1000)
+
+	// 1回目の決済中に別の端末から同じテーブルを会計する
+	var second *httptest.ResponseRecorder
+	card.onCharge = func() {
+		second = f.checkout(t, PaymentRequest{Method: payments.MethodCard, Amount: 1000})
+	}
+
+	first := f.checkout(t, PaymentRequest{Method: payments.MethodCard, Amount: 1000})
+	if second.Code != http.StatusOK {
+		t.Fatalf("second checkout status = %d, body = %s", second.Code, second.Body)
+	}
+	if first.Code != http.StatusConflict {
+		t.Fatalf("first checkout status = %d, body = %s", first.Code, first.Body)
+	}
+
+	// 後から完了した会計の決済だけが取り消され、支払いは1回分のみ記録される
+	if len(card.Charges) != 2 || len(card.Refunded) != 1 {
+		t.Errorf("card charges = %d, refunds = %d; want 2, 1", len(card.Charges), len(card.Refunded))
+	}
+	if got := f.countPayments(t); got != 1 {
+		t.Errorf("payments = %d, want 1", got)
+	}
+
+	// 会計済みのテーブルは決済せずに拒否する
+	w := f.checkout(t, PaymentRequest{Method: payments.MethodCard, Amount: 1000})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("third checkout status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(card.Charges) != 2 {
+		t.Errorf("card charges = %d, want 2", len(card.Charges))
+	}
+}
+
+func TestCheckoutOrder(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, card)
+	order := f.addTakeoutOrder(t, 540)
+
+	w := f.checkoutOrder(t, order.ID, PaymentRequest{Method: payments.MethodCard, Amount: 540})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if got := f.orderStatus(t, order.ID); got != models.OrderStatusPaid {
+		t.Errorf("order status = %q, want %q", got, models.OrderStatusPaid)
+	}
+	var payment models.Payment
+	if err := f.db.First(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if payment.OrderID == nil || *payment.OrderID != order.ID || payment.TableSessionID != nil {
+		t.Errorf("payment order_id = %v, table_session_id = %v; want %d, nil", payment.OrderID, payment.TableSessionID, order.ID)
+	}
+
+	// 会計済みの注文は決済せずに拒否する
+	w = f.checkoutOrder(t, order.ID, PaymentRequest{Method: payments.MethodCard, Amount: 540})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("second checkout status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(card.Charges) != 1 {
+		t.Errorf("card charges = %d, want 1", len(card.Charges))
+	}
+}
+
+func TestCheckoutOrderRejectsTableOrder(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, card)
+	order := f.addOrder(t, 1000)
+
+	w := f.checkoutOrder(t, order.ID, PaymentRequest{Method: payments.MethodCard, Amount: 1000})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(card.Charges) != 0 {
+		t.Errorf("card charges = %d, want 0", len(card.Charges))
+	}
+}
+
+// refund 返金APIを呼び出す
+func (f *checkoutFixture) refund(t *testing.T, orderID uint) *httptest.ResponseRecorder {
+	t.Helper()
+	return f.post(t, "/orders/"+strconv.FormatUint(uint64(orderID), 10)+"/refund", gin.H{"reason": "注文間違い"})
+}
+
+func (f *checkoutFixture) paymentStatuses(t *testing.T) []string {
+	t.Helper()
+	var statuses []string
+	if err := f.db.Model(&models.Payment{}).Order("id").Pluck("status", &statuses).Error; err != nil {
+		t.Fatal(err)
+	}
+	return statuses
+}
+
+func TestRefundTableCheckout(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, payments.CashProvider{}, card)
+	first := f.addOrder(t, 1000)
+	second := f.addOrder(t, 500)
+
+	w := f.checkout(t,
+		PaymentRequest{Method: payments.MethodCash, Amount: 700},
+		PaymentRequest{Method: payments.MethodCard, Amount: 800},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("checkout status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// テーブルの会計はまとめて支払われたため、1件の注文から同じ来店の注文をすべて返金する
+	w = f.refund(t, second.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refund status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(card.Refunded) != 1 || card.Refunded[0].Amount != 800 {
+		t.Errorf("card refunds = %+v, want one refund of 800", card.Refunded)
+	}
+	for _, status := range f.paymentStatuses(t) {
+		if status != models.PaymentRefunded {
+			t.Errorf("payment status = %q, want %q", status, models.PaymentRefunded)
+		}
+	}
+	for _, id := range []uint{first.ID, second.ID} {
+		if got := f.orderStatus(t, id); got != models.OrderStatusRefunded {
+			t.Errorf("order %d status = %q, want %q", id, got, models.OrderStatusRefunded)
+		}
+	}
+	var event models.OrderStatusEvent
+	if err := f.db.Where("order_id = ? AND to_status = ?", first.ID, models.OrderStatusRefunded).First(&event).Error; err != nil {
+		t.Fatalf("refund event: %v", err)
+	}
+	if event.FromStatus != models.OrderStatusPaid || event.Note != "返金: 注文間違い" {
+		t.Errorf("event = from %q, note %q; want paid, 返金: 注文間違い", event.FromStatus, event.Note)
+	}
+
+	// 返金済みの注文は再度返金できない
+	if w := f.refund(t, first.ID); w.Code != http.StatusConflict {
+		t.Fatalf("second refund status = %d, body = %s", w.Code, w.Body)
+	}
+	if len(card.Refunded) != 1 {
+		t.Errorf("card refunds = %d, want 1", len(card.Refunded))
+	}
+}
+
+func TestRefundOrderCheckout(t *testing.T) {
+	card := paymenttest.NewFakeProvider(payments.MethodCard)
+	f := newCheckoutFixture(t, card)
+	order := f.addTakeoutOrder(t, 540)
+	other := f.addTakeoutOrder(t, 300)
+
+	for _, o := range []models.Order{order, other} {
+		if w := f.checkoutOrder(t, o.ID, PaymentRequest{Method: payments.MethodCard, Amount: o.TotalPrice}); w.Code != http.StatusOK {
+			t.Fatalf("checkout status = %d, body = %s", w.Code, w.Body)
+		}
+	}
+
+	if w := f.refund(t, order.ID); w.Code != http.StatusOK {
+		t.Fatalf("refund status = %d, body = %s", w.Code, w.Body)
+	}
+
+	// 注文単位の会計は対象の注文の支払いだけを返金する
+	if len(card.Refunded) != 1 || card.Refunded[0].Amount != 540 {
+		t.Errorf("card refunds = %+v, want one refund of 540", card.Refunded)
+	}
+	if got := f.paymentStatuses(t); len(got) != 2 || got[0] != models.PaymentRefunded || got[1] != models.PaymentCompleted {
+		t.Errorf("payment statuses = %v, want [refunded completed]", got)
+	}
+	if got := f.orderStatus(t, order.ID); got != models.OrderStatusRefunded {
+		t.Errorf("order status = %q, want %q", got, models.OrderStatusRefunded)
+	}
+	if got := f.orderStatus(t, other.ID); got != models.OrderStatusPaid {
+		t.Errorf("other order status = %q, want %q", got, models.OrderStatusPaid)
+	}
+}
+
+func TestRefundRejectsUnpaidOrder(t *testing.T) {
+	f := newCheckoutFixture(t, payments.CashProvider{})
+	order := f.addOrder(t, 1000)
+
+	if w := f.refund(t, order.ID); w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body)
+	}
+	if got := f.orderStatus(t, order.ID); got != models.OrderStatusReceived {
+		t.Errorf("order status = %q, want %q", got, models.OrderStatusReceived)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission ログイン中のユーザーの役割が操作を許可されているか確認するミドルウェア
+// RequireLogin の後に使う（役割はリクエストごとに取得したユーザーのものを使うため、変更はすぐ反映される）
+// APIトークンで認証した場合は、トークンのスコープにも含まれている必要がある
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			abortUnauthorized(c)
+			return
+		}
+		if !models.RoleHasPermission(user.Role, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "この操作を行う権限がありません"})
+			return
+		}
+
+		if token := CurrentAPIToken(c); token != nil && !token.HasScope(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "このAPIトークンには操作の権限がありません"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"orderbase/billing"
+	"orderbase/models"
+	"orderbase/printing"
+	"orderbase/receipt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type PrinterHandler struct {
+	DB      *gorm.DB
+	Spooler *printing.Spooler
+}
+
+// 調理伝票に印字する調理場所の名前
+var stationLabels = map[string]string{
+	models.StationKitchen: "キッチン",
+	models.StationDrinks:  "ドリンク",
+	models.StationDessert: "デザート",
+}
+
+// PrinterRequest プリンター登録・更新リクエスト
+type PrinterRequest struct {
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Kind    string  `json:"kind"`
+	Station *string `json:"station"` // 空文字列の場合はすべての調理場所
+	Enabled *bool   `json:"enabled"`
+}
+
+// queueKitchenTickets 注文の明細を調理場所ごとの調理伝票にして印刷ジョブを登録
+// 担当の調理場所が一致するか、担当を指定していないキッチンプリンターに送る
+func queueKitchenTickets(db *gorm.DB, spooler *printing.Spooler, order models.Order) error {
+	if spooler == nil {
+		return nil
+	}
+
+	var printers []models.Printer
+	if err := db.Where("store_id = ? AND kind = ? AND enabled = ?", order.StoreID, models.PrinterKitchen, true).Find(&printers).Error; err != nil {
+		return err
+	}
+	if len(printers) == 0 {
+		return nil
+	}
+
+	tableNumber := 0
+	if order.TableID != nil {
+		var table models.Table
+		if err := db.First(&table, *order.TableID).Error; err == nil {
+			tableNumber = table.TableNumber
+		}
+	}
+
+	// 調理場所ごとにまとめる（明細の順序は保つ）
+	var stations []string
+	itemsByStation := make(map[string][]printing.TicketItem)
+	for _, item := range order.Items {
+		if item.Status == models.OrderStatusCancelled {
+			continue
+		}
+		if _, ok := itemsByStation[item.Station]; !ok {
+			stations = append(stations, item.Station)
+		}
+		itemsByStation[item.Station] = append(itemsByStation[item.Station], printing.TicketItem{
+			Name:     item.Product.Name,
+			Quantity: item.Quantity,
+			Options:  optionLabels(item.Options),
+		})
+	}
+
+	for _, station := range stations {
+		label := stationLabels[station]
+		if label == "" {
+			label = station
+		}
+		data := printing.KitchenTicket(printing.Ticket{
+			OrderID:     order.ID,
+			TableNumber: tableNumber,
+			Station:     label,
+			Takeout:     order.DiningOption == billing.DiningTakeout,
+			OrderedAt:   order.CreatedAt,
+			Items:       itemsByStation[station],
+		})
+		for i := range printers {
+			printer := &printers[i]
+			if printer.Station != "" && printer.Station != station {
+				continue
+			}
+			if _, err := spooler.Enqueue(db, printer, models.PrintJobKitchenTicket, data, &order.ID, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// findReceiptPrinter 店舗のレシートプリンターを取得（IDを指定しない場合は最初に登録された有効なもの、なければnil）
+func findReceiptPrinter(db *gorm.DB, storeID, printerID uint) (*models.Printer, error) {
+	query := db.Where("store_id = ? AND kind = ? AND enabled = ?", storeID, models.PrinterReceipt, true)
+	if printerID > 0 {
+		query = query.Where("id = ?", printerID)
+	}
+	var printer models.Printer
+	err := query.Order("id ASC").First(&printer).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &printer, nil
+}
+
+// queueReceiptPrint 領収書の印刷ジョブを登録
+func queueReceiptPrint(db *gorm.DB, spooler *printing.Spooler, printer *models.Printer, r models.Receipt, duplicate bool) {
+	data := printing.Receipt(receipt.TextLines(receiptDocument(r, duplicate)))
+	if _, err := spooler.Enqueue(db, printer, models.PrintJobReceipt, data, nil, &r.ID); err != nil {
+		log.Printf("領収書の印刷ジョブの登録に失敗しました（No.%d）: %v", r.Number, err)
+	}
+}
+
+// validatePrinter プリンターの設定を検証し、エラーメッセージを返す
+func validatePrinter(printer models.Printer) string {
+	if printer.Name == "" || printer.Address == "" {
+		return "名前とアドレスは必須です"
+	}
+	if printer.Kind != models.PrinterKitchen && printer.Kind != models.PrinterReceipt {
+		return "不正なプリンターの種類です"
+	}
+	if printer.Station != "" && !models.IsValidStation(printer.Station) {
+		return "不正な調理場所です"
+	}
+	return ""
+}
+
+// loadPrinter URLのIDから店舗のプリンターを取得（見つからない場合はレスポンス済み）
+func (h *PrinterHandler) loadPrinter(c *gin.Context, storeID uint) (*models.Printer, bool) {
+	printerID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return nil, false
+	}
+	var printer models.Printer
+	if err := h.DB.Where("store_id = ?", storeID).First(&printer, printerID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "プリンターが見つかりません"})
+		return nil, false
+	}
+	return &printer, true
+}
+
+// GetPrinters プリンター一覧を取得
+func (h *PrinterHandler) GetPrinters(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var printers []models.Printer
+	if err := h.DB.Where("store_id = ?", storeID).Order("id ASC").Find(&printers).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プリンターの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"printers": printers})
+}
+
+// CreatePrinter プリンターを登録
+func (h *PrinterHandler) CreatePrinter(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req PrinterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	printer := models.Printer{
+		StoreID: storeID,
+		Name:    req.Name,
+		Address: req.Address,
+		Kind:    req.Kind,
+		Enabled: true,
+	}
+	if req.Station != nil {
+		printer.Station = *req.Station
+	}
+	if req.Enabled != nil {
+		printer.Enabled = *req.Enabled
+	}
+	if msg := validatePrinter(printer); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&printer).Error; err != nil {
+			return err
+		}
+		// enabled は default:true のため、無効で登録する場合は明示的に更新する
+		if !printer.Enabled {
+			return tx.Model(&printer).Update("enabled", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プリンターの登録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "プリンターを登録しました",
+		"printer": printer,
+	})
+}
+
+// UpdatePrinter プリンターの設定を更新
+func (h *PrinterHandler) UpdatePrinter(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	printer, ok := h.loadPrinter(c, storeID)
+	if !ok {
+		return
+	}
+
+	var req PrinterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Name != "" {
+		printer.Name = req.Name
+	}
+	if req.Address != "" {
+		printer.Address = req.Address
+	}
+	if req.Kind != "" {
+		printer.Kind = req.Kind
+	}
+	// 調理場所は空文字列で「すべて」に戻せる
+	if req.Station != nil {
+		printer.Station = *req.Station
+	}
+	if req.Enabled != nil {
+		printer.Enabled = *req.Enabled
+	}
+	if msg := validatePrinter(*printer); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.DB.Model(printer).Updates(map[string]interface{}{
+		"name":    printer.Name,
+		"address": printer.Address,
+		"kind":    printer.Kind,
+		"station": printer.Station,
+		"enabled": printer.Enabled,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プリンターの更新に失敗しました"})
+		return
+	}
+	// 有効にした場合は保留中のジョブを送信する
+	h.Spooler.Wake()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "プリンターを更新しました",
+		"printer": printer,
+	})
+}
+
+// DeletePrinter プリンターを削除（未送信のジョブは取り消す）
+func (h *PrinterHandler) DeletePrinter(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	printer, ok := h.loadPrinter(c, storeID)
+	if !ok {
+		return
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PrintJob{}).
+			Where("printer_id = ? AND status = ?", printer.ID, models.PrintJobQueued).
+			Update("status", models.PrintJobCancelled).Error; err != nil {
+			return err
+		}
+		return tx.Delete(printer).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プリンターの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "プリンターを削除しました"})
+}
+
+// TestPrinter テスト印刷のジョブを登録
+func (h *PrinterHandler) TestPrinter(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	printer, ok := h.loadPrinter(c, storeID)
+	if !ok {
+		return
+	}
+
+	job, err := h.Spooler.Enqueue(h.DB, printer, models.PrintJobTest, printing.TestPage(printer.Name, time.Now()), nil, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "印刷ジョブの登録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "テスト印刷を登録しました",
+		"job":     job,
+	})
+}
+
+// GetPrintJobs 印刷ジョブの一覧を取得（新しい順に最大100件）
+func (h *PrinterHandler) GetPrintJobs(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	query := h.DB.Where("store_id = ?", storeID).Preload("Printer").Order("id DESC").Limit(100)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if printerID := c.Query("printer_id"); printerID != "" {
+		query = query.Where("printer_id = ?", printerID)
+	}
+
+	var jobs []models.PrintJob
+	if err := query.Find(&jobs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "印刷ジョブの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// loadPrintJob URLのIDから店舗の印刷ジョブを取得（見つからない場合はレスポンス済み）
+func (h *PrinterHandler) loadPrintJob(c *gin.Context, storeID uint) (*models.PrintJob, bool) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return nil, false
+	}
+	var job models.PrintJob
+	if err := h.DB.Where("store_id = ?", storeID).Preload("Printer").First(&job, jobID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "印刷ジョブが見つかりません"})
+		return nil, false
+	}
+	return &job, true
+}
+
+// GetPrintJob 印刷ジョブの状態を取得
+func (h *PrinterHandler) GetPrintJob(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	job, ok := h.loadPrintJob(c, storeID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// RetryPrintJob 失敗・取り消したジョブを再送する
+func (h *PrinterHandler) RetryPrintJob(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	job, ok := h.loadPrintJob(c, storeID)
+	if !ok {
+		return
+	}
+	if job.Status != models.PrintJobFailed && job.Status != models.PrintJobCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "失敗または取り消したジョブのみ再送できます"})
+		return
+	}
+
+	job.Status = models.PrintJobQueued
+	job.Attempts = 0
+	job.NextAttemptAt = time.Now()
+	if err := h.DB.Model(job).Updates(map[string]interface{}{
+		"status":          job.Status,
+		"attempts":        job.Attempts,
+		"next_attempt_at": job.NextAttemptAt,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "印刷ジョブの更新に失敗しました"})
+		return
+	}
+	h.Spooler.Wake()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "再送を登録しました",
+		"job":     job,
+	})
+}
+
+// CancelPrintJob 未送信のジョブを取り消す
+func (h *PrinterHandler) CancelPrintJob(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	job, ok := h.loadPrintJob(c, storeID)
+	if !ok {
+		return
+	}
+
+	// 送信中のジョブは取り消さない
+	result := h.DB.Model(&models.PrintJob{}).
+		Where("id = ? AND status = ?", job.ID, models.PrintJobQueued).
+		Update("status", models.PrintJobCancelled)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "印刷ジョブの更新に失敗しました"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "送信待ちのジョブのみ取り消せます"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "印刷ジョブを取り消しました"})
+}
@@ -3,34 +3,44 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"orderbase/billing"
 	"orderbase/models"
+	"path/filepath"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type ProductHandler struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	UploadDir string // 商品画像の保存先（/uploads で公開される）
 }
 
 func (h *ProductHandler) AddProductWithImage(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.Where("username = ?", username.(string)).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	user := CurrentUser(c)
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	name := c.PostForm("name")
 	priceStr := c.PostForm("price")
 	labels := c.PostForm("labels")
+	station := c.DefaultPostForm("station", models.StationKitchen)
+	if !models.IsValidStation(station) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な調理場所です"})
+		return
+	}
+	taxCategory := c.DefaultPostForm("tax_category", billing.TaxCategoryReduced)
+	if !billing.IsValidTaxCategory(taxCategory) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な税区分です"})
+		return
+	}
+	categoryID, msg := parseCategoryID(h.DB, storeID, c.PostForm("category_id"))
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
 
 	file, err := c.FormFile("image")
 	if err != nil {
@@ -38,8 +48,8 @@ func (h *ProductHandler) AddProductWithImage(c *gin.Context) {
 		return
 	}
 
-	imagePath := "uploads/" + file.Filename
-	if err := c.SaveUploadedFile(file, imagePath); err != nil {
+	filename := filepath.Base(file.Filename)
+	if err := c.SaveUploadedFile(file, filepath.Join(h.UploadDir, filename)); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "画像保存失敗"})
 		return
 	}
@@ -47,12 +57,26 @@ func (h *ProductHandler) AddProductWithImage(c *gin.Context) {
 	var price int
 	fmt.Sscanf(priceStr, "%d", &price)
 
+	// 表示順を省略した場合はカテゴリーの末尾に追加
+	var sortOrder int
+	if sortOrderStr := c.PostForm("sort_order"); sortOrderStr != "" {
+		fmt.Sscanf(sortOrderStr, "%d", &sortOrder)
+	} else if sortOrder, err = nextProductSortOrder(h.DB, storeID, categoryID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品登録失敗"})
+		return
+	}
+
 	product := models.Product{
-		Name:      name,
-		Price:     price,
-		ImagePath: "/" + imagePath,
-		Labels:    labels,
-		UserID:    user.ID,
+		Name:        name,
+		Price:       price,
+		ImagePath:   "/uploads/" + filename,
+		Labels:      labels,
+		Station:     station,
+		TaxCategory: taxCategory,
+		CategoryID:  categoryID,
+		SortOrder:   sortOrder,
+		StoreID:     storeID,
+		UserID:      user.ID,
 	}
 
 	if err := h.DB.Create(&product).Error; err != nil {
@@ -64,21 +88,13 @@ func (h *ProductHandler) AddProductWithImage(c *gin.Context) {
 }
 
 func (h *ProductHandler) GetMyProducts(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.Where("username = ?", username.(string)).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	var products []models.Product
-	if err := h.DB.Preload("User").Where("user_id = ?", user.ID).Find(&products).Error; err != nil {
+	if err := h.DB.Preload("User").Where("store_id = ?", storeID).Find(&products).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品取得失敗"})
 		return
 	}
@@ -87,31 +103,23 @@ func (h *ProductHandler) GetMyProducts(c *gin.Context) {
 }
 
 func (h *ProductHandler) DeleteProduct(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.Where("username = ?", username.(string)).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	productID := c.Param("id")
 
-	// まず商品が存在し、かつユーザーの所有物であることを確認
+	// まず商品が存在し、かつ自分の店舗の商品であることを確認
 	var product models.Product
 	if err := h.DB.First(&product, productID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
 		return
 	}
 
-	// 所有者チェック
-	if product.UserID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "他のユーザーの商品は削除できません"})
+	// 店舗チェック
+	if product.StoreID != storeID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "他の店舗の商品は削除できません"})
 		return
 	}
 
@@ -125,31 +133,23 @@ func (h *ProductHandler) DeleteProduct(c *gin.Context) {
 }
 
 func (h *ProductHandler) UpdateProduct(c *gin.Context) {
-	session := sessions.Default(c)
-	username := session.Get("user")
-	if username == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未ログイン"})
-		return
-	}
-
-	var user models.User
-	if err := h.DB.Where("username = ?", username.(string)).First(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	productID := c.Param("id")
 
-	// 商品が存在し、かつユーザーの所有物であることを確認
+	// 商品が存在し、かつ自分の店舗の商品であることを確認
 	var product models.Product
 	if err := h.DB.First(&product, productID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
 		return
 	}
 
-	// 所有者チェック
-	if product.UserID != user.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "他のユーザーの商品は編集できません"})
+	// 店舗チェック
+	if product.StoreID != storeID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "他の店舗の商品は編集できません"})
 		return
 	}
 
@@ -157,6 +157,9 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	name := c.PostForm("name")
 	priceStr := c.PostForm("price")
 	labels := c.PostForm("labels")
+	station := c.PostForm("station")
+	taxCategory := c.PostForm("tax_category")
+	sortOrderStr := c.PostForm("sort_order")
 
 	// 更新
 	if name != "" {
@@ -169,6 +172,43 @@ func (h *ProductHandler) UpdateProduct(c *gin.Context) {
 	}
 	// ラベルは空文字列も許可（削除できるように）
 	product.Labels = labels
+	if station != "" {
+		if !models.IsValidStation(station) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不正な調理場所です"})
+			return
+		}
+		product.Station = station
+	}
+	if taxCategory != "" {
+		if !billing.IsValidTaxCategory(taxCategory) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不正な税区分です"})
+			return
+		}
+		product.TaxCategory = taxCategory
+	}
+	// カテゴリーは指定された場合のみ変更（空文字列・0 で未分類に戻す）
+	if categoryStr, ok := c.GetPostForm("category_id"); ok {
+		categoryID, msg := parseCategoryID(h.DB, storeID, categoryStr)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		// 別のカテゴリーに移した場合は表示順を省略すると末尾に追加
+		if !sameCategoryID(categoryID, product.CategoryID) && sortOrderStr == "" {
+			sortOrder, err := nextProductSortOrder(h.DB, storeID, categoryID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+				return
+			}
+			product.SortOrder = sortOrder
+		}
+		product.CategoryID = categoryID
+	}
+	if sortOrderStr != "" {
+		var sortOrder int
+		fmt.Sscanf(sortOrderStr, "%d", &sortOrder)
+		product.SortOrder = sortOrder
+	}
 
 	if err := h.DB.Save(&product).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"orderbase/billing"
+	"orderbase/models"
+	"orderbase/printing"
+	"orderbase/receipt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ReceiptHandler struct {
+	DB      *gorm.DB
+	Spooler *printing.Spooler
+}
+
+// 領収書の出力形式
+var receiptFormats = map[string]bool{"json": true, "text": true, "html": true, "pdf": true}
+
+// receiptDocument 保存した領収書から出力内容を作成
+func receiptDocument(r models.Receipt, duplicate bool) receipt.Document {
+	doc := receipt.Document{
+		Number:             receipt.FormatNumber(r.Number),
+		IssuedAt:           r.IssuedAt,
+		StoreName:          r.StoreName,
+		RegistrationNumber: r.RegistrationNumber,
+		Recipient:          r.Recipient,
+		Duplicate:          duplicate,
+		PriceIncludesTax:   r.PriceIncludesTax,
+		Subtotal:           r.Subtotal,
+		Tax:                r.TaxAmount,
+		Total:              r.Total,
+	}
+	if r.TableNumber > 0 {
+		doc.Note = fmt.Sprintf("テーブル %d", r.TableNumber)
+	}
+	for _, line := range r.Lines {
+		doc.Lines = append(doc.Lines, receipt.Line{
+			Name:      line.Name,
+			Quantity:  line.Quantity,
+			UnitPrice: line.UnitPrice,
+			Amount:    line.Amount,
+			Rate:      line.TaxRate,
+		})
+	}
+	for _, t := range r.Taxes {
+		doc.Taxes = append(doc.Taxes, billing.RateTotal{Rate: t.Rate, Subtotal: t.Subtotal, Tax: t.Tax, Total: t.Total})
+	}
+	return doc
+}
+
+// writeReceipt 指定の形式（json, text, html, pdf）で領収書を返す
+func writeReceipt(c *gin.Context, r models.Receipt, format string, duplicate bool) {
+	doc := receiptDocument(r, duplicate)
+	switch format {
+	case "text":
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(receipt.Text(doc)))
+	case "html":
+		body, err := receipt.HTML(doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "領収書の作成に失敗しました"})
+			return
+		}
+		c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=receipt-%s.pdf", doc.Number))
+		c.Data(http.StatusOK, "application/pdf", receipt.PDF(doc))
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"receipt":   r,
+			"duplicate": duplicate,
+		})
+	}
+}
+
+// receiptPrinterFromQuery ?print=true の場合に印刷先のレシートプリンターを取得
+// 印刷しない場合はnil（取得できない場合はレスポンス済み）
+func (h *ReceiptHandler) receiptPrinterFromQuery(c *gin.Context, storeID uint) (*models.Printer, bool) {
+	if c.Query("print") != "true" {
+		return nil, true
+	}
+	printerID, _ := strconv.ParseUint(c.Query("printer_id"), 10, 32)
+	printer, err := findReceiptPrinter(h.DB, storeID, uint(printerID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プリンターの取得に失敗しました"})
+		return nil, false
+	}
+	if printer == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "有効なレシートプリンターが登録されていません"})
+		return nil, false
+	}
+	return printer, true
+}
+
+// findIssuedReceipt 会計・注文に発行済みの領収書を取得（なければnil）
+func findIssuedReceipt(db *gorm.DB, tableSessionIDs []uint, orderIDs []uint) (*models.Receipt, error) {
+	var r models.Receipt
+	err := db.Where("table_session_id IN ? OR order_id IN ?", tableSessionIDs, orderIDs).First(&r).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// IssueReceipt 会計済みのテーブル会計または注文の領収書を発行（?print=true でレシートプリンターに印刷）
+func (h *ReceiptHandler) IssueReceipt(c *gin.Context) {
+	userID := currentUserID(c)
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TableSessionID *uint  `json:"table_session_id"`
+		OrderID        *uint  `json:"order_id"`
+		Recipient      string `json:"recipient"` // 宛名（任意）
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if (req.TableSessionID == nil) == (req.OrderID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "会計（table_session_id）または注文（order_id）のどちらかを指定してください"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if !receiptFormats[format] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な出力形式です"})
+		return
+	}
+	printer, ok := h.receiptPrinterFromQuery(c, storeID)
+	if !ok {
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+	if store.InvoiceNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "先に適格請求書発行事業者の登録番号を設定してください"})
+		return
+	}
+
+	r := models.Receipt{
+		StoreID:            storeID,
+		Recipient:          req.Recipient,
+		StoreName:          store.Name,
+		RegistrationNumber: store.InvoiceNumber,
+		IssuedAt:           time.Now(),
+	}
+	uid := userID
+	r.IssuedBy = &uid
+
+	// 対象の注文を取得（会計済みのみ）
+	var orders []models.Order
+	var tableSessionIDs []uint
+	if req.TableSessionID != nil {
+		var tableSession models.TableSession
+		storeTables := h.DB.Model(&models.Table{}).Select("id").Where("store_id = ?", storeID)
+		if err := h.DB.Preload("Table").Where("table_id IN (?)", storeTables).First(&tableSession, *req.TableSessionID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "会計が見つかりません"})
+			return
+		}
+		var openOrders int64
+		if err := h.DB.Model(&models.Order{}).
+			Where("table_session_id = ? AND status IN ?", tableSession.ID, models.OpenOrderStatuses).
+			Count(&openOrders).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+			return
+		}
+		if openOrders > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "未会計の注文があります"})
+			return
+		}
+		if err := h.DB.Where("table_session_id = ? AND status = ?", tableSession.ID, models.OrderStatusPaid).
+			Preload("Items", "status <> ?", models.OrderStatusCancelled).
+			Preload("Items.Product").
+			Preload("Items.Options").
+			Order("created_at ASC").
+			Find(&orders).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+			return
+		}
+		r.TableSessionID = &tableSession.ID
+		r.TableNumber = tableSession.Table.TableNumber
+		tableSessionIDs = append(tableSessionIDs, tableSession.ID)
+	} else {
+		var order models.Order
+		if err := h.DB.Preload("Items", "status <> ?", models.OrderStatusCancelled).
+			Preload("Items.Product").
+			Preload("Items.Options").
+			Preload("Table").
+			Where("store_id = ?", storeID).
+			First(&order, *req.OrderID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "注文が見つかりません"})
+			return
+		}
+		if order.Status == models.OrderStatusPaid {
+			orders = append(orders, order)
+		}
+		r.OrderID = &order.ID
+		if order.Table != nil {
+			r.TableNumber = order.Table.TableNumber
+		}
+		if order.TableSessionID != nil {
+			tableSessionIDs = append(tableSessionIDs, *order.TableSessionID)
+		}
+	}
+	if len(orders) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "会計済みの注文がありません"})
+		return
+	}
+
+	// 同じ会計に領収書を二重に発行しない（必要な場合は再発行する）
+	orderIDs := make([]uint, 0, len(orders))
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.ID)
+	}
+	issued, err := findIssuedReceipt(h.DB, tableSessionIDs, orderIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "領収書の取得に失敗しました"})
+		return
+	}
+	if issued != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "領収書は発行済みです。再発行してください", "receipt_id": issued.ID})
+		return
+	}
+
+	for _, order := range orders {
+		for _, item := range order.Items {
+			name := item.Product.Name
+			if name == "" {
+				name = fmt.Sprintf("商品 #%d", item.ProductID)
+			}
+			if len(item.Options) > 0 {
+				name += "（" + strings.Join(optionLabels(item.Options), "・") + "）"
+			}
+			r.Lines = append(r.Lines, models.ReceiptLine{
+				OrderItemID: item.ID,
+				Name:        name,
+				Quantity:    item.Quantity,
+				UnitPrice:   item.UnitPrice,
+				Amount:      item.LineTotal,
+				TaxRate:     item.TaxRate,
+			})
+		}
+	}
+	summary := billTax(orders)
+	r.PriceIncludesTax = summary.PriceIncludesTax
+	r.Subtotal = summary.Subtotal
+	r.TaxAmount = summary.Tax
+	r.Total = summary.Total
+	for _, t := range summary.Rates {
+		r.Taxes = append(r.Taxes, models.ReceiptTax{Rate: t.Rate, Subtotal: t.Subtotal, Tax: t.Tax, Total: t.Total})
+	}
+
+	// 領収書番号は店舗ごとの発行順の連番
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		var last uint
+		if err := tx.Model(&models.Receipt{}).Where("store_id = ?", storeID).Select("COALESCE(MAX(number), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		r.Number = last + 1
+		return tx.Create(&r).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "領収書の発行に失敗しました"})
+		return
+	}
+	if printer != nil {
+		queueReceiptPrint(h.DB, h.Spooler, printer, r, false)
+	}
+
+	writeReceipt(c, r, format, false)
+}
+
+// loadReceipt URLの領収書IDから店舗の領収書を取得（見つからない場合はレスポンス済み）
+func (h *ReceiptHandler) loadReceipt(c *gin.Context, storeID uint) (*models.Receipt, bool) {
+	receiptID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正なIDです"})
+		return nil, false
+	}
+
+	var r models.Receipt
+	if err := h.DB.Where("store_id = ?", storeID).Preload("Lines").Preload("Taxes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("rate DESC")
+	}).First(&r, receiptID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "領収書が見つかりません"})
+		return nil, false
+	}
+	return &r, true
+}
+
+// GetReceipt 発行済みの領収書を表示
+func (h *ReceiptHandler) GetReceipt(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if !receiptFormats[format] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な出力形式です"})
+		return
+	}
+
+	r, ok := h.loadReceipt(c, storeID)
+	if !ok {
+		return
+	}
+	writeReceipt(c, *r, format, false)
+}
+
+// ReprintReceipt 領収書を再発行（「再発行」と明記し、回数を記録する。?print=true でレシートプリンターに印刷）
+func (h *ReceiptHandler) ReprintReceipt(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if !receiptFormats[format] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な出力形式です"})
+		return
+	}
+	printer, ok := h.receiptPrinterFromQuery(c, storeID)
+	if !ok {
+		return
+	}
+
+	r, ok := h.loadReceipt(c, storeID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if err := h.DB.Model(r).Updates(map[string]interface{}{
+		"reprint_count":     gorm.Expr("reprint_count + 1"),
+		"last_reprinted_at": now,
+	}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "領収書の再発行に失敗しました"})
+		return
+	}
+	r.ReprintCount++
+	r.LastReprintedAt = &now
+	if printer != nil {
+		queueReceiptPrint(h.DB, h.Spooler, printer, *r, true)
+	}
+
+	writeReceipt(c, *r, format, true)
+}
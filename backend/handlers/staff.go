@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+	"orderbase/security"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type StaffHandler struct {
+	DB        *gorm.DB
+	Passwords security.PasswordPolicy // 初期パスワードの条件
+}
+
+// StaffMember スタッフ一覧の1件
+type StaffMember struct {
+	ID          uint     `json:"id"`
+	Username    string   `json:"username"`
+	Role        string   `json:"role"`
+	IsOwner     bool     `json:"is_owner"` // 店舗を作成したオーナー（役割の変更・削除はできない）
+	Permissions []string `json:"permissions"`
+	TwoFactor   bool     `json:"two_factor_enabled"`
+}
+
+// staffMember ユーザーをスタッフ一覧の形式に変換
+func staffMember(user models.User, store *models.Store) StaffMember {
+	return StaffMember{
+		ID:          user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		IsOwner:     store.OwnerID != nil && *store.OwnerID == user.ID,
+		Permissions: models.RolePermissions(user.Role),
+		TwoFactor:   user.TOTPEnabled,
+	}
+}
+
+// loadStaff URLのIDから店舗のスタッフを取得し、役割の変更・削除ができるか確認（できない場合はレスポンス済み）
+func (h *StaffHandler) loadStaff(c *gin.Context, storeID uint) (*models.User, *models.Store, bool) {
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return nil, nil, false
+	}
+
+	var user models.User
+	if err := h.DB.Where("store_id = ?", storeID).First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "スタッフが見つかりません"})
+		return nil, nil, false
+	}
+	if user.ID == currentUserID(c) {
+		c.JSON(http.StatusConflict, gin.H{"error": "自分自身の役割の変更・削除はできません"})
+		return nil, nil, false
+	}
+	if store.OwnerID != nil && *store.OwnerID == user.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": "店舗のオーナーの役割の変更・削除はできません"})
+		return nil, nil, false
+	}
+	return &user, store, true
+}
+
+// GetStaff 店舗のスタッフと役割の一覧を取得
+func (h *StaffHandler) GetStaff(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+	var users []models.User
+	if err := h.DB.Where("store_id = ?", storeID).Order("id ASC").Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの取得に失敗しました"})
+		return
+	}
+
+	staff := make([]StaffMember, 0, len(users))
+	for _, user := range users {
+		staff = append(staff, staffMember(user, store))
+	}
+	c.JSON(http.StatusOK, gin.H{"staff": staff, "roles": models.Roles})
+}
+
+// InviteStaff 店舗にスタッフのアカウントを作成し、役割を割り当てる
+func (h *StaffHandler) InviteStaff(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"` // 初期パスワード
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	req.Username = strings.TrimSpace(req.Username)
+	if req.Username == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ユーザー名と初期パスワードが必要です"})
+		return
+	}
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な役割です"})
+		return
+	}
+	if err := h.Passwords.Validate(req.Password, req.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+
+	var exists int64
+	if err := h.DB.Model(&models.User{}).Unscoped().Where("username = ?", req.Username).Count(&exists).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの登録に失敗しました"})
+		return
+	}
+	if exists > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "このユーザー名は既に使われています"})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの登録に失敗しました"})
+		return
+	}
+	user := models.User{
+		Username: req.Username,
+		Password: string(hash),
+		StoreID:  &storeID,
+		Role:     req.Role,
+	}
+	if err := h.DB.Create(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの登録に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "スタッフを登録しました",
+		"staff":   staffMember(user, store),
+	})
+}
+
+// UpdateStaffRole スタッフの役割を変更
+func (h *StaffHandler) UpdateStaffRole(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if !models.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不正な役割です"})
+		return
+	}
+
+	user, store, ok := h.loadStaff(c, storeID)
+	if !ok {
+		return
+	}
+	if err := h.DB.Model(user).Update("role", req.Role).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "役割の変更に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "役割を変更しました",
+		"staff":   staffMember(*user, store),
+	})
+}
+
+// RemoveStaff スタッフのアカウントを削除
+func (h *StaffHandler) RemoveStaff(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	user, _, ok := h.loadStaff(c, storeID)
+	if !ok {
+		return
+	}
+	if err := h.DB.Delete(user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "スタッフを削除しました"})
+}
+
+// SetStaffPIN スタッフの共有端末用PINを設定（忘れた場合の再設定用）
+func (h *StaffHandler) SetStaffPIN(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PIN string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if !validPIN(req.PIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PINは4〜8桁の数字で入力してください"})
+		return
+	}
+
+	user, _, ok := h.loadStaff(c, storeID)
+	if !ok {
+		return
+	}
+	if err := savePIN(h.DB, user, req.PIN); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "PINの設定に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PINを設定しました"})
+}
+
+// ResetStaffTwoFactor スタッフの二段階認証を解除（認証アプリとリカバリーコードをなくした場合の再設定用）
+func (h *StaffHandler) ResetStaffTwoFactor(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	user, _, ok := h.loadStaff(c, storeID)
+	if !ok {
+		return
+	}
+	if err := disableTwoFactor(h.DB, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二段階認証の解除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "二段階認証を解除しました"})
+}
@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"orderbase/events"
+	"orderbase/models"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 在庫台帳の取得件数
+const (
+	stockMovementsDefaultLimit = 100
+	stockMovementsMaxLimit     = 500
+)
+
+// errInsufficientStock 在庫数が0未満になる増減
+var errInsufficientStock = errors.New("在庫が不足しています")
+
+type InventoryHandler struct {
+	DB *gorm.DB
+}
+
+// StockRequest 在庫の設定・調整リクエスト
+// stock（在庫数を指定）と delta（入荷・廃棄などの増減）はどちらか一方のみ指定する
+type StockRequest struct {
+	TrackStock *bool  `json:"track_stock"`
+	Stock      *int   `json:"stock"`
+	Delta      *int   `json:"delta"`
+	SoldOut    *bool  `json:"sold_out"`
+	Note       string `json:"note"`
+}
+
+// StockShortage 在庫が足りない商品
+type StockShortage struct {
+	ProductID  uint   `json:"product_id"`
+	Name       string `json:"name"`
+	Requested  int    `json:"requested"`
+	Available  int    `json:"available"` // 品切れの場合は0
+	CartItemID *uint  `json:"cart_item_id,omitempty"`
+}
+
+// StockStatus 在庫変更イベントで配信する商品の在庫状況
+type StockStatus struct {
+	ProductID  uint `json:"product_id"`
+	TrackStock bool `json:"track_stock"`
+	Stock      int  `json:"stock"`
+	SoldOut    bool `json:"sold_out"`
+	Available  bool `json:"available"` // 品切れ・在庫0・食材不足のいずれでもない
+}
+
+// stockShortageError 注文時に在庫が足りなかった商品
+type stockShortageError struct {
+	Shortages []StockShortage
+}
+
+func (e *stockShortageError) Error() string {
+	return "在庫が不足しています"
+}
+
+// stockChecker 注文前に在庫を確認する
+// 同じ商品の複数行や、同じ食材を使う商品は合算して確認する（商品のレシピは事前に読み込んでおく）
+type stockChecker struct {
+	remaining   map[uint]int     // 商品ごとの残り在庫数
+	ingredients map[uint]float64 // 食材ごとの残り在庫量
+}
+
+func newStockChecker() *stockChecker {
+	return &stockChecker{
+		remaining:   make(map[uint]int),
+		ingredients: make(map[uint]float64),
+	}
+}
+
+// productStock 確保済みの分を除いた商品の在庫数
+func (s *stockChecker) productStock(product models.Product) int {
+	if remaining, ok := s.remaining[product.ID]; ok {
+		return remaining
+	}
+	return product.Stock
+}
+
+// ingredientOnHand 確保済みの分を除いた食材の在庫量
+func (s *stockChecker) ingredientOnHand(item models.RecipeItem) float64 {
+	if onHand, ok := s.ingredients[item.IngredientID]; ok {
+		return onHand
+	}
+	return item.Ingredient.OnHand
+}
+
+// available 商品をあと何個注文できるか（在庫数・食材のどちらでも制限されない場合は limited が false）
+func (s *stockChecker) available(product models.Product) (units int, limited bool) {
+	if product.SoldOut {
+		return 0, true
+	}
+	if product.TrackStock {
+		units, limited = s.productStock(product), true
+	}
+	for _, item := range product.Recipe {
+		if item.Quantity <= 0 {
+			continue
+		}
+		n := int(math.Floor(s.ingredientOnHand(item)/item.Quantity + quantityEpsilon))
+		if !limited || n < units {
+			units = n
+		}
+		limited = true
+	}
+	if units < 0 {
+		units = 0
+	}
+	return units, limited
+}
+
+// take 数量分を確保できるか確認し、確保できた場合は残りから差し引く
+// 確保できない場合は注文できる残数を返す
+func (s *stockChecker) take(product models.Product, quantity int) (int, bool) {
+	if units, limited := s.available(product); limited && units < quantity {
+		return units, false
+	}
+	if product.TrackStock {
+		s.remaining[product.ID] = s.productStock(product) - quantity
+	}
+	for _, item := range product.Recipe {
+		s.ingredients[item.IngredientID] = s.ingredientOnHand(item) - item.Quantity*float64(quantity)
+	}
+	return quantity, true
+}
+
+// productAvailable 商品を1つ以上注文できるか（品切れ・在庫0・食材不足の場合は false）
+func productAvailable(product models.Product) bool {
+	units, limited := newStockChecker().available(product)
+	return !limited || units > 0
+}
+
+// moveStock 在庫を増減して品切れフラグを更新し、台帳に記録する
+// 在庫管理しない商品は何もせず nil を返し、在庫が0未満になる場合は errInsufficientStock を返す
+func moveStock(tx *gorm.DB, productID uint, delta int, reason string, orderID, itemID *uint, actor statusActor, note string) (*models.StockMovement, error) {
+	var product models.Product
+	if err := tx.Select("id", "track_stock", "stock").First(&product, productID).Error; err != nil {
+		return nil, err
+	}
+	if !product.TrackStock {
+		return nil, nil
+	}
+
+	// 同時に注文された場合も在庫がマイナスにならないよう、条件付きで更新する
+	result := tx.Model(&models.Product{}).
+		Where("id = ? AND stock + ? >= 0", productID, delta).
+		Update("stock", gorm.Expr("stock + ?", delta))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errInsufficientStock
+	}
+
+	if err := tx.Select("id", "stock").First(&product, productID).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Update("sold_out", product.Stock <= 0).Error; err != nil {
+		return nil, err
+	}
+
+	movement := models.StockMovement{
+		ProductID:     productID,
+		Delta:         delta,
+		StockAfter:    product.Stock,
+		Reason:        reason,
+		OrderID:       orderID,
+		OrderItemID:   itemID,
+		Note:          note,
+		ChangedBy:     actor.UserID,
+		ChangedByName: actor.Name,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		return nil, err
+	}
+	return &movement, nil
+}
+
+// deductStock 注文の明細の数量分だけ商品の在庫を減らす（注文作成と同じトランザクションで呼ぶ）
+// レシピの食材は提供・会計時に使用する（consumeIngredients）。在庫が変わった商品のIDを返す
+func deductStock(tx *gorm.DB, order *models.Order, actor statusActor) ([]uint, error) {
+	var productIDs []uint
+	for i := range order.Items {
+		item := &order.Items[i]
+		movement, err := moveStock(tx, item.ProductID, -item.Quantity, models.StockReasonSale, &order.ID, &item.ID, actor, "")
+		if movement != nil {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		if errors.Is(err, errInsufficientStock) {
+			product, _ := loadProductForOrder(tx, item.ProductID)
+			units, _ := newStockChecker().available(product)
+			return nil, &stockShortageError{Shortages: []StockShortage{{
+				ProductID: item.ProductID,
+				Name:      product.Name,
+				Requested: item.Quantity,
+				Available: units,
+			}}}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return productIDs, nil
+}
+
+// restoreStock 取り消された明細の在庫を戻す
+// 台帳で出庫済みの数量だけ戻すため、二重に戻ることはなく、在庫管理前の注文は対象外になる
+func restoreStock(tx *gorm.DB, items []models.OrderItem, actor statusActor, note string) error {
+	for _, item := range items {
+		var net int
+		if err := tx.Model(&models.StockMovement{}).
+			Where("order_item_id = ?", item.ID).
+			Select("COALESCE(SUM(delta), 0)").
+			Scan(&net).Error; err != nil {
+			return err
+		}
+		if net >= 0 {
+			continue
+		}
+		if _, err := moveStock(tx, item.ProductID, -net, models.StockReasonCancel, &item.OrderID, &item.ID, actor, note); err != nil {
+			return err
+		}
+	}
+	return restoreIngredients(tx, items, actor, note)
+}
+
+// publishStockChanges 在庫が変わった商品の在庫状況を配信（コミット後に呼ぶ）
+func publishStockChanges(db *gorm.DB, productIDs []uint) {
+	seen := make(map[uint]bool)
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		publishStockStatus(db, id)
+	}
+}
+
+// publishStockStatus 商品の在庫状況を配信
+func publishStockStatus(db *gorm.DB, productID uint) {
+	var product models.Product
+	if err := db.Preload("Recipe.Ingredient").First(&product, productID).Error; err != nil {
+		return
+	}
+	events.Publish(product.StoreID, events.StockChanged, StockStatus{
+		ProductID:  product.ID,
+		TrackStock: product.TrackStock,
+		Stock:      product.Stock,
+		SoldOut:    product.SoldOut,
+		Available:  productAvailable(product),
+	})
+}
+
+// GetInventory 自分の商品の在庫状況を一覧で取得
+func (h *InventoryHandler) GetInventory(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var products []models.Product
+	if err := h.DB.Where("store_id = ?", storeID).
+		Preload("Recipe.Ingredient").
+		Order("sort_order ASC, id ASC").
+		Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "商品取得失敗"})
+		return
+	}
+
+	inventory := make([]gin.H, 0, len(products))
+	for _, p := range products {
+		inventory = append(inventory, gin.H{
+			"product_id":  p.ID,
+			"name":        p.Name,
+			"track_stock": p.TrackStock,
+			"stock":       p.Stock,
+			"sold_out":    p.SoldOut,
+			"available":   productAvailable(p),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"inventory": inventory})
+}
+
+// UpdateStock 在庫管理の有無・在庫数・品切れを設定する（変更は台帳に記録する）
+func (h *InventoryHandler) UpdateStock(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	product, err := findOwnedProduct(h.DB, storeID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "商品が見つかりません"})
+		return
+	}
+
+	var req StockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	if req.Stock != nil && req.Delta != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stock と delta は同時に指定できません"})
+		return
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫数は0以上で指定してください"})
+		return
+	}
+
+	trackStock := product.TrackStock
+	if req.TrackStock != nil {
+		trackStock = *req.TrackStock
+	}
+	if !trackStock && (req.Stock != nil || req.Delta != nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫管理が無効の商品です"})
+		return
+	}
+
+	actor := actorFromSession(c)
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if req.TrackStock != nil {
+			if err := tx.Model(product).Update("track_stock", trackStock).Error; err != nil {
+				return err
+			}
+		}
+
+		delta := 0
+		if req.Stock != nil {
+			delta = *req.Stock - product.Stock
+		}
+		if req.Delta != nil {
+			delta = *req.Delta
+		}
+		if delta != 0 {
+			if _, err := moveStock(tx, product.ID, delta, models.StockReasonAdjust, nil, nil, actor, req.Note); err != nil {
+				return err
+			}
+		} else if req.TrackStock != nil && trackStock && !product.TrackStock {
+			// 在庫管理を始めた時点の在庫数で品切れを判定する
+			if err := tx.Model(product).Update("sold_out", product.Stock <= 0).Error; err != nil {
+				return err
+			}
+		}
+
+		// 品切れは在庫数と別に手動でも切り替えられる（在庫管理する商品は次の増減で再判定される）
+		if req.SoldOut != nil {
+			return tx.Model(product).Update("sold_out", *req.SoldOut).Error
+		}
+		return nil
+	})
+	if errors.Is(err, errInsufficientStock) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "在庫数が0未満になります"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "在庫の更新に失敗しました"})
+		return
+	}
+
+	h.DB.First(product, product.ID)
+	publishStockStatus(h.DB, product.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "在庫を更新しました",
+		"product": product,
+	})
+}
+
+// GetStockMovements 在庫台帳を新しい順に取得（?product_id= で商品を絞り込む）
+func (h *InventoryHandler) GetStockMovements(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	limit := stockMovementsDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不正な件数です"})
+			return
+		}
+		if n > stockMovementsMaxLimit {
+			n = stockMovementsMaxLimit
+		}
+		limit = n
+	}
+
+	query := h.DB.Joins("JOIN products ON products.id = stock_movements.product_id").
+		Where("products.store_id = ?", storeID)
+	if v := c.Query("product_id"); v != "" {
+		query = query.Where("stock_movements.product_id = ?", v)
+	}
+
+	var movements []models.StockMovement
+	if err := query.Order("stock_movements.id DESC").
+		Limit(limit).
+		Find(&movements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "在庫台帳の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"movements": movements})
+}
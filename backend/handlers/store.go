@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"orderbase/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type StoreHandler struct {
+	DB *gorm.DB
+}
+
+// currentStoreID ログイン中のユーザーが所属する店舗のID
+// 店舗に所属していない場合はレスポンスを返して false を返す
+func currentStoreID(c *gin.Context) (uint, bool) {
+	user := CurrentUser(c)
+	if user == nil || user.StoreID == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "店舗に所属していません"})
+		return 0, false
+	}
+	return *user.StoreID, true
+}
+
+// loadStore 店舗を取得
+func loadStore(db *gorm.DB, storeID uint) (*models.Store, error) {
+	var store models.Store
+	if err := db.First(&store, storeID).Error; err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// userStoreID ユーザー名から所属する店舗のIDを取得（公開ページ用）
+func userStoreID(db *gorm.DB, username string) (uint, error) {
+	var user models.User
+	if err := db.Select("id", "store_id").Where("username = ?", username).First(&user).Error; err != nil {
+		return 0, err
+	}
+	if user.StoreID == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+	return *user.StoreID, nil
+}
+
+// GetStore ログイン中のユーザーの店舗情報と役割・権限を取得
+func (h *StoreHandler) GetStore(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "店舗が見つかりません"})
+		return
+	}
+	user := CurrentUser(c)
+
+	c.JSON(http.StatusOK, gin.H{
+		"store":       store,
+		"role":        user.Role,
+		"permissions": models.RolePermissions(user.Role),
+	})
+}
+
+// GetStoreUsers 店舗に所属するユーザーの一覧を取得
+func (h *StoreHandler) GetStoreUsers(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var users []models.User
+	if err := h.DB.Where("store_id = ?", storeID).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ユーザー取得失敗"})
+		return
+	}
+	c.JSON(http.StatusOK, users)
+}
+
+// SetSecuritySettings 店舗のセキュリティ設定を変更（オーナー・マネージャーへの二段階認証の必須化）
+func (h *StoreHandler) SetSecuritySettings(c *gin.Context) {
+	var req struct {
+		RequireTwoFactor *bool `json:"require_two_factor" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	// 必須にした本人が設定を終えるまで操作できなくならないよう、先に自分の二段階認証を有効にしてもらう
+	if *req.RequireTwoFactor && !CurrentUser(c).TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "先に自分の二段階認証を有効にしてください"})
+		return
+	}
+
+	if err := h.DB.Model(&models.Store{}).Where("id = ?", storeID).Update("require_two_factor", *req.RequireTwoFactor).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "セキュリティ設定を保存しました"})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"orderbase/events"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// 接続維持のためのハートビート間隔
+const streamHeartbeatInterval = 15 * time.Second
+
+// StreamOrderEvents 店舗の注文・テーブルの変更をServer-Sent Eventsで配信（キッチン用）
+func StreamOrderEvents(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	// 再接続時はLast-Event-ID以降のイベントを再送（再送できない場合はresyncイベントを送る）
+	// （EventSourceでヘッダーを付けられない場合はクエリパラメータでも可）
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		id, err := strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "不正なLast-Event-IDです"})
+			return
+		}
+		lastID = id
+	}
+
+	ch, replay, unsubscribe := events.Subscribe(storeID, lastID)
+	defer unsubscribe()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// 再接続までの待ち時間（ミリ秒）をクライアントに通知
+	fmt.Fprint(c.Writer, "retry: 3000\n\n")
+	for _, event := range replay {
+		renderEvent(c, event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				// ハブから切断された場合はクライアントに再接続させる
+				return false
+			}
+			renderEvent(c, event)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// renderEvent イベントをSSE形式で書き出す
+func renderEvent(c *gin.Context, event events.Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(event.ID, 10),
+		Event: event.Type,
+		Data:  event,
+	})
+}
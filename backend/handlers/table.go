@@ -1,26 +1,27 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"orderbase/events"
 	"orderbase/models"
+	"orderbase/qrtoken"
 	"strconv"
 
-	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type TableHandler struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Tokens  *qrtoken.Signer // QRコード用トークンの署名
+	BaseURL string          // QRコードのURLの基準（メニューページのURLを作る）
 }
 
 // CreateTable テーブルを作成
 func (h *TableHandler) CreateTable(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -41,15 +42,16 @@ func (h *TableHandler) CreateTable(c *gin.Context) {
 		req.Status = "active"
 	}
 
-	// テーブル番号の重複チェック
+	// テーブル番号の重複チェック（店舗内で一意）
 	var existingTable models.Table
-	if err := h.DB.Where("table_number = ?", req.TableNumber).First(&existingTable).Error; err == nil {
+	if err := h.DB.Where("store_id = ? AND table_number = ?", storeID, req.TableNumber).First(&existingTable).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "このテーブル番号は既に使用されています"})
 		return
 	}
 
 	// テーブル作成
 	table := models.Table{
+		StoreID:     storeID,
 		TableNumber: req.TableNumber,
 		Capacity:    req.Capacity,
 		Status:      req.Status,
@@ -59,6 +61,7 @@ func (h *TableHandler) CreateTable(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "テーブルの作成に失敗しました"})
 		return
 	}
+	events.Publish(table.StoreID, events.TableChanged, table)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "テーブルを作成しました",
@@ -66,18 +69,15 @@ func (h *TableHandler) CreateTable(c *gin.Context) {
 	})
 }
 
-// GetTables 全テーブルを取得
+// GetTables 店舗の全テーブルを取得
 func (h *TableHandler) GetTables(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
 	var tables []models.Table
-	if err := h.DB.Order("table_number ASC").Find(&tables).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).Order("table_number ASC").Find(&tables).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "テーブルの取得に失敗しました"})
 		return
 	}
@@ -87,11 +87,8 @@ func (h *TableHandler) GetTables(c *gin.Context) {
 
 // GetTableByID テーブル詳細を取得
 func (h *TableHandler) GetTableByID(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -102,7 +99,7 @@ func (h *TableHandler) GetTableByID(c *gin.Context) {
 	}
 
 	var table models.Table
-	if err := h.DB.First(&table, tableID).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).First(&table, tableID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "テーブルが見つかりません"})
 			return
@@ -116,11 +113,8 @@ func (h *TableHandler) GetTableByID(c *gin.Context) {
 
 // UpdateTable テーブルを更新
 func (h *TableHandler) UpdateTable(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -144,7 +138,7 @@ func (h *TableHandler) UpdateTable(c *gin.Context) {
 
 	// テーブルの存在確認
 	var table models.Table
-	if err := h.DB.First(&table, tableID).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).First(&table, tableID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "テーブルが見つかりません"})
 			return
@@ -156,7 +150,7 @@ func (h *TableHandler) UpdateTable(c *gin.Context) {
 	// テーブル番号の重複チェック
 	if req.TableNumber != nil && *req.TableNumber != table.TableNumber {
 		var existingTable models.Table
-		if err := h.DB.Where("table_number = ?", *req.TableNumber).First(&existingTable).Error; err == nil {
+		if err := h.DB.Where("store_id = ? AND table_number = ?", storeID, *req.TableNumber).First(&existingTable).Error; err == nil {
 			c.JSON(http.StatusConflict, gin.H{"error": "このテーブル番号は既に使用されています"})
 			return
 		}
@@ -174,13 +168,28 @@ func (h *TableHandler) UpdateTable(c *gin.Context) {
 		updates["status"] = *req.Status
 	}
 
-	if err := h.DB.Model(&table).Updates(updates).Error; err != nil {
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&table).Updates(updates).Error; err != nil {
+			return err
+		}
+		// 使用不可にしたテーブルは来店セッションも終了する
+		if req.Status != nil && *req.Status == "inactive" {
+			return closeOpenTableSession(tx, table.ID)
+		}
+		return nil
+	})
+	if errors.Is(err, errTableHasOpenOrders) {
+		c.JSON(http.StatusConflict, gin.H{"error": "未会計の注文があるため使用不可にできません"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "テーブルの更新に失敗しました"})
 		return
 	}
 
 	// 更新後のデータを取得
 	h.DB.First(&table, tableID)
+	events.Publish(table.StoreID, events.TableChanged, table)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "テーブルを更新しました",
@@ -190,11 +199,8 @@ func (h *TableHandler) UpdateTable(c *gin.Context) {
 
 // DeleteTable テーブルを削除
 func (h *TableHandler) DeleteTable(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -206,7 +212,7 @@ func (h *TableHandler) DeleteTable(c *gin.Context) {
 
 	// テーブルの存在確認
 	var table models.Table
-	if err := h.DB.First(&table, tableID).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).First(&table, tableID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "テーブルが見つかりません"})
 			return
@@ -217,21 +223,29 @@ func (h *TableHandler) DeleteTable(c *gin.Context) {
 
 	// ソフトデリート（statusをinactiveに変更）を推奨
 	// ハードデリートする場合は以下をコメントアウトして h.DB.Delete(&table).Error を使用
-	if err := h.DB.Model(&table).Update("status", "inactive").Error; err != nil {
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&table).Update("status", "inactive").Error; err != nil {
+			return err
+		}
+		return closeOpenTableSession(tx, table.ID)
+	})
+	if errors.Is(err, errTableHasOpenOrders) {
+		c.JSON(http.StatusConflict, gin.H{"error": "未会計の注文があるため削除できません"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "テーブルの削除に失敗しました"})
 		return
 	}
+	events.Publish(table.StoreID, events.TableChanged, table)
 
 	c.JSON(http.StatusOK, gin.H{"message": "テーブルを削除しました"})
 }
 
-// GetTableOrders テーブル別の注文履歴を取得
+// GetTableOrders テーブルの現在の来店分の注文を取得
 func (h *TableHandler) GetTableOrders(c *gin.Context) {
-	// 認証チェック
-	session := sessions.Default(c)
-	userID := session.Get("user_id")
-	if userID == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "ログインが必要です"})
+	storeID, ok := currentStoreID(c)
+	if !ok {
 		return
 	}
 
@@ -243,7 +257,7 @@ func (h *TableHandler) GetTableOrders(c *gin.Context) {
 
 	// テーブルの存在確認
 	var table models.Table
-	if err := h.DB.First(&table, tableID).Error; err != nil {
+	if err := h.DB.Where("store_id = ?", storeID).First(&table, tableID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.JSON(http.StatusNotFound, gin.H{"error": "テーブルが見つかりません"})
 			return
@@ -252,19 +266,36 @@ func (h *TableHandler) GetTableOrders(c *gin.Context) {
 		return
 	}
 
-	// テーブルに紐づく注文を取得
-	var orders []models.Order
-	if err := h.DB.Where("table_id = ?", tableID).
-		Preload("Product").
-		Preload("User").
-		Order("created_at DESC").
-		Find(&orders).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+	// 現在の来店（利用中セッション）の注文のみ取得
+	current, err := findOpenTableSession(h.DB, table.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの取得に失敗しました"})
 		return
 	}
 
+	orders := []models.Order{}
+	var summary *TableSessionSummary
+	if current != nil {
+		if err := h.DB.Where("table_session_id = ?", current.ID).
+			Preload("Items.Product").
+			Preload("Items.Options").
+			Preload("User").
+			Order("created_at DESC").
+			Find(&orders).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "注文の取得に失敗しました"})
+			return
+		}
+		s, err := summarizeTableSession(h.DB, *current)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "集計に失敗しました"})
+			return
+		}
+		summary = &s
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"table":  table,
-		"orders": orders,
+		"table":   table,
+		"session": summary,
+		"orders":  orders,
 	})
 }
@@ -0,0 +1,283 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"orderbase/events"
+	"orderbase/models"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TableSessionSummary 来店単位の集計
+type TableSessionSummary struct {
+	models.TableSession
+	OrderCount      int64 `json:"order_count"`
+	TotalAmount     int   `json:"total_amount"`     // 取消・返金を除いた合計
+	DurationMinutes int   `json:"duration_minutes"` // 滞在時間（利用中の場合は現在まで）
+}
+
+var (
+	// errTableInUse 着席しようとしたテーブルに利用中のセッションがある
+	errTableInUse = errors.New("このテーブルは利用中です")
+	// errTableHasOpenOrders 未会計の注文が残っている来店を終了しようとした
+	errTableHasOpenOrders = errors.New("未会計の注文があります")
+)
+
+// findOpenTableSession テーブルの利用中セッションを取得（なければnil）
+func findOpenTableSession(db *gorm.DB, tableID uint) (*models.TableSession, error) {
+	var tableSession models.TableSession
+	err := db.Where("table_id = ? AND status = ?", tableID, models.TableSessionOpen).
+		Order("opened_at DESC").
+		First(&tableSession).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tableSession, nil
+}
+
+// openTableSession テーブルのセッションを開始
+func openTableSession(tx *gorm.DB, tableID uint, partySize int, openedBy *uint) (*models.TableSession, error) {
+	tableSession := models.TableSession{
+		TableID:   tableID,
+		PartySize: partySize,
+		Status:    models.TableSessionOpen,
+		OpenedAt:  time.Now(),
+		OpenedBy:  openedBy,
+	}
+	if err := tx.Create(&tableSession).Error; err != nil {
+		return nil, err
+	}
+	return &tableSession, nil
+}
+
+// closeTableSession テーブルのセッションを終了
+func closeTableSession(tx *gorm.DB, tableSession *models.TableSession) error {
+	now := time.Now()
+	tableSession.Status = models.TableSessionClosed
+	tableSession.ClosedAt = &now
+	return tx.Model(tableSession).Updates(map[string]interface{}{
+		"status":    tableSession.Status,
+		"closed_at": now,
+	}).Error
+}
+
+// ensureNoOpenOrders 来店に未会計の注文が残っていないか確認（残っている場合は errTableHasOpenOrders）
+// 同時に注文された場合に備え、来店を終了するトランザクション内で呼ぶ
+func ensureNoOpenOrders(tx *gorm.DB, tableSessionID uint) error {
+	var openOrders int64
+	if err := tx.Model(&models.Order{}).
+		Where("table_session_id = ? AND status IN ?", tableSessionID, models.OpenOrderStatuses).
+		Count(&openOrders).Error; err != nil {
+		return err
+	}
+	if openOrders > 0 {
+		return errTableHasOpenOrders
+	}
+	return nil
+}
+
+// closeOpenTableSession テーブルに利用中セッションがあれば終了（未会計の注文がある場合は errTableHasOpenOrders）
+func closeOpenTableSession(tx *gorm.DB, tableID uint) error {
+	current, err := findOpenTableSession(tx, tableID)
+	if err != nil || current == nil {
+		return err
+	}
+	if err := ensureNoOpenOrders(tx, current.ID); err != nil {
+		return err
+	}
+	return closeTableSession(tx, current)
+}
+
+// summarizeTableSession セッションの注文数・合計・滞在時間を集計
+func summarizeTableSession(db *gorm.DB, tableSession models.TableSession) (TableSessionSummary, error) {
+	summary := TableSessionSummary{TableSession: tableSession}
+
+	if err := db.Model(&models.Order{}).
+		Where("table_session_id = ?", tableSession.ID).
+		Count(&summary.OrderCount).Error; err != nil {
+		return summary, err
+	}
+	if err := db.Model(&models.Order{}).
+		Where("table_session_id = ? AND status NOT IN ?", tableSession.ID,
+			[]string{models.OrderStatusCancelled, models.OrderStatusRefunded}).
+		Select("COALESCE(SUM(total_price), 0)").
+		Scan(&summary.TotalAmount).Error; err != nil {
+		return summary, err
+	}
+
+	end := time.Now()
+	if tableSession.ClosedAt != nil {
+		end = *tableSession.ClosedAt
+	}
+	summary.DurationMinutes = int(end.Sub(tableSession.OpenedAt).Minutes())
+	return summary, nil
+}
+
+// loadTable URLのテーブルIDから店舗のテーブルを取得（見つからない場合はレスポンス済み）
+func loadTable(c *gin.Context, db *gorm.DB, storeID uint) (*models.Table, bool) {
+	tableID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "無効なテーブルIDです"})
+		return nil, false
+	}
+
+	var table models.Table
+	if err := db.Where("store_id = ?", storeID).First(&table, tableID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "テーブルが見つかりません"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "テーブルの取得に失敗しました"})
+		return nil, false
+	}
+	return &table, true
+}
+
+// OpenTableSession 着席（セッション開始）
+func (h *TableHandler) OpenTableSession(c *gin.Context) {
+	userID := currentUserID(c)
+
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		PartySize int `json:"party_size" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	uid := userID
+	var tableSession *models.TableSession
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		// 着席したテーブルは注文受付可能にする（先にテーブルを更新し、同時の着席を待たせてから利用中か確認する）
+		if err := tx.Model(table).Update("status", "active").Error; err != nil {
+			return err
+		}
+		current, err := findOpenTableSession(tx, table.ID)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return errTableInUse
+		}
+		tableSession, err = openTableSession(tx, table.ID, req.PartySize, &uid)
+		return err
+	})
+	if errors.Is(err, errTableInUse) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの開始に失敗しました"})
+		return
+	}
+	events.Publish(table.StoreID, events.TableChanged, table)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "着席を登録しました",
+		"session": tableSession,
+	})
+}
+
+// CloseTableSession 会計済みのセッションを終了し、テーブルを空席にする
+func (h *TableHandler) CloseTableSession(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	current, err := findOpenTableSession(h.DB, table.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの取得に失敗しました"})
+		return
+	}
+	if current == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "利用中のセッションがありません"})
+		return
+	}
+
+	// 未会計の注文が残っている場合は終了できない
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := closeTableSession(tx, current); err != nil {
+			return err
+		}
+		if err := ensureNoOpenOrders(tx, current.ID); err != nil {
+			return err
+		}
+		return tx.Model(table).Update("status", "inactive").Error
+	})
+	if errors.Is(err, errTableHasOpenOrders) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの終了に失敗しました"})
+		return
+	}
+	events.Publish(table.StoreID, events.TableChanged, table)
+
+	summary, err := summarizeTableSession(h.DB, *current)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "集計に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "会計を終了しました",
+		"session": summary,
+	})
+}
+
+// GetTableSessions テーブルの来店履歴を取得
+func (h *TableHandler) GetTableSessions(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	var tableSessions []models.TableSession
+	if err := h.DB.Where("table_id = ?", table.ID).
+		Order("opened_at DESC").
+		Find(&tableSessions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "来店履歴の取得に失敗しました"})
+		return
+	}
+
+	summaries := make([]TableSessionSummary, 0, len(tableSessions))
+	for _, tableSession := range tableSessions {
+		summary, err := summarizeTableSession(h.DB, tableSession)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "集計に失敗しました"})
+			return
+		}
+		summaries = append(summaries, summary)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"table":    table,
+		"sessions": summaries,
+	})
+}
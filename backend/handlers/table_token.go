@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"orderbase/events"
+	"orderbase/models"
+	"orderbase/qrtoken"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+// QRトークンのデフォルト有効期間
+const defaultTableTokenTTL = 3 * time.Hour
+
+var (
+	errTableTokenRevoked = errors.New("このQRコードは無効になりました")
+	errTableNotSeated    = errors.New("テーブルの利用は終了しています")
+	errTableUnavailable  = errors.New("指定されたテーブルは現在使用できません")
+)
+
+// signTableToken 発行記録からトークン文字列を作成
+func signTableToken(signer *qrtoken.Signer, record models.TableToken) (string, error) {
+	return signer.Sign(qrtoken.Claims{
+		TokenID:   record.ID,
+		TableID:   record.TableID,
+		SessionID: record.TableSessionID,
+		ExpiresAt: record.ExpiresAt.Unix(),
+	})
+}
+
+// resolveTableToken トークンを検証し、注文先のテーブルと来店セッションを返す
+func resolveTableToken(db *gorm.DB, signer *qrtoken.Signer, token string) (*models.Table, *models.TableSession, error) {
+	claims, err := signer.Verify(token, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var record models.TableToken
+	if err := db.First(&record, claims.TokenID).Error; err != nil {
+		return nil, nil, qrtoken.ErrInvalidToken
+	}
+	if record.TableID != claims.TableID || record.TableSessionID != claims.SessionID {
+		return nil, nil, qrtoken.ErrInvalidToken
+	}
+	if record.RevokedAt != nil {
+		return nil, nil, errTableTokenRevoked
+	}
+
+	// 発行時の来店セッションが終了していれば無効（次のお客様は新しいQRコードを使う）
+	var tableSession models.TableSession
+	if err := db.First(&tableSession, record.TableSessionID).Error; err != nil {
+		return nil, nil, errTableNotSeated
+	}
+	if tableSession.Status != models.TableSessionOpen {
+		return nil, nil, errTableNotSeated
+	}
+
+	var table models.Table
+	if err := db.First(&table, record.TableID).Error; err != nil {
+		return nil, nil, errTableUnavailable
+	}
+	if table.Status != "active" {
+		return nil, nil, errTableUnavailable
+	}
+
+	return &table, &tableSession, nil
+}
+
+// tableTokenFromRequest ヘッダーまたはセッションからテーブルトークンを取得
+func tableTokenFromRequest(c *gin.Context) string {
+	if token := c.GetHeader("X-Table-Token"); token != "" {
+		return token
+	}
+	session := sessions.Default(c)
+	if token, ok := session.Get("table_token").(string); ok {
+		return token
+	}
+	return ""
+}
+
+// tableTokenURL QRコードに埋め込むURLを作成（設定のベースURLを使い、リクエストのHostヘッダーは使わない）
+// 店舗のメインメニューかベースURLが未設定の場合はトークンのみ
+func tableTokenURL(baseURL string, user *models.User, store models.Store, token string) string {
+	base := strings.TrimRight(baseURL, "/")
+	if store.MainMenuPage == "" || base == "" {
+		return token
+	}
+	return fmt.Sprintf("%s/html/view/%s/%s?table_token=%s",
+		base, url.PathEscape(user.Username), url.PathEscape(store.MainMenuPage),
+		url.QueryEscape(token))
+}
+
+// findActiveTableToken テーブルの有効な最新トークンを取得（なければnil）
+func findActiveTableToken(db *gorm.DB, tableID uint) (*models.TableToken, error) {
+	var record models.TableToken
+	err := db.Where("table_id = ? AND revoked_at IS NULL AND expires_at > ?", tableID, time.Now()).
+		Order("created_at DESC").
+		First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// revokeTableTokens テーブルの未失効トークンをすべて失効
+func revokeTableTokens(tx *gorm.DB, tableID uint) error {
+	return tx.Model(&models.TableToken{}).
+		Where("table_id = ? AND revoked_at IS NULL", tableID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// IssueTableToken 現在の来店セッション用のQRトークンを発行（以前のトークンは失効）
+func (h *TableHandler) IssueTableToken(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TTLMinutes int `json:"ttl_minutes"`
+	}
+	// リクエストボディがない場合もエラーにしない
+	c.ShouldBindJSON(&req)
+	ttl := defaultTableTokenTTL
+	if req.TTLMinutes > 0 {
+		ttl = time.Duration(req.TTLMinutes) * time.Minute
+	}
+
+	current, err := findOpenTableSession(h.DB, table.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "セッションの取得に失敗しました"})
+		return
+	}
+	if current == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "先に着席を登録してください"})
+		return
+	}
+
+	record := models.TableToken{
+		TableID:        table.ID,
+		TableSessionID: current.ID,
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := revokeTableTokens(tx, table.ID); err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの発行に失敗しました"})
+		return
+	}
+
+	token, err := signTableToken(h.Tokens, record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの発行に失敗しました"})
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "QRコードを発行しました",
+		"token":      token,
+		"payload":    tableTokenURL(h.BaseURL, CurrentUser(c), *store, token),
+		"expires_at": record.ExpiresAt,
+		"qr_url":     fmt.Sprintf("/api/tables/%d/token/qr.png", table.ID),
+	})
+}
+
+// GetTableTokenQR 有効なトークンのQRコード画像（PNG）を返す
+func (h *TableHandler) GetTableTokenQR(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	size := 256
+	if s, err := strconv.Atoi(c.Query("size")); err == nil && s >= 64 && s <= 1024 {
+		size = s
+	}
+
+	record, err := findActiveTableToken(h.DB, table.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの取得に失敗しました"})
+		return
+	}
+	if record == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "有効なQRコードがありません"})
+		return
+	}
+
+	token, err := signTableToken(h.Tokens, *record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの作成に失敗しました"})
+		return
+	}
+
+	store, err := loadStore(h.DB, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "店舗取得失敗"})
+		return
+	}
+
+	png, err := qrcode.Encode(tableTokenURL(h.BaseURL, CurrentUser(c), *store, token), qrcode.Medium, size)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "QRコードの生成に失敗しました"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+// RevokeTableTokens テーブルのQRトークンをすべて失効
+func (h *TableHandler) RevokeTableTokens(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+	table, ok := loadTable(c, h.DB, storeID)
+	if !ok {
+		return
+	}
+
+	if err := revokeTableTokens(h.DB, table.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの失効に失敗しました"})
+		return
+	}
+	events.Publish(table.StoreID, events.TableChanged, table)
+
+	c.JSON(http.StatusOK, gin.H{"message": "QRコードを無効にしました"})
+}
+
+// BindCartTable QRコードのトークンをカートに紐づける（以降の注文先テーブルになる）
+func BindCartTable(c *gin.Context, db *gorm.DB, signer *qrtoken.Signer) {
+	var req struct {
+		TableToken string `json:"table_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+
+	table, _, err := resolveTableToken(db, signer, req.TableToken)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set("table_token", req.TableToken)
+	session.Save()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "テーブルを設定しました",
+		"table_id":     table.ID,
+		"table_number": table.TableNumber,
+	})
+}
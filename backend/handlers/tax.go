@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"orderbase/billing"
+	"orderbase/models"
+
+	"gorm.io/gorm"
+)
+
+// pricesIncludeTax 店舗の価格が税込か取得
+func pricesIncludeTax(db *gorm.DB, storeID uint) (bool, error) {
+	var store models.Store
+	if err := db.Select("id", "price_includes_tax").First(&store, storeID).Error; err != nil {
+		return false, err
+	}
+	return store.PriceIncludesTax, nil
+}
+
+// diningOptionOrDefault 飲食の区分を検証（未指定の場合は店内飲食）
+func diningOptionOrDefault(option string) (string, bool) {
+	if option == "" {
+		return billing.DiningEatIn, true
+	}
+	return option, billing.IsValidDiningOption(option)
+}
+
+// taxLines 取り消されていない明細を税計算の対象にする
+func taxLines(items []models.OrderItem, priceIncludesTax bool) []billing.TaxLine {
+	lines := make([]billing.TaxLine, 0, len(items))
+	for _, item := range items {
+		if item.Status == models.OrderStatusCancelled {
+			continue
+		}
+		lines = append(lines, billing.TaxLine{
+			Rate:             item.TaxRate,
+			Amount:           item.LineTotal,
+			PriceIncludesTax: priceIncludesTax,
+		})
+	}
+	return lines
+}
+
+// applyOrderTax 明細から注文の税抜金額・税額・支払総額を計算
+func applyOrderTax(order *models.Order) {
+	summary := billing.CalculateTax(taxLines(order.Items, order.PriceIncludesTax))
+	order.Subtotal = summary.Subtotal
+	order.TaxAmount = summary.Tax
+	order.TotalPrice = summary.Total
+	order.Tax = &summary
+}
+
+// orderTax 注文の税率ごとの内訳
+func orderTax(order models.Order) *billing.TaxSummary {
+	summary := billing.CalculateTax(taxLines(order.Items, order.PriceIncludesTax))
+	return &summary
+}
+
+// cartTax カート内容の税額を計算（カートが空の場合は税込価格として扱う）
+// 事前に priceCartItems でオプション込みの小計を計算しておく
+func cartTax(db *gorm.DB, cartItems []models.CartItem, diningOption string) (billing.TaxSummary, error) {
+	includesTax := true
+	if len(cartItems) > 0 {
+		var err error
+		includesTax, err = pricesIncludeTax(db, cartItems[0].StoreID)
+		if err != nil {
+			return billing.TaxSummary{}, err
+		}
+	}
+
+	lines := make([]billing.TaxLine, 0, len(cartItems))
+	for _, item := range cartItems {
+		lines = append(lines, billing.TaxLine{
+			Rate:             billing.TaxRate(item.Product.TaxCategory, diningOption),
+			Amount:           item.LineTotal,
+			PriceIncludesTax: includesTax,
+		})
+	}
+	return billing.CalculateTax(lines), nil
+}
+
+// billTax 複数の注文をまとめた請求の税額を計算（税率ごとの端数処理は請求全体で1回）
+func billTax(orders []models.Order) billing.TaxSummary {
+	var lines []billing.TaxLine
+	for _, order := range orders {
+		lines = append(lines, taxLines(order.Items, order.PriceIncludesTax)...)
+	}
+	return billing.CalculateTax(lines)
+}
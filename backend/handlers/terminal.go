@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"orderbase/models"
+	"orderbase/security"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type TerminalHandler struct {
+	DB           *gorm.DB
+	Logins       *security.LoginGuard // PIN入力の試行制限（桁数が少ないため総当たりを防ぐ）
+	SecureCookie bool                 // 端末トークンのCookieをHTTPSのみで送る
+}
+
+const (
+	// 端末トークンを保存するCookie（ログアウト・ユーザー切り替えでは消えない）
+	terminalCookieName = "orderbase_terminal"
+	terminalCookieAge  = 365 * 24 * 60 * 60
+	// Cookieを使えないクライアント用のヘッダー
+	terminalTokenHeader = "X-Terminal-Token"
+)
+
+// hashSecretToken 端末トークン・APIトークンのハッシュ（DBにはハッシュのみ保存する）
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newSecretToken ランダムなトークンを生成
+func newSecretToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// validPIN PINが4〜8桁の数字か
+func validPIN(pin string) bool {
+	if len(pin) < 4 || len(pin) > 8 {
+		return false
+	}
+	for _, r := range pin {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// currentTerminal リクエスト元の登録済み端末を取得（未登録・解除済みの場合はレスポンス済み）
+func currentTerminal(c *gin.Context, db *gorm.DB) (*models.Terminal, bool) {
+	token := c.GetHeader(terminalTokenHeader)
+	if token == "" {
+		token, _ = c.Cookie(terminalCookieName)
+	}
+	if token == "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "この端末は登録されていません"})
+		return nil, false
+	}
+
+	var terminal models.Terminal
+	if err := db.Where("token_hash = ? AND revoked_at IS NULL", hashSecretToken(token)).First(&terminal).Error; err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "この端末は登録されていません"})
+		return nil, false
+	}
+	now := time.Now()
+	db.Model(&terminal).Update("last_used_at", now)
+	terminal.LastUsedAt = &now
+	return &terminal, true
+}
+
+// RegisterTerminal リクエスト元の端末を店舗の共有端末として登録（端末トークンをCookieに保存する）
+func (h *TerminalHandler) RegisterTerminal(c *gin.Context) {
+	userID := currentUserID(c)
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リクエストが不正です"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "端末名が必要です"})
+		return
+	}
+
+	token, err := newSecretToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "端末の登録に失敗しました"})
+		return
+	}
+	uid := userID
+	terminal := models.Terminal{
+		StoreID:   storeID,
+		Name:      req.Name,
+		TokenHash: hashSecretToken(token),
+		CreatedBy: &uid,
+	}
+	if err := h.DB.Create(&terminal).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "端末の登録に失敗しました"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(terminalCookieName, token, terminalCookieAge, "/", "", h.SecureCookie, true)
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "端末を登録しました",
+		"terminal": terminal,
+		"token":    token, // Cookieを使えないクライアントは X-Terminal-Token ヘッダーで送る（再表示はできない）
+	})
+}
+
+// GetTerminals 店舗に登録した共有端末の一覧を取得
+func (h *TerminalHandler) GetTerminals(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var terminals []models.Terminal
+	if err := h.DB.Where("store_id = ?", storeID).Order("id ASC").Find(&terminals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "端末の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"terminals": terminals})
+}
+
+// RevokeTerminal 共有端末の登録を解除（以降その端末ではPINでログインできない）
+func (h *TerminalHandler) RevokeTerminal(c *gin.Context) {
+	storeID, ok := currentStoreID(c)
+	if !ok {
+		return
+	}
+
+	var terminal models.Terminal
+	if err := h.DB.Where("store_id = ?", storeID).First(&terminal, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "端末が見つかりません"})
+		return
+	}
+	if terminal.RevokedAt == nil {
+		now := time.Now()
+		if err := h.DB.Model(&terminal).Update("revoked_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "端末の登録解除に失敗しました"})
+			return
+		}
+		terminal.RevokedAt = &now
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "端末の登録を解除しました", "terminal": terminal})
+}
+
+// GetTerminalStaff 登録済み端末でPINログインできるスタッフの一覧を取得（ログイン不要）
+func (h *TerminalHandler) GetTerminalStaff(c *gin.Context) {
+	terminal, ok := currentTerminal(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var users []models.User
+	if err := h.DB.Select("id", "username", "role").
+		Where("store_id = ? AND pin_hash <> ''", terminal.StoreID).
+		Order("username ASC").
+		Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "スタッフの取得に失敗しました"})
+		return
+	}
+
+	type pinStaff struct {
+		ID       uint   `json:"id"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	staff := make([]pinStaff, 0, len(users))
+	for _, user := range users {
+		staff = append(staff, pinStaff{ID: user.ID, Username: user.Username, Role: user.Role})
+	}
+	c.JSON(http.StatusOK, gin.H{"terminal": terminal, "staff": staff})
+}
+
+// PINLogin 登録済み端末でPINによりログイン
+// ログイン中に別のスタッフがPINを入力した場合はそのスタッフに切り替える（端末とカートはそのまま）
+func (h *TerminalHandler) PINLogin(c *gin.Context) {
+	terminal, ok := currentTerminal(c, h.DB)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID uint   `json:"user_id"`
+		PIN    string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserID == 0 || req.PIN == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+
+	now := time.Now()
+	ip := c.ClientIP()
+	accountKey := security.AccountKey(models.LoginMethodPIN, strconv.FormatUint(uint64(req.UserID), 10))
+	if wait := h.Logins.Wait(ip, accountKey, now); wait > 0 {
+		recordLoginAttempt(h.DB, c, nil, "", models.LoginMethodPIN, models.LoginFailThrottled)
+		respondTooManyAttempts(c, wait)
+		return
+	}
+
+	var user models.User
+	if err := h.DB.Where("store_id = ?", terminal.StoreID).First(&user, req.UserID).Error; err != nil || user.PINHash == "" {
+		h.Logins.Fail(ip, accountKey, now)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "PINが違います"})
+		return
+	}
+	if user.PINLockedUntil != nil && now.Before(*user.PINLockedUntil) {
+		h.Logins.IP.Fail(ip, now)
+		recordLoginAttempt(h.DB, c, &user, user.Username, models.LoginMethodPIN, models.LoginFailLocked)
+		respondTooManyAttempts(c, user.PINLockedUntil.Sub(now))
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PINHash), []byte(req.PIN)) != nil {
+		h.Logins.Fail(ip, accountKey, now)
+		h.recordPINFailure(&user, now)
+		recordLoginAttempt(h.DB, c, &user, user.Username, models.LoginMethodPIN, models.LoginFailWrongPassword)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "PINが違います"})
+		return
+	}
+	if user.FailedPINs > 0 || user.PINLockedUntil != nil {
+		h.DB.Model(&user).Updates(map[string]interface{}{"failed_pins": 0, "pin_locked_until": nil})
+	}
+	// 二段階認証が有効・必須のユーザーはPINだけでログインさせない（確認コードを省略できてしまうため）
+	if user.TOTPEnabled || twoFactorRequired(h.DB, &user) {
+		recordLoginAttempt(h.DB, c, &user, user.Username, models.LoginMethodPIN, models.LoginFailTwoFactorRequired)
+		c.JSON(http.StatusForbidden, gin.H{"error": "二段階認証を使うユーザーはPINでログインできません。ユーザー名とパスワードでログインしてください"})
+		return
+	}
+	h.Logins.Succeed(accountKey)
+	recordLoginAttempt(h.DB, c, &user, user.Username, models.LoginMethodPIN, "")
+
+	session := sessions.Default(c)
+	session.Set("user", user.Username)
+	session.Set("user_id", user.ID)
+	session.Set("terminal_id", terminal.ID)
+	session.Delete(twoFactorVerifiedKey)
+	session.Save()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "ログイン成功",
+		"username":    user.Username,
+		"role":        user.Role,
+		"permissions": models.RolePermissions(user.Role),
+	})
+}
+
+// recordPINFailure PINの連続失敗回数を増やし、上限に達したらPINでのログインを一時ロック
+// （再起動で消えるメモリ上の試行制限だけでは、4桁のPINを時間をかけて総当たりできてしまうため）
+func (h *TerminalHandler) recordPINFailure(user *models.User, now time.Time) {
+	failures := user.FailedPINs + 1
+	if failures < h.Logins.LockoutThreshold {
+		h.DB.Model(user).Update("failed_pins", gorm.Expr("failed_pins + 1"))
+		return
+	}
+	lockedUntil := now.Add(h.Logins.LockoutDuration)
+	h.DB.Model(user).Updates(map[string]interface{}{"failed_pins": 0, "pin_locked_until": lockedUntil})
+}
+
+// LockTerminal 共有端末をロック（ログイン中のスタッフをログアウトさせ、次のスタッフのPIN入力を待つ）
+func (h *TerminalHandler) LockTerminal(c *gin.Context) {
+	if _, ok := currentTerminal(c, h.DB); !ok {
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Delete("user")
+	session.Delete("user_id")
+	session.Delete("terminal_id")
+	session.Delete(twoFactorVerifiedKey)
+	session.Save()
+
+	c.JSON(http.StatusOK, gin.H{"message": "端末をロックしました"})
+}
+
+// SetPIN ログイン中のユーザーのPINを設定（現在のパスワードで本人確認する）
+func (h *TerminalHandler) SetPIN(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		PIN      string `json:"pin"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+	if !validPIN(req.PIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PINは4〜8桁の数字で入力してください"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		return
+	}
+	if err := savePIN(h.DB, user, req.PIN); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "PINの設定に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "PINを設定しました"})
+}
+
+// savePIN PINをハッシュ化して保存（PINの一時ロックも解除する）
+func savePIN(db *gorm.DB, user *models.User, pin string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return db.Model(user).Updates(map[string]interface{}{"pin_hash": string(hash), "failed_pins": 0, "pin_locked_until": nil}).Error
+}
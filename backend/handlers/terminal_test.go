@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"orderbase/models"
+	"orderbase/security"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testTerminalToken = "test-terminal-token"
+
+// terminalFixture 共有端末とPINを設定したスタッフ
+type terminalFixture struct {
+	db       *gorm.DB
+	user     models.User
+	terminal models.Terminal
+}
+
+func newTerminalFixture(t *testing.T) *terminalFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Store{}, &models.User{}, &models.Terminal{}, &models.LoginAttempt{}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &terminalFixture{db: db}
+	store := models.Store{Name: "テスト店舗"}
+	mustCreate(t, db, &store)
+	f.user = models.User{Username: "hall", StoreID: &store.ID, Role: models.RoleStaff}
+	mustCreate(t, db, &f.user)
+	if err := savePIN(db, &f.user, "1234"); err != nil {
+		t.Fatal(err)
+	}
+	f.terminal = models.Terminal{StoreID: store.ID, Name: "ホール1", TokenHash: hashSecretToken(testTerminalToken)}
+	mustCreate(t, db, &f.terminal)
+	return f
+}
+
+// newRouter PINログインとログインが必要なルートを持つルーター（呼ぶたびに試行制限が空になり、再起動と同じ状態になる）
+func (f *terminalFixture) newRouter(lockoutThreshold int) *gin.Engine {
+	// メモリ上の待ち時間は発生させず、アカウントの一時ロックだけを確認する
+	unlimited := security.LimiterConfig{FreeAttempts: 1000, BaseDelay: time.Second, MaxDelay: time.Second, ResetAfter: time.Hour}
+	h := &TerminalHandler{DB: f.db, Logins: &security.LoginGuard{
+		IP:               security.NewLimiter(unlimited),
+		Account:          security.NewLimiter(unlimited),
+		LockoutThreshold: lockoutThreshold,
+		LockoutDuration:  15 * time.Minute,
+	}}
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("test-session-secret"))))
+	router.POST("/terminal/pin-login", h.PINLogin)
+	router.GET("/me", RequireLogin(f.db), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"username": CurrentUser(c).Username})
+	})
+	return router
+}
+
+// pinLogin 共有端末からPINでログインする
+func (f *terminalFixture) pinLogin(t *testing.T, router *gin.Engine, pin string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(gin.H{"user_id": f.user.ID, "pin": pin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/terminal/pin-login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(terminalTokenHeader, testTerminalToken)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestPINLoginLocksAfterRepeatedFailures(t *testing.T) {
+	f := newTerminalFixture(t)
+	router := f.newRouter(3)
+
+	for i := 0; i < 3; i++ {
+		if w := f.pinLogin(t, router, "9999"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, body = %s", i+1, w.Code, w.Body)
+		}
+	}
+
+	// 一時ロックはDBに保存され、再起動後（試行制限が空）も正しいPINを受け付けない
+	restarted := f.newRouter(3)
+	if w := f.pinLogin(t, restarted, "1234"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked login status = %d, body = %s", w.Code, w.Body)
+	}
+	var attempt models.LoginAttempt
+	if err := f.db.Order("id DESC").First(&attempt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if attempt.Reason != models.LoginFailLocked {
+		t.Errorf("reason = %q, want %q", attempt.Reason, models.LoginFailLocked)
+	}
+
+	// ロックが解除されるとログインでき、失敗回数もリセットされる
+	if err := f.db.Model(&f.user).Update("pin_locked_until", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if w := f.pinLogin(t, restarted, "1234"); w.Code != http.StatusOK {
+		t.Fatalf("login after lock status = %d, body = %s", w.Code, w.Body)
+	}
+	var user models.User
+	if err := f.db.First(&user, f.user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedPINs != 0 || user.PINLockedUntil != nil {
+		t.Errorf("failed_pins = %d, pin_locked_until = %v; want 0, nil", user.FailedPINs, user.PINLockedUntil)
+	}
+}
+
+func TestRevokedTerminalSessionRejected(t *testing.T) {
+	f := newTerminalFixture(t)
+	router := f.newRouter(10)
+
+	login := f.pinLogin(t, router, "1234")
+	if login.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body = %s", login.Code, login.Body)
+	}
+	me := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		for _, c := range login.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := me(); code != http.StatusOK {
+		t.Fatalf("before revoke status = %d, want 200", code)
+	}
+
+	// 端末の登録を取り消すと、その端末でPINログインしたセッションは使えなくなる
+	if err := f.db.Model(&f.terminal).Update("revoked_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := me(); code != http.StatusUnauthorized {
+		t.Fatalf("after revoke status = %d, want 401", code)
+	}
+}
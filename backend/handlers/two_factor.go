@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"orderbase/models"
+	"orderbase/security"
+	"orderbase/totp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type TwoFactorHandler struct {
+	DB     *gorm.DB
+	Logins *security.LoginGuard // 確認コードの試行制限
+}
+
+const (
+	// 認証アプリに表示するサービス名
+	twoFactorIssuer = "OrderBase"
+	// 発行するリカバリーコードの数
+	recoveryCodeCount = 10
+	// パスワードの確認後、確認コードを入力できる時間
+	twoFactorLoginTimeout = 5 * time.Minute
+
+	// パスワードの確認が済み、確認コードの入力を待っているユーザーを保存するセッションのキー
+	pendingTwoFactorUserKey = "pending_2fa_user_id"
+	pendingTwoFactorAtKey   = "pending_2fa_at"
+	// 二段階認証の確認が済んだユーザーのIDを保存するセッションのキー（ログイン中のユーザーと一致する場合のみ有効）
+	twoFactorVerifiedKey = "two_factor_verified"
+)
+
+// twoFactorRequired 店舗の設定でユーザーの役割に二段階認証が必須になっているか
+func twoFactorRequired(db *gorm.DB, user *models.User) bool {
+	if user.StoreID == nil || !models.RoleRequiresTwoFactor(user.Role) {
+		return false
+	}
+	store, err := loadStore(db, *user.StoreID)
+	return err == nil && store.RequireTwoFactor
+}
+
+// twoFactorSetupRequired 二段階認証が必須なのに、まだ有効にしていないユーザーか
+func twoFactorSetupRequired(db *gorm.DB, user *models.User) bool {
+	return !user.TOTPEnabled && twoFactorRequired(db, user)
+}
+
+// twoFactorVerified セッションでログイン中のユーザーの二段階認証の確認が済んでいるか
+func twoFactorVerified(c *gin.Context, user *models.User) bool {
+	verifiedUserID, ok := sessions.Default(c).Get(twoFactorVerifiedKey).(uint)
+	return ok && verifiedUserID == user.ID
+}
+
+// RequireTwoFactorSetup 店舗で二段階認証が必須の場合、設定が済むまで操作させないミドルウェア
+// 二段階認証が有効なユーザーは、このセッションで確認コードの確認が済んでいない場合も拒否する（PINなど確認コードを経ないログインへの対策）
+// RequireLogin の後に使う（二段階認証の設定APIには使わない）
+// APIトークンは発行時のログインで確認済みのため確認コードは求めないが、設定が必須になった後は設定が済むまで使わせない
+func RequireTwoFactorSetup(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			c.Next()
+			return
+		}
+		if CurrentAPIToken(c) == nil && user.TOTPEnabled && !twoFactorVerified(c, user) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error":               "二段階認証の確認が必要です。もう一度ログインしてください",
+				"two_factor_required": true,
+			})
+			return
+		}
+		if twoFactorSetupRequired(db, user) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":                     "二段階認証の設定が必要です",
+				"two_factor_setup_required": true,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// normalizeRecoveryCode 入力されたリカバリーコードの区切り・大文字小文字をそろえる
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// issueRecoveryCodes リカバリーコードを発行し直す（以前のコードは使えなくなる）
+func issueRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(buf)
+		record := models.RecoveryCode{UserID: userID, CodeHash: hashSecretToken(code)}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// remainingRecoveryCodes 未使用のリカバリーコードの数
+func remainingRecoveryCodes(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// verifySecondFactor 確認コードまたはリカバリーコードを検証（使ったコードは再利用できないようにする）
+func verifySecondFactor(db *gorm.DB, user *models.User, code, recoveryCode string) bool {
+	if code != "" {
+		step, ok := totp.Verify(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return false
+		}
+		// 同時に同じコードで認証された場合はどちらか一方のみ成功させる
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	if recoveryCode != "" {
+		result := db.Model(&models.RecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashSecretToken(normalizeRecoveryCode(recoveryCode))).
+			Update("used_at", time.Now())
+		return result.Error == nil && result.RowsAffected == 1
+	}
+	return false
+}
+
+// disableTwoFactor 二段階認証を無効にし、シークレットとリカバリーコードを削除
+func disableTwoFactor(db *gorm.DB, user *models.User) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":    "",
+			"totp_enabled":   false,
+			"totp_last_step": 0,
+		}).Error
+	})
+}
+
+// GetTwoFactorStatus ログイン中のユーザーの二段階認証の状態を取得
+func (h *TwoFactorHandler) GetTwoFactorStatus(c *gin.Context) {
+	user := CurrentUser(c)
+
+	var remaining int64
+	if user.TOTPEnabled {
+		remaining = remainingRecoveryCodes(h.DB, user.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"required":                 twoFactorRequired(h.DB, user),
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor 二段階認証のシークレットを生成（現在のパスワードで本人確認する）
+// 認証アプリに登録した後、EnableTwoFactor で確認コードを送ると有効になる
+func (h *TwoFactorHandler) SetupTwoFactor(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二段階認証は既に有効です"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二段階認証の設定に失敗しました"})
+		return
+	}
+	if err := h.DB.Model(user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二段階認証の設定に失敗しました"})
+		return
+	}
+
+	uri := totp.URI(twoFactorIssuer, user.Username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "QRコードの生成に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "認証アプリで読み取り、表示された確認コードを入力してください",
+		"secret":      secret,
+		"otpauth_uri": uri,
+		"qr_code":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// EnableTwoFactor 認証アプリの確認コードを検証して二段階認証を有効にし、リカバリーコードを発行
+func (h *TwoFactorHandler) EnableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "確認コードが必要です"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二段階認証は既に有効です"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "先に二段階認証の設定を開始してください"})
+		return
+	}
+	if !verifySecondFactor(h.DB, user, req.Code, "") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "確認コードが違います"})
+		return
+	}
+
+	var codes []string
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if codes, err = issueRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		return tx.Model(user).Update("totp_enabled", true).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二段階認証の有効化に失敗しました"})
+		return
+	}
+
+	// 確認コードを確認したので、このセッションはそのまま使えるようにする
+	session := sessions.Default(c)
+	session.Set(twoFactorVerifiedKey, user.ID)
+	session.Save()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "二段階認証を有効にしました",
+		"recovery_codes": codes, // 認証アプリを使えない場合に1回ずつ使える（再表示はできない）
+	})
+}
+
+// DisableTwoFactor 二段階認証を無効にする（パスワードと確認コードまたはリカバリーコードで本人確認する）
+func (h *TwoFactorHandler) DisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二段階認証は有効になっていません"})
+		return
+	}
+	if twoFactorRequired(h.DB, user) {
+		c.JSON(http.StatusConflict, gin.H{"error": "店舗の設定で二段階認証が必須になっているため無効にできません"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		return
+	}
+	if !verifySecondFactor(h.DB, user, req.Code, req.RecoveryCode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "確認コードが違います"})
+		return
+	}
+
+	if err := disableTwoFactor(h.DB, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "二段階認証の無効化に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "二段階認証を無効にしました"})
+}
+
+// RegenerateRecoveryCodes リカバリーコードを発行し直す（パスワードと確認コードで本人確認する）
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "入力エラー"})
+		return
+	}
+
+	user := CurrentUser(c)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "二段階認証は有効になっていません"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが違います"})
+		return
+	}
+	if !verifySecondFactor(h.DB, user, req.Code, "") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "確認コードが違います"})
+		return
+	}
+
+	codes, err := issueRecoveryCodes(h.DB, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "リカバリーコードの発行に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "リカバリーコードを発行し直しました",
+		"recovery_codes": codes,
+	})
+}
+
+// VerifyLoginTwoFactor ログインの2段階目（LoginUser でパスワードを確認した後に確認コードまたはリカバリーコードを送る）
+func (h *TwoFactorHandler) VerifyLoginTwoFactor(c *gin.Context) {
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Code == "" && req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "確認コードが必要です"})
+		return
+	}
+
+	session := sessions.Default(c)
+	userID, ok := session.Get(pendingTwoFactorUserKey).(uint)
+	startedAt, _ := session.Get(pendingTwoFactorAtKey).(int64)
+	now := time.Now()
+	if !ok || now.Sub(time.Unix(startedAt, 0)) > twoFactorLoginTimeout {
+		session.Delete(pendingTwoFactorUserKey)
+		session.Delete(pendingTwoFactorAtKey)
+		session.Save()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "もう一度ユーザー名とパスワードでログインしてください"})
+		return
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, userID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "もう一度ユーザー名とパスワードでログインしてください"})
+		return
+	}
+
+	method := models.LoginMethodTOTP
+	if req.Code == "" {
+		method = models.LoginMethodRecovery
+	}
+	ip := c.ClientIP()
+	accountKey := security.AccountKey(models.LoginMethodTOTP, strconv.FormatUint(uint64(user.ID), 10))
+	if wait := h.Logins.Wait(ip, accountKey, now); wait > 0 {
+		recordLoginAttempt(h.DB, c, &user, user.Username, method, models.LoginFailThrottled)
+		respondTooManyAttempts(c, wait)
+		return
+	}
+	if !verifySecondFactor(h.DB, &user, req.Code, req.RecoveryCode) {
+		h.Logins.Fail(ip, accountKey, now)
+		recordLoginAttempt(h.DB, c, &user, user.Username, method, models.LoginFailWrongCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "確認コードが違います"})
+		return
+	}
+
+	h.Logins.Succeed(accountKey)
+	recordLoginAttempt(h.DB, c, &user, user.Username, method, "")
+	setLoginSession(session, &user, true)
+
+	response := gin.H{"message": "ログイン成功"}
+	if method == models.LoginMethodRecovery {
+		response["recovery_codes_remaining"] = remainingRecoveryCodes(h.DB, user.ID)
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"orderbase/models"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testAPIToken = "test-api-token"
+
+func TestAPITokenRejectedUntilTwoFactorSetup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Store{}, &models.User{}, &models.APIToken{}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := models.Store{Name: "テスト店舗"}
+	mustCreate(t, db, &store)
+	owner := models.User{Username: "owner", StoreID: &store.ID, Role: models.RoleOwner}
+	mustCreate(t, db, &owner)
+	mustCreate(t, db, &models.APIToken{StoreID: store.ID, UserID: owner.ID, Name: "会計スクリプト", TokenHash: hashSecretToken(testAPIToken)})
+
+	router := gin.New()
+	router.Use(sessions.Sessions("test", cookie.NewStore([]byte("test-session-secret"))))
+	router.GET("/me", RequireLogin(db), RequireTwoFactorSetup(db), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"username": CurrentUser(c).Username})
+	})
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+testAPIToken)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("二段階認証が任意の店舗: status = %d, want 200", code)
+	}
+
+	// 必須にした後は、発行済みのトークンも所有者が設定するまで使えない
+	if err := db.Model(&store).Update("require_two_factor", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := get(); code != http.StatusForbidden {
+		t.Fatalf("二段階認証が未設定: status = %d, want 403", code)
+	}
+
+	// 設定が済めば、確認コードなしでトークンを使える
+	if err := db.Model(&owner).Update("totp_enabled", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("二段階認証を設定済み: status = %d, want 200", code)
+	}
+}
@@ -0,0 +1,44 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer メールを送信せず、ディレクトリに .eml ファイルとして保存する（メールサーバーのない開発環境用）
+// Dir が空の場合はログに本文を出力する
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	if m.Dir == "" {
+		log.Printf("メール送信（保存先未設定のためログに出力）: To=%s Subject=%s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	m.mu.Lock()
+	m.seq++
+	name := fmt.Sprintf("%s-%03d.eml", now.Format("20060102-150405"), m.seq)
+	m.mu.Unlock()
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, render(m.From, msg, now), 0o600); err != nil {
+		return err
+	}
+	log.Printf("メール送信（ファイルに保存）: To=%s Subject=%s File=%s", msg.To, msg.Subject, path)
+	return nil
+}
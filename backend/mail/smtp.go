@@ -0,0 +1,27 @@
+package mail
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+// SMTPMailer SMTPサーバー経由でメールを送信する
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // 空の場合は認証しない
+	Password string
+	From     string // 送信元アドレス
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, render(m.From, msg, time.Now()))
+}
@@ -2,9 +2,19 @@
 package main
 
 import (
-	"net/http"
+	"context"
+	"flag"
+	"log"
+	"orderbase/config"
 	"orderbase/handlers"
+	"orderbase/mail"
 	"orderbase/models"
+	"orderbase/payments"
+	"orderbase/printing"
+	"orderbase/qrtoken"
+	"orderbase/security"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -17,20 +27,71 @@ import (
 
 var db *gorm.DB
 
+// 設定ファイルと環境変数から読み込んだ設定
+var cfg *config.Config
+
+// 保存する秘密情報（OpenAI APIキー）の暗号化に使う鍵
+var secrets *security.Keyring
+
 func main() {
+	configPath := flag.String("config", os.Getenv("ORDERBASE_CONFIG"), "設定ファイル（JSON）のパス")
+	flag.Parse()
+
+	// 設定に問題がある場合は起動しない
+	var err error
+	cfg, err = config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("設定が不正なため起動できません:\n%v", err)
+	}
+	if cfg.IsProduction() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	secrets = secretKeyring()
 	initDB()
 	r := setupRouter()
-	r.Run(":8080")
+	if err := r.Run(cfg.Server.Addr); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func initDB() {
 	var err error
-	db, err = gorm.Open(sqlite.Open("users.db"), &gorm.Config{})
+	db, err = gorm.Open(sqlite.Open(cfg.Database.Path), &gorm.Config{})
 	if err != nil {
 		panic("DB接続失敗")
 	}
 	// Tag関連を除外し、ProductはJSON形式のtagsで管理
-	db.AutoMigrate(&models.User{}, &models.Product{}, &models.HTMLPage{}, &models.Order{}, &models.CartItem{}, &models.Table{})
+	db.AutoMigrate(&models.Store{}, &models.User{}, &models.Product{}, &models.HTMLPage{}, &models.Order{}, &models.OrderItem{}, &models.OrderStatusEvent{}, &models.CartItem{}, &models.Table{}, &models.TableSession{}, &models.TableToken{}, &models.Payment{}, &models.BillSplit{}, &models.BillSplitPart{}, &models.BillSplitItem{}, &models.Receipt{}, &models.ReceiptLine{}, &models.ReceiptTax{}, &models.Printer{}, &models.PrintJob{}, &models.Category{}, &models.OptionGroup{}, &models.Option{}, &models.CartItemOption{}, &models.OrderItemOption{}, &models.StockMovement{}, &models.Ingredient{}, &models.RecipeItem{}, &models.IngredientMovement{}, &models.Terminal{}, &models.APIToken{}, &models.LoginAttempt{}, &models.RecoveryCode{})
+
+	// 旧形式の注文データを明細行形式に移行
+	if err := models.MigrateLegacyOrders(db); err != nil {
+		panic("注文データの移行失敗: " + err.Error())
+	}
+	if err := models.MigrateOrderStatuses(db); err != nil {
+		panic("注文ステータスの移行失敗: " + err.Error())
+	}
+	if err := models.MigrateOrderItemStations(db); err != nil {
+		panic("調理場所の移行失敗: " + err.Error())
+	}
+	if err := models.MigrateOrderTaxes(db); err != nil {
+		panic("消費税データの移行失敗: " + err.Error())
+	}
+	// 店舗導入前のデータをデフォルト店舗に移行
+	if err := models.MigrateStores(db); err != nil {
+		panic("店舗データの移行失敗: " + err.Error())
+	}
+	if err := models.MigrateUserRoles(db); err != nil {
+		panic("役割データの移行失敗: " + err.Error())
+	}
+	// 平文のAPIキーの暗号化と、鍵のローテーション後の再暗号化
+	migrated, err := models.MigrateSecrets(db, secrets)
+	if err != nil {
+		panic("秘密情報の暗号化失敗: " + err.Error())
+	}
+	if migrated > 0 {
+		log.Printf("保存済みの秘密情報 %d 件を鍵のバージョン %d で暗号化しました", migrated, secrets.CurrentVersion())
+	}
 
 }
 
@@ -38,101 +99,281 @@ func setupRouter() *gin.Engine {
 	r := gin.Default()
 
 	// CORS設定（最初に適用）
-	r.Use(cors.New(cors.Config{
+	corsConfig := cors.Config{
 		AllowMethods:     []string{"GET", "POST", "DELETE", "PUT", "PATCH", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"},
 		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
 		AllowCredentials: true,
-		AllowOriginFunc: func(origin string) bool {
-			// localhost、ポート8080、TailscaleのIPアドレスからのアクセスを許可
-			return true // 開発環境では全て許可（本番環境では適切に制限してください）
-		},
-		MaxAge: 12 * time.Hour,
-	}))
+		MaxAge:           12 * time.Hour,
+	}
+	if len(cfg.Server.AllowedOrigins) > 0 {
+		corsConfig.AllowOrigins = cfg.Server.AllowedOrigins
+	} else {
+		// 開発環境でオリジンを指定しない場合は全て許可（本番環境では設定の検証で指定を必須にしている）
+		corsConfig.AllowOriginFunc = func(origin string) bool { return true }
+	}
+	r.Use(cors.New(corsConfig))
 
 	// セッションの設定
-	store := cookie.NewStore([]byte("secret"))
+	store := cookie.NewStore(secretOrRandom("SESSION_SECRET", cfg.Session.Secret))
 	store.Options(sessions.Options{
 		Path:     "/",
-		MaxAge:   3600,
+		MaxAge:   int(time.Duration(cfg.Session.MaxAge) / time.Second),
 		HttpOnly: true,
-		Secure:   false,
-		SameSite: http.SameSiteLaxMode,
+		Secure:   cfg.Session.Secure,
+		SameSite: cfg.SameSiteMode(),
 	})
 	r.Use(sessions.Sessions("mysession", store))
 
 	// 静的ファイル
-	r.Static("/static", "./static")
+	r.Static("/static", cfg.Storage.StaticDir)
 
 	// 静的ファイル（画像）公開
-	r.Static("/uploads", "./uploads")
-	authHandler := &handlers.AuthHandler{DB: db}
-	productHandler := &handlers.ProductHandler{DB: db}
+	r.Static("/uploads", cfg.Storage.UploadDir)
+	// テーブルQRコード用トークンの署名鍵
+	tableTokens := qrtoken.NewSigner(secretOrRandom("TABLE_TOKEN_SECRET", cfg.Tokens.TableSecret))
+
+	// 印刷ジョブの送信（プリンターがオフラインの間は再試行する）
+	spooler := printing.NewSpooler(db)
+	go spooler.Run(context.Background())
+
+	// ログイン試行の制限とパスワードの条件（スタッフ・共有端末のログインで共有する）
+	loginGuard := security.DefaultLoginGuard()
+	passwords := passwordPolicy()
+
+	// パスワード再設定・メールアドレス確認のトークンの署名鍵
+	accountKey := secretOrRandom("ACCOUNT_TOKEN_SECRET", cfg.Tokens.AccountSecret)
+
+	authHandler := &handlers.AuthHandler{DB: db, Passwords: passwords, Logins: loginGuard, Secrets: secrets}
+	productHandler := &handlers.ProductHandler{DB: db, UploadDir: cfg.Storage.UploadDir}
 	htmlHandler := &handlers.HTMLHandler{DB: db}
-	openaiHandler := &handlers.OpenAIHandler{DB: db}
-	tableHandler := &handlers.TableHandler{DB: db}
+	openaiHandler := &handlers.OpenAIHandler{DB: db, Secrets: secrets}
+	tableHandler := &handlers.TableHandler{DB: db, Tokens: tableTokens, BaseURL: cfg.Server.BaseURL}
+	// カード・QR決済は店舗の決済端末で行い、取引番号を記録する
+	paymentHandler := &handlers.PaymentHandler{
+		DB: db,
+		Providers: payments.NewRegistry(
+			payments.CashProvider{},
+			payments.NewTerminalProvider(payments.MethodCard),
+			payments.NewTerminalProvider(payments.MethodQR),
+		),
+	}
+	kdsHandler := &handlers.KDSHandler{DB: db}
+	receiptHandler := &handlers.ReceiptHandler{DB: db, Spooler: spooler}
+	printerHandler := &handlers.PrinterHandler{DB: db, Spooler: spooler}
+	categoryHandler := &handlers.CategoryHandler{DB: db}
+	optionHandler := &handlers.OptionHandler{DB: db}
+	inventoryHandler := &handlers.InventoryHandler{DB: db}
+	ingredientHandler := &handlers.IngredientHandler{DB: db}
+	storeHandler := &handlers.StoreHandler{DB: db}
+	staffHandler := &handlers.StaffHandler{DB: db, Passwords: passwords}
+	terminalHandler := &handlers.TerminalHandler{DB: db, Logins: loginGuard, SecureCookie: cfg.Session.Secure}
+	apiTokenHandler := &handlers.APITokenHandler{DB: db}
+	loginAttemptHandler := &handlers.LoginAttemptHandler{DB: db}
+	twoFactorHandler := &handlers.TwoFactorHandler{DB: db, Logins: loginGuard}
+	accountHandler := &handlers.AccountHandler{
+		DB:        db,
+		Mailer:    newMailer(),
+		Tokens:    security.NewTokenSigner(accountKey),
+		Passwords: passwords,
+		Logins:    loginGuard,
+		BaseURL:   cfg.Server.BaseURL,
+	}
+
+	// 役割に応じた操作の制限（権限がない場合は403）
+	can := handlers.RequirePermission
 
 	api := r.Group("/api")
+
+	// 公開API（ログイン不要）
+	public := api.Group("")
 	{
-		api.POST("/register", authHandler.RegisterUser)
-		api.POST("/login", authHandler.LoginUser)
-		api.GET("/dashboard", handlers.ShowDashboard)
-		api.GET("/dashboard/stats", func(c *gin.Context) { handlers.GetDashboardStats(c, db) })
-		api.GET("/logout", authHandler.LogoutUser)
-		api.GET("/ping", func(c *gin.Context) {
+		public.POST("/register", authHandler.RegisterUser)
+		public.POST("/login", authHandler.LoginUser)
+		public.POST("/login/2fa", twoFactorHandler.VerifyLoginTwoFactor)
+		public.GET("/logout", authHandler.LogoutUser)
+		public.GET("/password-policy", authHandler.GetPasswordPolicy)
+		public.POST("/password/forgot", accountHandler.ForgotPassword)
+		public.POST("/password/reset", accountHandler.ResetPassword)
+		public.POST("/email/verify", accountHandler.VerifyEmail)
+		public.GET("/ping", func(c *gin.Context) {
 			c.JSON(200, gin.H{"message": "pong"})
 		})
-		api.GET("/users", func(c *gin.Context) {
-			var users []models.User
-			if err := db.Find(&users).Error; err != nil {
-				c.JSON(500, gin.H{"error": "ユーザー取得失敗"})
-				return
-			}
-			c.JSON(200, users)
-		})
+		public.GET("/menu/:username", categoryHandler.GetMenu)
+
+		// 共有端末でのPINログイン（登録済み端末のみ）
+		public.GET("/terminal/staff", terminalHandler.GetTerminalStaff)
+		public.POST("/terminal/pin-login", terminalHandler.PINLogin)
+		public.POST("/terminal/lock", terminalHandler.LockTerminal)
+	}
+
+	// お客様向けAPI（ゲストのセッションで利用、ログイン不要）
+	customer := api.Group("")
+	{
+		customer.POST("/cart", func(c *gin.Context) { handlers.AddToCart(c, db) })
+		customer.GET("/cart", func(c *gin.Context) { handlers.GetCart(c, db) })
+		customer.PATCH("/cart/:id", func(c *gin.Context) { handlers.UpdateCartItem(c, db) })
+		customer.DELETE("/cart/:id", func(c *gin.Context) { handlers.RemoveFromCart(c, db) })
+		customer.POST("/cart/table", func(c *gin.Context) { handlers.BindCartTable(c, db, tableTokens) })
+		customer.POST("/cart/checkout", func(c *gin.Context) { handlers.CheckoutCart(c, db, tableTokens, spooler) })
+		customer.DELETE("/cart/clear", func(c *gin.Context) { handlers.ClearCart(c, db) })
+	}
+
+	// ログインが必要なAPI（ログイン中のユーザーはハンドラーで CurrentUser から取得する）
+	// Cookieのセッションの代わりに Authorization: Bearer でAPIトークンを送ることもできる
+	loggedIn := api.Group("", handlers.RequireLogin(db))
+
+	// 二段階認証の設定API（店舗で必須にされていて未設定の場合も使える）
+	account := loggedIn.Group("/2fa", handlers.RequireSession)
+	{
+		account.GET("", twoFactorHandler.GetTwoFactorStatus)
+		account.POST("/setup", twoFactorHandler.SetupTwoFactor)
+		account.POST("/enable", twoFactorHandler.EnableTwoFactor)
+		account.POST("/disable", twoFactorHandler.DisableTwoFactor)
+		account.POST("/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+	}
+
+	// スタッフ向けAPI（店舗で二段階認証が必須の場合は設定が済むまで、有効なユーザーはこのセッションで確認コードを確認するまで使えない）
+	staff := loggedIn.Group("", handlers.RequireTwoFactorSetup(db))
+	{
+		staff.GET("/dashboard", handlers.ShowDashboard)
+		staff.GET("/dashboard/stats", can(models.PermReportsView), func(c *gin.Context) { handlers.GetDashboardStats(c, db) })
+		staff.GET("/users", can(models.PermStaffManage), storeHandler.GetStoreUsers)
+		staff.GET("/store", storeHandler.GetStore)
+		staff.PATCH("/store/security-settings", can(models.PermStoreSettings), handlers.RequireSession, storeHandler.SetSecuritySettings)
+
+		// スタッフ・役割の管理API（オーナーのみ）
+		staff.GET("/staff", can(models.PermStaffManage), staffHandler.GetStaff)
+		staff.POST("/staff", can(models.PermStaffManage), staffHandler.InviteStaff)
+		staff.PATCH("/staff/:id/role", can(models.PermStaffManage), staffHandler.UpdateStaffRole)
+		staff.DELETE("/staff/:id", can(models.PermStaffManage), staffHandler.RemoveStaff)
+		staff.PUT("/staff/:id/pin", can(models.PermStaffManage), staffHandler.SetStaffPIN)
+		staff.DELETE("/staff/:id/2fa", can(models.PermStaffManage), handlers.RequireSession, staffHandler.ResetStaffTwoFactor)
+		staff.GET("/login-attempts", can(models.PermSecurityAudit), loginAttemptHandler.GetLoginAttempts)
+
+		// 共有端末の管理API
+		staff.GET("/terminals", can(models.PermTerminalsManage), terminalHandler.GetTerminals)
+		staff.POST("/terminals", can(models.PermTerminalsManage), terminalHandler.RegisterTerminal)
+		staff.DELETE("/terminals/:id", can(models.PermTerminalsManage), terminalHandler.RevokeTerminal)
+		staff.PUT("/user/pin", handlers.RequireSession, terminalHandler.SetPIN)
+		staff.PUT("/user/email", handlers.RequireSession, accountHandler.UpdateEmail)
+		staff.POST("/user/email/verification", handlers.RequireSession, accountHandler.ResendEmailVerification)
+
+		// APIトークン（連携サービス・ヘッドレスクライアント用）の管理API
+		staff.GET("/api-tokens", handlers.RequireSession, apiTokenHandler.GetAPITokens)
+		staff.POST("/api-tokens", handlers.RequireSession, apiTokenHandler.CreateAPIToken)
+		staff.DELETE("/api-tokens/:id", handlers.RequireSession, apiTokenHandler.RevokeAPIToken)
+
 		// HTML関連API
-		api.GET("/html/list", htmlHandler.ListHTMLPages)
-		api.PUT("/html/save/:username/:page", htmlHandler.SaveHTMLPage)
-		api.GET("/html/get/:username/:page", htmlHandler.GetHTMLPage)
-		api.DELETE("/html/delete/:username/:page", htmlHandler.DeleteHTMLPage)
+		staff.GET("/html/list", can(models.PermStoreView), htmlHandler.ListHTMLPages)
+		staff.PUT("/html/save/:username/:page", can(models.PermMenuManage), htmlHandler.SaveHTMLPage)
+		staff.GET("/html/get/:username/:page", can(models.PermStoreView), htmlHandler.GetHTMLPage)
+		staff.DELETE("/html/delete/:username/:page", can(models.PermMenuManage), htmlHandler.DeleteHTMLPage)
 
 		// OpenAI関連API
-		api.POST("/openai/chat", openaiHandler.ChatCompletion)
-		api.POST("/openai/set-key", authHandler.SetOpenAIKey)
-		api.GET("/openai/get-key", authHandler.GetOpenAIKey)
+		staff.POST("/openai/chat", handlers.RequireSession, openaiHandler.ChatCompletion)
+		staff.POST("/openai/set-key", handlers.RequireSession, authHandler.SetOpenAIKey)
+		staff.GET("/openai/get-key", handlers.RequireSession, authHandler.GetOpenAIKey)
 
 		// メインメニュー設定API
-		api.PATCH("/user/main-menu", authHandler.SetMainMenu)
-		api.GET("/user/main-menu", authHandler.GetMainMenu)
+		staff.PATCH("/user/main-menu", can(models.PermStoreSettings), authHandler.SetMainMenu)
+		staff.GET("/user/main-menu", authHandler.GetMainMenu)
+		staff.PATCH("/user/tax-settings", can(models.PermStoreSettings), authHandler.SetTaxSettings)
+		staff.GET("/user/tax-settings", authHandler.GetTaxSettings)
+		staff.PATCH("/user/receipt-settings", can(models.PermStoreSettings), authHandler.SetReceiptSettings)
+		staff.GET("/user/receipt-settings", authHandler.GetReceiptSettings)
 
 		// テーブル管理API
-		api.POST("/tables", tableHandler.CreateTable)
-		api.GET("/tables", tableHandler.GetTables)
-		api.GET("/tables/:id", tableHandler.GetTableByID)
-		api.PATCH("/tables/:id", tableHandler.UpdateTable)
-		api.DELETE("/tables/:id", tableHandler.DeleteTable)
-		api.GET("/tables/:id/orders", tableHandler.GetTableOrders)
+		staff.POST("/tables", can(models.PermTablesManage), tableHandler.CreateTable)
+		staff.GET("/tables", can(models.PermStoreView), tableHandler.GetTables)
+		staff.GET("/tables/:id", can(models.PermStoreView), tableHandler.GetTableByID)
+		staff.PATCH("/tables/:id", can(models.PermTablesManage), tableHandler.UpdateTable)
+		staff.DELETE("/tables/:id", can(models.PermTablesManage), tableHandler.DeleteTable)
+		staff.GET("/tables/:id/orders", can(models.PermStoreView), tableHandler.GetTableOrders)
+		staff.GET("/tables/:id/sessions", can(models.PermStoreView), tableHandler.GetTableSessions)
+		staff.POST("/tables/:id/sessions", can(models.PermFloorOperate), tableHandler.OpenTableSession)
+		staff.POST("/tables/:id/sessions/close", can(models.PermFloorOperate), tableHandler.CloseTableSession)
+		staff.POST("/tables/:id/token", can(models.PermFloorOperate), tableHandler.IssueTableToken)
+		staff.GET("/tables/:id/token/qr.png", can(models.PermFloorOperate), tableHandler.GetTableTokenQR)
+		staff.DELETE("/tables/:id/token", can(models.PermFloorOperate), tableHandler.RevokeTableTokens)
+		staff.GET("/tables/:id/bill", can(models.PermFloorOperate), paymentHandler.GetTableBill)
+		staff.POST("/tables/:id/checkout", can(models.PermFloorOperate), paymentHandler.CheckoutTable)
+		staff.POST("/tables/:id/splits", can(models.PermFloorOperate), paymentHandler.CreateBillSplit)
+		staff.DELETE("/tables/:id/splits/:split_id", can(models.PermFloorOperate), paymentHandler.CancelBillSplit)
+		staff.POST("/tables/:id/splits/:split_id/parts/:part_id/pay", can(models.PermFloorOperate), paymentHandler.PayBillSplitPart)
+
+		// 領収書API
+		staff.POST("/receipts", can(models.PermFloorOperate), receiptHandler.IssueReceipt)
+		staff.GET("/receipts/:id", can(models.PermFloorOperate), receiptHandler.GetReceipt)
+		staff.POST("/receipts/:id/reprint", can(models.PermFloorOperate), receiptHandler.ReprintReceipt)
+
+		// プリンター・印刷ジョブAPI
+		staff.GET("/printers", can(models.PermStoreView), printerHandler.GetPrinters)
+		staff.POST("/printers", can(models.PermPrintersManage), printerHandler.CreatePrinter)
+		staff.PATCH("/printers/:id", can(models.PermPrintersManage), printerHandler.UpdatePrinter)
+		staff.DELETE("/printers/:id", can(models.PermPrintersManage), printerHandler.DeletePrinter)
+		staff.POST("/printers/:id/test", can(models.PermPrintersManage), printerHandler.TestPrinter)
+		staff.GET("/print-jobs", can(models.PermStoreView), printerHandler.GetPrintJobs)
+		staff.GET("/print-jobs/:id", can(models.PermStoreView), printerHandler.GetPrintJob)
+		staff.POST("/print-jobs/:id/retry", can(models.PermKitchenOperate), printerHandler.RetryPrintJob)
+		staff.DELETE("/print-jobs/:id", can(models.PermKitchenOperate), printerHandler.CancelPrintJob)
 
 		// 商品関連API
-		api.POST("/products/upload", productHandler.AddProductWithImage)
-		api.PATCH("/products/:id", productHandler.UpdateProduct)
-		api.DELETE("/products/:id", productHandler.DeleteProduct)
-		api.GET("/products/mine", productHandler.GetMyProducts)
+		staff.POST("/products/upload", can(models.PermMenuManage), productHandler.AddProductWithImage)
+		staff.PATCH("/products/:id", can(models.PermMenuManage), productHandler.UpdateProduct)
+		staff.DELETE("/products/:id", can(models.PermMenuManage), productHandler.DeleteProduct)
+		staff.GET("/products/mine", can(models.PermStoreView), productHandler.GetMyProducts)
+
+		// オプション（サイズ・トッピングなど）
+		staff.GET("/products/:id/option-groups", can(models.PermStoreView), optionHandler.GetOptionGroups)
+		staff.POST("/products/:id/option-groups", can(models.PermMenuManage), optionHandler.CreateOptionGroup)
+		staff.PATCH("/option-groups/:id", can(models.PermMenuManage), optionHandler.UpdateOptionGroup)
+		staff.DELETE("/option-groups/:id", can(models.PermMenuManage), optionHandler.DeleteOptionGroup)
+		staff.POST("/option-groups/:id/options", can(models.PermMenuManage), optionHandler.CreateOption)
+		staff.PATCH("/options/:id", can(models.PermMenuManage), optionHandler.UpdateOption)
+		staff.DELETE("/options/:id", can(models.PermMenuManage), optionHandler.DeleteOption)
+
+		// 在庫
+		staff.GET("/inventory", can(models.PermStoreView), inventoryHandler.GetInventory)
+		staff.PATCH("/products/:id/stock", can(models.PermInventoryManage), inventoryHandler.UpdateStock)
+		staff.GET("/stock-movements", can(models.PermInventoryManage), inventoryHandler.GetStockMovements)
+
+		// 食材在庫・レシピ
+		staff.GET("/ingredients", can(models.PermStoreView), ingredientHandler.GetIngredients)
+		staff.POST("/ingredients", can(models.PermInventoryManage), ingredientHandler.CreateIngredient)
+		staff.GET("/ingredients/low-stock", can(models.PermStoreView), ingredientHandler.GetLowStockReport)
+		staff.PATCH("/ingredients/:id", can(models.PermInventoryManage), ingredientHandler.UpdateIngredient)
+		staff.DELETE("/ingredients/:id", can(models.PermInventoryManage), ingredientHandler.DeleteIngredient)
+		staff.PATCH("/ingredients/:id/stock", can(models.PermInventoryManage), ingredientHandler.AdjustIngredientStock)
+		staff.GET("/ingredient-movements", can(models.PermInventoryManage), ingredientHandler.GetIngredientMovements)
+		staff.GET("/products/:id/recipe", can(models.PermStoreView), ingredientHandler.GetRecipe)
+		staff.PUT("/products/:id/recipe", can(models.PermMenuManage), ingredientHandler.SetRecipe)
+
+		// カテゴリー・メニュー
+		staff.GET("/categories", can(models.PermStoreView), categoryHandler.GetCategories)
+		staff.POST("/categories", can(models.PermMenuManage), categoryHandler.CreateCategory)
+		staff.PUT("/categories/order", can(models.PermMenuManage), categoryHandler.ReorderCategories)
+		staff.PATCH("/categories/:id", can(models.PermMenuManage), categoryHandler.UpdateCategory)
+		staff.DELETE("/categories/:id", can(models.PermMenuManage), categoryHandler.DeleteCategory)
+		staff.PUT("/categories/:id/products/order", can(models.PermMenuManage), categoryHandler.ReorderCategoryProducts)
 
 		// 注文関連API
-		api.POST("/orders", func(c *gin.Context) { handlers.CreateOrder(c, db) })
-		api.GET("/orders", func(c *gin.Context) { handlers.GetOrders(c, db) })
-		api.GET("/orders/:id", func(c *gin.Context) { handlers.GetOrderByID(c, db) })
-		api.PATCH("/orders/:id/status", func(c *gin.Context) { handlers.UpdateOrderStatus(c, db) })
-		api.DELETE("/orders/:id", func(c *gin.Context) { handlers.DeleteOrder(c, db) })
+		staff.POST("/orders", can(models.PermFloorOperate), func(c *gin.Context) { handlers.CreateOrder(c, db, spooler) })
+		staff.GET("/orders", can(models.PermStoreView), func(c *gin.Context) { handlers.GetOrders(c, db) })
+		staff.GET("/orders/stream", can(models.PermStoreView), handlers.StreamOrderEvents)
+		staff.GET("/orders/:id", can(models.PermStoreView), func(c *gin.Context) { handlers.GetOrderByID(c, db) })
+		staff.PATCH("/orders/:id/status", can(models.PermKitchenOperate), func(c *gin.Context) { handlers.UpdateOrderStatus(c, db) })
+		staff.GET("/orders/:id/timeline", can(models.PermStoreView), func(c *gin.Context) { handlers.GetOrderTimeline(c, db) })
+		staff.PATCH("/orders/:id/items/:item_id/status", can(models.PermKitchenOperate), func(c *gin.Context) { handlers.UpdateOrderItemStatus(c, db) })
+		staff.POST("/orders/:id/checkout", can(models.PermFloorOperate), paymentHandler.CheckoutOrder)
+		staff.POST("/orders/:id/refund", can(models.PermOrdersVoid), paymentHandler.RefundOrder)
+		staff.DELETE("/orders/:id", can(models.PermOrdersVoid), func(c *gin.Context) { handlers.DeleteOrder(c, db) })
 
-		// カート関連API
-		api.POST("/cart", func(c *gin.Context) { handlers.AddToCart(c, db) })
-		api.GET("/cart", func(c *gin.Context) { handlers.GetCart(c, db) })
-		api.PATCH("/cart/:id", func(c *gin.Context) { handlers.UpdateCartItem(c, db) })
-		api.DELETE("/cart/:id", func(c *gin.Context) { handlers.RemoveFromCart(c, db) })
-		api.POST("/cart/checkout", func(c *gin.Context) { handlers.CheckoutCart(c, db) })
-		api.DELETE("/cart/clear", func(c *gin.Context) { handlers.ClearCart(c, db) })
+		// キッチンディスプレイ（KDS）API
+		staff.GET("/kds/:station", can(models.PermStoreView), kdsHandler.GetStationItems)
+		staff.GET("/kds/:station/bumped", can(models.PermStoreView), kdsHandler.GetBumpedItems)
+		staff.POST("/kds/:station/items/:item_id/bump", can(models.PermKitchenOperate), kdsHandler.BumpItem)
+		staff.POST("/kds/:station/items/:item_id/recall", can(models.PermKitchenOperate), kdsHandler.RecallItem)
 	}
 
 	// HTMLページを直接レンダリング（Next.js以外からアクセスする場合）
@@ -140,3 +381,66 @@ func setupRouter() *gin.Engine {
 
 	return r
 }
+
+// secretOrRandom 署名鍵（開発環境で未設定の場合は起動ごとに生成する。本番環境では設定の検証で必須にしている）
+func secretOrRandom(name, secret string) []byte {
+	if secret == "" {
+		log.Printf("%s が未設定のため、一時的な署名鍵を使用します", name)
+		return qrtoken.RandomKey()
+	}
+	return []byte(secret)
+}
+
+// passwordPolicy 設定で調整したパスワードの条件
+func passwordPolicy() security.PasswordPolicy {
+	policy := security.DefaultPasswordPolicy()
+	policy.MinLength = cfg.Password.MinLength
+	policy.RequireSymbol = cfg.Password.RequireSymbol
+	return policy
+}
+
+// newMailer メールの送信方法（SMTPサーバーが未設定の場合は mail.dir に .eml ファイルとして保存し、それも未設定ならログに出力する）
+func newMailer() mail.Mailer {
+	if cfg.Mail.SMTPHost == "" {
+		return &mail.FileMailer{Dir: cfg.Mail.Dir, From: cfg.Mail.From}
+	}
+	return &mail.SMTPMailer{
+		Host:     cfg.Mail.SMTPHost,
+		Port:     cfg.Mail.SMTPPort,
+		Username: cfg.Mail.SMTPUsername,
+		Password: cfg.Mail.SMTPPassword,
+		From:     cfg.Mail.From,
+	}
+}
+
+// 鍵が設定されていない場合に生成した鍵を保存するファイル（開発環境用）
+const devSecretKeyFile = "secret.key"
+
+// secretKeyring 秘密情報を暗号化する鍵（設定の encryption.keys / SECRET_KEYS）
+// 鍵を変える場合は新しいバージョンを追加して起動すると、保存済みの値がすべて新しい鍵で暗号化し直される（古い鍵はその後削除できる）
+// 開発環境で未設定の場合は secret.key に生成した鍵をバージョン1として使う
+func secretKeyring() *security.Keyring {
+	spec := cfg.Encryption.Keys
+	if spec == "" {
+		key, err := os.ReadFile(devSecretKeyFile)
+		if os.IsNotExist(err) {
+			log.Printf("SECRET_KEYS が未設定のため、%s に生成した鍵を使用します", devSecretKeyFile)
+			generated, genErr := security.RandomKeyringKey()
+			if genErr != nil {
+				log.Fatalf("暗号化の鍵の生成失敗: %v", genErr)
+			}
+			key = []byte(generated)
+			err = os.WriteFile(devSecretKeyFile, key, 0o600)
+		}
+		if err != nil {
+			log.Fatalf("暗号化の鍵の読み込み失敗: %v", err)
+		}
+		spec = "1:" + strings.TrimSpace(string(key))
+	}
+
+	keyring, err := security.ParseKeyring(spec, cfg.Encryption.KeyVersion)
+	if err != nil {
+		log.Fatalf("暗号化の鍵の設定が不正です: %v", err)
+	}
+	return keyring
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"orderbase/config"
+	"orderbase/models"
+	"orderbase/security"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// routePermissions 権限が必要なAPIと、その権限（ルートを追加したらここにも追加する）
+var routePermissions = map[string]string{
+	"GET /api/dashboard/stats":           models.PermReportsView,
+	"GET /api/users":                     models.PermStaffManage,
+	"PATCH /api/store/security-settings": models.PermStoreSettings,
+
+	"GET /api/staff":            models.PermStaffManage,
+	"POST /api/staff":           models.PermStaffManage,
+	"PATCH /api/staff/:id/role": models.PermStaffManage,
+	"DELETE /api/staff/:id":     models.PermStaffManage,
+	"PUT /api/staff/:id/pin":    models.PermStaffManage,
+	"DELETE /api/staff/:id/2fa": models.PermStaffManage,
+	"GET /api/login-attempts":   models.PermSecurityAudit,
+
+	"GET /api/terminals":        models.PermTerminalsManage,
+	"POST /api/terminals":       models.PermTerminalsManage,
+	"DELETE /api/terminals/:id": models.PermTerminalsManage,
+
+	"GET /api/html/list":                      models.PermStoreView,
+	"PUT /api/html/save/:username/:page":      models.PermMenuManage,
+	"GET /api/html/get/:username/:page":       models.PermStoreView,
+	"DELETE /api/html/delete/:username/:page": models.PermMenuManage,
+
+	"PATCH /api/user/main-menu":        models.PermStoreSettings,
+	"PATCH /api/user/tax-settings":     models.PermStoreSettings,
+	"PATCH /api/user/receipt-settings": models.PermStoreSettings,
+
+	"POST /api/tables":                                         models.PermTablesManage,
+	"GET /api/tables":                                          models.PermStoreView,
+	"GET /api/tables/:id":                                      models.PermStoreView,
+	"PATCH /api/tables/:id":                                    models.PermTablesManage,
+	"DELETE /api/tables/:id":                                   models.PermTablesManage,
+	"GET /api/tables/:id/orders":                               models.PermStoreView,
+	"GET /api/tables/:id/sessions":                             models.PermStoreView,
+	"POST /api/tables/:id/sessions":                            models.PermFloorOperate,
+	"POST /api/tables/:id/sessions/close":                      models.PermFloorOperate,
+	"POST /api/tables/:id/token":                               models.PermFloorOperate,
+	"GET /api/tables/:id/token/qr.png":                         models.PermFloorOperate,
+	"DELETE /api/tables/:id/token":                             models.PermFloorOperate,
+	"GET /api/tables/:id/bill":                                 models.PermFloorOperate,
+	"POST /api/tables/:id/checkout":                            models.PermFloorOperate,
+	"POST /api/tables/:id/splits":                              models.PermFloorOperate,
+	"DELETE /api/tables/:id/splits/:split_id":                  models.PermFloorOperate,
+	"POST /api/tables/:id/splits/:split_id/parts/:part_id/pay": models.PermFloorOperate,
+
+	"POST /api/receipts":             models.PermFloorOperate,
+	"GET /api/receipts/:id":          models.PermFloorOperate,
+	"POST /api/receipts/:id/reprint": models.PermFloorOperate,
+
+	"GET /api/printers":              models.PermStoreView,
+	"POST /api/printers":             models.PermPrintersManage,
+	"PATCH /api/printers/:id":        models.PermPrintersManage,
+	"DELETE /api/printers/:id":       models.PermPrintersManage,
+	"POST /api/printers/:id/test":    models.PermPrintersManage,
+	"GET /api/print-jobs":            models.PermStoreView,
+	"GET /api/print-jobs/:id":        models.PermStoreView,
+	"POST /api/print-jobs/:id/retry": models.PermKitchenOperate,
+	"DELETE /api/print-jobs/:id":     models.PermKitchenOperate,
+
+	"POST /api/products/upload": models.PermMenuManage,
+	"PATCH /api/products/:id":   models.PermMenuManage,
+	"DELETE /api/products/:id":  models.PermMenuManage,
+	"GET /api/products/mine":    models.PermStoreView,
+
+	"GET /api/products/:id/option-groups":  models.PermStoreView,
+	"POST /api/products/:id/option-groups": models.PermMenuManage,
+	"PATCH /api/option-groups/:id":         models.PermMenuManage,
+	"DELETE /api/option-groups/:id":        models.PermMenuManage,
+	"POST /api/option-groups/:id/options":  models.PermMenuManage,
+	"PATCH /api/options/:id":               models.PermMenuManage,
+	"DELETE /api/options/:id":              models.PermMenuManage,
+
+	"GET /api/inventory":            models.PermStoreView,
+	"PATCH /api/products/:id/stock": models.PermInventoryManage,
+	"GET /api/stock-movements":      models.PermInventoryManage,
+
+	"GET /api/ingredients":             models.PermStoreView,
+	"POST /api/ingredients":            models.PermInventoryManage,
+	"GET /api/ingredients/low-stock":   models.PermStoreView,
+	"PATCH /api/ingredients/:id":       models.PermInventoryManage,
+	"DELETE /api/ingredients/:id":      models.PermInventoryManage,
+	"PATCH /api/ingredients/:id/stock": models.PermInventoryManage,
+	"GET /api/ingredient-movements":    models.PermInventoryManage,
+	"GET /api/products/:id/recipe":     models.PermStoreView,
+	"PUT /api/products/:id/recipe":     models.PermMenuManage,
+
+	"GET /api/categories":                    models.PermStoreView,
+	"POST /api/categories":                   models.PermMenuManage,
+	"PUT /api/categories/order":              models.PermMenuManage,
+	"PATCH /api/categories/:id":              models.PermMenuManage,
+	"DELETE /api/categories/:id":             models.PermMenuManage,
+	"PUT /api/categories/:id/products/order": models.PermMenuManage,
+
+	"POST /api/orders":                            models.PermFloorOperate,
+	"GET /api/orders":                             models.PermStoreView,
+	"GET /api/orders/stream":                      models.PermStoreView,
+	"GET /api/orders/:id":                         models.PermStoreView,
+	"PATCH /api/orders/:id/status":                models.PermKitchenOperate,
+	"GET /api/orders/:id/timeline":                models.PermStoreView,
+	"PATCH /api/orders/:id/items/:item_id/status": models.PermKitchenOperate,
+	"POST /api/orders/:id/checkout":               models.PermFloorOperate,
+	"POST /api/orders/:id/refund":                 models.PermOrdersVoid,
+	"DELETE /api/orders/:id":                      models.PermOrdersVoid,
+
+	"GET /api/kds/:station":                        models.PermStoreView,
+	"GET /api/kds/:station/bumped":                 models.PermStoreView,
+	"POST /api/kds/:station/items/:item_id/bump":   models.PermKitchenOperate,
+	"POST /api/kds/:station/items/:item_id/recall": models.PermKitchenOperate,
+}
+
+// routesWithoutPermission 役割による制限がないAPI（公開・お客様向け・自分のアカウントの設定）
+var routesWithoutPermission = map[string]bool{
+	"POST /api/register":           true,
+	"POST /api/login":              true,
+	"POST /api/login/2fa":          true,
+	"GET /api/logout":              true,
+	"GET /api/password-policy":     true,
+	"POST /api/password/forgot":    true,
+	"POST /api/password/reset":     true,
+	"POST /api/email/verify":       true,
+	"GET /api/ping":                true,
+	"GET /api/menu/:username":      true,
+	"GET /api/terminal/staff":      true,
+	"POST /api/terminal/pin-login": true,
+	"POST /api/terminal/lock":      true,
+
+	"POST /api/cart":          true,
+	"GET /api/cart":           true,
+	"PATCH /api/cart/:id":     true,
+	"DELETE /api/cart/:id":    true,
+	"POST /api/cart/table":    true,
+	"POST /api/cart/checkout": true,
+	"DELETE /api/cart/clear":  true,
+
+	"GET /api/2fa":                 true,
+	"POST /api/2fa/setup":          true,
+	"POST /api/2fa/enable":         true,
+	"POST /api/2fa/disable":        true,
+	"POST /api/2fa/recovery-codes": true,
+
+	"GET /api/dashboard":                true,
+	"GET /api/store":                    true,
+	"PUT /api/user/pin":                 true,
+	"PUT /api/user/email":               true,
+	"POST /api/user/email/verification": true,
+	"GET /api/api-tokens":               true, // 連携サービス用トークンの発行はハンドラーで権限を確認する
+	"POST /api/api-tokens":              true,
+	"DELETE /api/api-tokens/:id":        true,
+	"POST /api/openai/chat":             true,
+	"POST /api/openai/set-key":          true,
+	"GET /api/openai/get-key":           true,
+	"GET /api/user/main-menu":           true,
+	"GET /api/user/tax-settings":        true,
+	"GET /api/user/receipt-settings":    true,
+}
+
+// RequirePermission が権限のない役割に返すエラー
+const permissionDeniedError = "この操作を行う権限がありません"
+
+// setupTestServer 一時DBでルーターを起動
+func setupTestServer(t *testing.T) (*gin.Engine, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	key, err := security.RandomKeyringKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConfig := config.Defaults(config.EnvDevelopment)
+	testConfig.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	testConfig.Storage.UploadDir = t.TempDir()
+	testConfig.Storage.StaticDir = t.TempDir()
+	testConfig.Encryption.Keys = "1:" + key
+	cfg = &testConfig
+
+	secrets = secretKeyring()
+	initDB()
+	r := setupRouter()
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+// loginAs 店舗に指定した役割のユーザーを作成し、ログインしたクライアントを返す
+func loginAs(t *testing.T, server *httptest.Server, storeID uint, role string) *http.Client {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password-"+role), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Username: role, Password: string(hash), StoreID: &storeID, Role: role}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar, Timeout: 10 * time.Second}
+	body, _ := json.Marshal(gin.H{"username": role, "password": "password-" + role})
+	res, err := client.Post(server.URL+"/api/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s: login status = %d", role, res.StatusCode)
+	}
+	return client
+}
+
+// routeParam ルートのパラメーター（存在しないIDにして、許可された場合もデータを変更しないようにする）
+var routeParam = regexp.MustCompile(`:[a-z_]+`)
+
+func TestRoutesDeclarePermissions(t *testing.T) {
+	r, _ := setupTestServer(t)
+
+	registered := make(map[string]bool)
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		_, guarded := routePermissions[key]
+		if guarded == routesWithoutPermission[key] {
+			t.Errorf("%s: routePermissions と routesWithoutPermission のどちらか一方に登録してください", key)
+		}
+	}
+	for key := range routePermissions {
+		if !registered[key] {
+			t.Errorf("%s: ルートが登録されていません", key)
+		}
+	}
+	for key := range routesWithoutPermission {
+		if !registered[key] {
+			t.Errorf("%s: ルートが登録されていません", key)
+		}
+	}
+}
+
+func TestRoutesRejectRolesWithoutPermission(t *testing.T) {
+	_, server := setupTestServer(t)
+
+	store := models.Store{Name: "テスト店舗"}
+	if err := db.Create(&store).Error; err != nil {
+		t.Fatal(err)
+	}
+	clients := make(map[string]*http.Client)
+	for _, role := range models.Roles {
+		clients[role] = loginAs(t, server, store.ID, role)
+	}
+
+	for key, permission := range routePermissions {
+		method, path, _ := strings.Cut(key, " ")
+		url := server.URL + routeParam.ReplaceAllString(path, "999999")
+
+		for _, role := range models.Roles {
+			allowed := models.RoleHasPermission(role, permission)
+			t.Run(role+" "+key, func(t *testing.T) {
+				req, err := http.NewRequest(method, url, strings.NewReader("{}"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Content-Type", "application/json")
+				res, err := clients[role].Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// 配信（SSE）のレスポンスは読み切らずに閉じる
+				defer res.Body.Close()
+
+				denied := false
+				if res.StatusCode == http.StatusForbidden {
+					var body struct {
+						Error string `json:"error"`
+					}
+					json.NewDecoder(res.Body).Decode(&body)
+					denied = body.Error == permissionDeniedError
+				}
+				switch {
+				case res.StatusCode == http.StatusUnauthorized:
+					t.Fatalf("status = 401, want the session to be accepted")
+				case allowed && denied:
+					t.Errorf("status = 403, want %s to be allowed (%s)", role, permission)
+				case !allowed && !denied:
+					t.Errorf("status = %d, want 403 for %s without %s", res.StatusCode, role, permission)
+				}
+			})
+		}
+	}
+}
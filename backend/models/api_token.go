@@ -0,0 +1,58 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIトークンの種類
+const (
+	APITokenPersonal = "personal" // 個人用（発行したユーザー本人が管理する）
+	APITokenService  = "service"  // 連携サービス用（キオスクアプリ・会計スクリプトなど。店舗の管理者が管理する）
+)
+
+// APIToken Cookieのセッションを使えないクライアント用のトークン（Authorization: Bearer で送る）
+// トークンは発行したユーザーとして動作し、操作はユーザーの役割とスコープの両方で許可されたものに限られる
+type APIToken struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	StoreID    uint       `gorm:"index;not null" json:"store_id"`
+	UserID     uint       `gorm:"index;not null" json:"user_id"` // 発行したユーザー（削除されるとトークンも使えなくなる）
+	Kind       string     `gorm:"default:'personal'" json:"kind"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`                        // 一覧で見分けるためのトークンの先頭部分
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"` // トークンのSHA-256（トークン自体は保存しない）
+	Scopes     string     `json:"scopes"`                        // カンマ区切りの許可する操作（例: "store.view,floor.operate"）
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`          // 有効期限（null の場合は無期限）
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// IsValidAPITokenKind 有効なトークンの種類か
+func IsValidAPITokenKind(kind string) bool {
+	return kind == APITokenPersonal || kind == APITokenService
+}
+
+// ScopeList 許可する操作の一覧
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope 操作がスコープに含まれているか
+func (t *APIToken) HasScope(permission string) bool {
+	for _, scope := range t.ScopeList() {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive 解除されておらず有効期限内か
+func (t *APIToken) IsActive(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// BillSplit テーブルの請求の分割（割り勘・個別会計）
+type BillSplit struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	TableID        uint      `gorm:"index;not null" json:"table_id"`
+	TableSessionID uint      `gorm:"index;not null" json:"table_session_id"`
+	Mode           string    `json:"mode"`   // even, items, amounts
+	Total          int       `json:"total"`  // 分割時点の請求額
+	Status         string    `json:"status"` // open, paid, cancelled
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+
+	// リレーション
+	Parts []BillSplitPart `gorm:"foreignKey:BillSplitID" json:"parts"`
+}
+
+// BillSplitPart 分割後の個別の請求
+type BillSplitPart struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	BillSplitID uint      `gorm:"index;not null" json:"bill_split_id"`
+	Label       string    `json:"label"` // 「1人目」「席A」など
+	Amount      int       `json:"amount"`
+	Status      string    `json:"status"` // unpaid, paid
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// リレーション
+	Items []BillSplitItem `gorm:"foreignKey:BillSplitPartID" json:"items,omitempty"` // 品目ごとの分割の場合のみ
+}
+
+// BillSplitItem 品目ごとの分割で個別の請求に割り当てた注文明細
+type BillSplitItem struct {
+	ID              uint `gorm:"primaryKey" json:"id"`
+	BillSplitPartID uint `gorm:"index;not null" json:"bill_split_part_id"`
+	OrderItemID     uint `gorm:"index;not null" json:"order_item_id"`
+	Amount          int  `json:"amount"`
+}
+
+// 分割方法
+const (
+	BillSplitEven    = "even"
+	BillSplitItems   = "items"
+	BillSplitAmounts = "amounts"
+)
+
+// 分割のステータス
+const (
+	BillSplitOpen      = "open"
+	BillSplitPaid      = "paid"
+	BillSplitCancelled = "cancelled"
+)
+
+// 個別の請求のステータス
+const (
+	BillSplitPartUnpaid = "unpaid"
+	BillSplitPartPaid   = "paid"
+)
@@ -8,11 +8,27 @@ import (
 type CartItem struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	SessionID string    `gorm:"index" json:"session_id"` // セッションID（ログイン不要）
+	StoreID   uint      `gorm:"index" json:"store_id"`   // 商品の店舗（1つのカートには同じ店舗の商品のみ入れられる）
 	ProductID uint      `json:"product_id"`
 	Quantity  int       `json:"quantity"`
+	OptionKey string    `gorm:"index" json:"-"` // 選択オプションIDを昇順にカンマ区切りにしたもの（同じ選択の行をまとめる）
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 
 	// リレーション
-	Product Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Product Product          `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Options []CartItemOption `gorm:"foreignKey:CartItemID" json:"options"`
+
+	// オプション込みの単価と小計（レスポンス用）
+	UnitPrice int `gorm:"-" json:"unit_price"`
+	LineTotal int `gorm:"-" json:"line_total"`
+}
+
+// OptionIDs 選択されたオプションのID
+func (c CartItem) OptionIDs() []uint {
+	ids := make([]uint, 0, len(c.Options))
+	for _, o := range c.Options {
+		ids = append(ids, o.OptionID)
+	}
+	return ids
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Category 商品カテゴリー（例: ドリンク / メイン / デザート）
+// ParentID を指定するとサブカテゴリーになる
+type Category struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name"`
+	ParentID  *uint     `gorm:"index" json:"parentId"`       // 親カテゴリー（トップレベルの場合は null）
+	SortOrder int       `json:"sortOrder"`                   // 同じ親を持つカテゴリー内での表示順（昇順）
+	Visible   bool      `gorm:"default:true" json:"visible"` // false の場合は公開メニューに表示しない（サブカテゴリーも含む）
+	StoreID   uint      `gorm:"index" json:"storeId"`
+}
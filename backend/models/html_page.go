@@ -4,7 +4,8 @@ import "time"
 
 type HTMLPage struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
-	Name      string    `gorm:"uniqueIndex;not null" json:"name"`
+	StoreID   uint      `gorm:"uniqueIndex:idx_html_pages_store_name" json:"store_id"`
+	Name      string    `gorm:"uniqueIndex:idx_html_pages_store_name;not null" json:"name"` // 店舗内で一意
 	Content   string    `gorm:"type:text;not null" json:"content"`
 	UserID    uint      `gorm:"not null" json:"user_id"`
 	User      User      `gorm:"foreignKey:UserID" json:"user"`
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Ingredient 食材・資材の在庫
+type Ingredient struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	StoreID      uint      `gorm:"index" json:"store_id"`
+	Name         string    `json:"name"`
+	Unit         string    `json:"unit"`          // 単位（g, ml, 個 など）
+	OnHand       float64   `json:"on_hand"`       // 在庫量
+	ReorderLevel float64   `json:"reorder_level"` // 発注点（在庫量がこれ以下になったら発注対象）
+}
+
+// NeedsReorder 発注点を下回っているか
+func (i Ingredient) NeedsReorder() bool {
+	return i.OnHand <= i.ReorderLevel
+}
+
+// RecipeItem 商品1つあたりに使う食材の量（レシピ・部品表）
+type RecipeItem struct {
+	ID           uint    `gorm:"primaryKey" json:"id"`
+	ProductID    uint    `gorm:"index;not null" json:"product_id"`
+	IngredientID uint    `gorm:"index;not null" json:"ingredient_id"`
+	Quantity     float64 `json:"quantity"`
+
+	Ingredient Ingredient `gorm:"foreignKey:IngredientID" json:"ingredient"`
+}
+
+// IngredientMovement 食材の在庫の増減履歴（監査用の台帳）
+type IngredientMovement struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	IngredientID  uint      `gorm:"index;not null" json:"ingredient_id"`
+	Delta         float64   `json:"delta"`         // 増減量（使用は負数）
+	OnHandAfter   float64   `json:"on_hand_after"` // 増減後の在庫量
+	Reason        string    `json:"reason"`        // sale（注文で使用）, cancel（取り消しによる戻し）, adjust（手動調整）
+	OrderID       *uint     `gorm:"index" json:"order_id,omitempty"`
+	OrderItemID   *uint     `gorm:"index" json:"order_item_id,omitempty"`
+	Note          string    `json:"note,omitempty"`
+	ChangedBy     *uint     `json:"changed_by,omitempty"`
+	ChangedByName string    `json:"changed_by_name,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
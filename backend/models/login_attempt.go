@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ログインの方法
+const (
+	LoginMethodPassword = "password" // ユーザー名とパスワード
+	LoginMethodPIN      = "pin"      // 共有端末でのPIN
+	LoginMethodTOTP     = "totp"     // 二段階認証の確認コード（パスワードの後）
+	LoginMethodRecovery = "recovery" // 二段階認証のリカバリーコード（パスワードの後）
+)
+
+// ログインに失敗した理由
+const (
+	LoginFailUnknownUser       = "unknown_user"        // ユーザーが存在しない
+	LoginFailWrongPassword     = "wrong_password"      // パスワード・PINが違う
+	LoginFailWrongCode         = "wrong_code"          // 二段階認証の確認コード・リカバリーコードが違う
+	LoginFailLocked            = "locked"              // アカウントが一時ロック中
+	LoginFailThrottled         = "throttled"           // 試行が多すぎるため待ち時間中
+	LoginFailTwoFactorRequired = "two_factor_required" // 二段階認証の対象のためPINでのログインを拒否
+)
+
+// LoginAttempt ログインの試行履歴（オーナーが不審なログインを確認するため）
+type LoginAttempt struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	StoreID   *uint     `gorm:"index" json:"store_id,omitempty"` // ユーザーが存在しない場合は null
+	UserID    *uint     `gorm:"index" json:"user_id,omitempty"`
+	Username  string    `gorm:"index" json:"username"` // 入力されたユーザー名
+	Method    string    `json:"method"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"` // 失敗した理由
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
@@ -0,0 +1,258 @@
+package models
+
+import (
+	"fmt"
+	"orderbase/security"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MigrateLegacyOrders 旧形式（1注文 = 1商品）の注文行を明細行形式に移行
+// ordersテーブルに product_id / quantity 列が残っている場合のみ実行される
+func MigrateLegacyOrders(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&Order{}, "product_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var legacyOrders []struct {
+			ID         uint
+			ProductID  uint
+			Quantity   int
+			TotalPrice int
+			Status     string
+			CreatedAt  time.Time
+			UpdatedAt  time.Time
+		}
+		if err := tx.Table("orders").
+			Select("id, product_id, quantity, total_price, status, created_at, updated_at").
+			Where("product_id IS NOT NULL AND product_id <> 0").
+			Scan(&legacyOrders).Error; err != nil {
+			return err
+		}
+
+		for _, legacy := range legacyOrders {
+			// 既に明細行がある注文はスキップ
+			var count int64
+			if err := tx.Model(&OrderItem{}).Where("order_id = ?", legacy.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+
+			quantity := legacy.Quantity
+			if quantity <= 0 {
+				quantity = 1
+			}
+
+			item := OrderItem{
+				OrderID:   legacy.ID,
+				ProductID: legacy.ProductID,
+				Quantity:  quantity,
+				UnitPrice: legacy.TotalPrice / quantity,
+				LineTotal: legacy.TotalPrice,
+				Status:    legacy.Status,
+				CreatedAt: legacy.CreatedAt,
+				UpdatedAt: legacy.UpdatedAt,
+			}
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+
+		// 旧カラムを削除
+		if err := tx.Migrator().DropColumn(&Order{}, "product_id"); err != nil {
+			return err
+		}
+		if tx.Migrator().HasColumn(&Order{}, "quantity") {
+			if err := tx.Migrator().DropColumn(&Order{}, "quantity"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateOrderStatuses 旧ステータス（pending/completed）を新しいライフサイクルに移行
+func MigrateOrderStatuses(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for legacy, status := range legacyOrderStatuses {
+			if err := tx.Model(&Order{}).Where("status = ?", legacy).Update("status", status).Error; err != nil {
+				return err
+			}
+		}
+
+		// 明細行は served までなので、完了済みは served とする
+		if err := tx.Model(&OrderItem{}).Where("status = ?", "pending").Update("status", OrderStatusReceived).Error; err != nil {
+			return err
+		}
+		return tx.Model(&OrderItem{}).Where("status = ?", "completed").Update("status", OrderStatusServed).Error
+	})
+}
+
+// MigrateOrderItemStations 調理場所が未設定の明細行に商品の調理場所を設定
+func MigrateOrderItemStations(db *gorm.DB) error {
+	return db.Model(&OrderItem{}).
+		Where("station IS NULL OR station = ''").
+		Update("station", gorm.Expr("COALESCE((SELECT station FROM products WHERE products.id = order_items.product_id), ?)", StationKitchen)).
+		Error
+}
+
+// MigrateOrderTaxes 税額が記録されていない既存の注文を税込価格・標準税率（10%）として補完
+// 記録済みの支払総額は変更しない
+func MigrateOrderTaxes(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OrderItem{}).
+			Where("tax_rate IS NULL OR tax_rate = 0").
+			Update("tax_rate", 10).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Order{}).
+			Where("COALESCE(subtotal, 0) = 0 AND COALESCE(tax_amount, 0) = 0 AND total_price > 0").
+			Updates(map[string]interface{}{
+				"price_includes_tax": true,
+				"tax_amount":         gorm.Expr("total_price * 10 / 110"),
+				"subtotal":           gorm.Expr("total_price - total_price * 10 / 110"),
+			}).Error
+	})
+}
+
+// 店舗に属するデータのテーブル（店舗導入前のデータは既定の店舗に移行する）
+var storeOwnedTables = []string{
+	"products", "categories", "ingredients", "tables", "html_pages",
+	"orders", "cart_items", "printers", "print_jobs", "receipts",
+}
+
+// 店舗導入前の全体で一意だったインデックス（店舗ごとの一意制約に置き換える）
+var legacyGlobalIndexes = []struct {
+	Model interface{}
+	Name  string
+}{
+	{&Table{}, "idx_tables_table_number"},
+	{&HTMLPage{}, "idx_html_pages_name"},
+	{&Receipt{}, "idx_receipts_number"},
+}
+
+// MigrateStores 店舗に所属していないユーザーとデータを既定の店舗に移行
+// 既定の店舗の設定（店舗名・税込設定・登録番号・メインメニュー）は最初に登録されたユーザーの設定を引き継ぐ
+func MigrateStores(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, index := range legacyGlobalIndexes {
+			if tx.Migrator().HasIndex(index.Model, index.Name) {
+				if err := tx.Migrator().DropIndex(index.Model, index.Name); err != nil {
+					return err
+				}
+			}
+		}
+
+		var unassigned int64
+		if err := tx.Model(&User{}).Where("store_id IS NULL OR store_id = 0").Count(&unassigned).Error; err != nil {
+			return err
+		}
+		if unassigned == 0 {
+			return nil
+		}
+
+		// 旧形式ではユーザーごとに保存していた店舗の設定
+		var legacy struct {
+			ID               uint
+			StoreName        string
+			PriceIncludesTax *bool
+			InvoiceNumber    string
+			MainMenuPage     string
+		}
+		columns := []string{"id"}
+		for _, column := range []string{"store_name", "price_includes_tax", "invoice_number", "main_menu_page"} {
+			if tx.Migrator().HasColumn(&User{}, column) {
+				columns = append(columns, column)
+			}
+		}
+		if err := tx.Table("users").Select(columns).Where("deleted_at IS NULL").Order("id ASC").Limit(1).Scan(&legacy).Error; err != nil {
+			return err
+		}
+
+		store := Store{
+			Name:          legacy.StoreName,
+			InvoiceNumber: legacy.InvoiceNumber,
+			MainMenuPage:  legacy.MainMenuPage,
+		}
+		if store.Name == "" {
+			store.Name = DefaultStoreName
+		}
+		if legacy.ID != 0 {
+			store.OwnerID = &legacy.ID
+		}
+		if err := tx.Create(&store).Error; err != nil {
+			return err
+		}
+		// price_includes_tax は default:true のため、税抜の場合は明示的に更新する
+		if legacy.PriceIncludesTax != nil && !*legacy.PriceIncludesTax {
+			if err := tx.Model(&store).Update("price_includes_tax", false).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&User{}).Unscoped().
+			Where("store_id IS NULL OR store_id = 0").
+			Update("store_id", store.ID).Error; err != nil {
+			return err
+		}
+		for _, table := range storeOwnedTables {
+			if err := tx.Table(table).
+				Where("store_id IS NULL OR store_id = 0").
+				Update("store_id", store.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateUserRoles 役割が未設定のユーザーに役割を割り当てる
+// 店舗のオーナーは owner、それ以外（店舗導入前に登録したユーザー）は manager とする
+func MigrateUserRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		owners := tx.Model(&Store{}).Select("owner_id").Where("owner_id IS NOT NULL")
+		if err := tx.Model(&User{}).Unscoped().
+			Where("(role IS NULL OR role = '') AND id IN (?)", owners).
+			Update("role", RoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Unscoped().
+			Where("role IS NULL OR role = ''").
+			Update("role", RoleManager).Error
+	})
+}
+
+// MigrateSecrets 平文のまま、または古い鍵で暗号化されている秘密情報（OpenAI APIキー）を現在の鍵で暗号化し直す
+// 鍵を追加して現在のバージョンを変えた後の起動時に、保存済みの値をすべて新しい鍵に移す。暗号化し直した件数を返す
+func MigrateSecrets(db *gorm.DB, keyring *security.Keyring) (int, error) {
+	var users []User
+	if err := db.Unscoped().Select("id", "open_ai_key").Where("open_ai_key <> ''").Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, user := range users {
+			if !keyring.NeedsReencrypt(user.OpenAIKey) {
+				continue
+			}
+			encrypted, err := keyring.Reencrypt(user.OpenAIKey, OpenAIKeyContext(user.ID))
+			if err != nil {
+				return fmt.Errorf("ユーザー %d のAPIキー: %w", user.ID, err)
+			}
+			if err := tx.Model(&User{}).Unscoped().Where("id = ?", user.ID).UpdateColumn("open_ai_key", encrypted).Error; err != nil {
+				return err
+			}
+			migrated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return migrated, nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// OptionGroup 商品のオプショングループ（例: サイズ、トッピング、辛さ）
+type OptionGroup struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ProductID uint      `gorm:"index;not null" json:"productId"`
+	Name      string    `json:"name"`
+	Required  bool      `json:"required"`  // true の場合は最低1つ選択が必要
+	MinSelect int       `json:"minSelect"` // 最低選択数
+	MaxSelect int       `json:"maxSelect"` // 最大選択数（0 は無制限）
+	SortOrder int       `json:"sortOrder"`
+
+	Options []Option `gorm:"foreignKey:GroupID" json:"options"`
+}
+
+// MinSelections 必須指定を考慮した最低選択数
+func (g OptionGroup) MinSelections() int {
+	if g.Required && g.MinSelect < 1 {
+		return 1
+	}
+	return g.MinSelect
+}
+
+// Option オプションの選択肢（例: 大盛り +100円）
+type Option struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	GroupID    uint      `gorm:"index;not null" json:"groupId"`
+	Name       string    `json:"name"`
+	PriceDelta int       `json:"priceDelta"`                    // 商品価格への加算額（値引きの場合は負数）
+	Available  bool      `gorm:"default:true" json:"available"` // false の場合は品切れとして選択できない
+	SortOrder  int       `json:"sortOrder"`
+}
+
+// CartItemOption カート内商品で選択されたオプション
+type CartItemOption struct {
+	ID         uint `gorm:"primaryKey" json:"id"`
+	CartItemID uint `gorm:"index;not null" json:"cart_item_id"`
+	OptionID   uint `json:"option_id"`
+
+	Option Option `gorm:"foreignKey:OptionID" json:"option"`
+}
+
+// OrderItemOption 注文明細で選択されたオプション（注文時点の名前と加算額のスナップショット）
+type OrderItemOption struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	OrderItemID uint   `gorm:"index;not null" json:"order_item_id"`
+	OptionID    uint   `json:"option_id"`
+	GroupName   string `json:"group_name"`
+	Name        string `json:"name"`
+	PriceDelta  int    `json:"price_delta"`
+}
@@ -1,23 +1,51 @@
 package models
 
 import (
+	"orderbase/billing"
 	"time"
 )
 
-// Order 注文情報
+// Order 注文情報（1回の注文 = 1ヘッダー + 複数の明細行）
 type Order struct {
-	ID         uint      `gorm:"primaryKey" json:"id"`
-	UserID     *uint     `json:"user_id,omitempty"`  // ゲスト購入のためオプショナル
-	ProductID  uint      `json:"product_id"`
-	Quantity   int       `json:"quantity"`
-	TotalPrice int       `json:"total_price"`
-	Status     string    `json:"status"`             // pending, completed, cancelled
-	TableID    *uint     `json:"table_id,omitempty"` // テーブル参照（オプショナル）
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	StoreID          uint      `gorm:"index" json:"store_id"`
+	UserID           *uint     `json:"user_id,omitempty"`                       // ゲスト購入のためオプショナル
+	TotalPrice       int       `json:"total_price"`                             // 支払総額（税込）
+	Subtotal         int       `json:"subtotal"`                                // 税抜金額
+	TaxAmount        int       `json:"tax_amount"`                              // 消費税額
+	DiningOption     string    `gorm:"default:'eat_in'" json:"dining_option"`   // eat_in（店内飲食）, takeout（持ち帰り）
+	PriceIncludesTax bool      `json:"price_includes_tax"`                      // 明細の金額が税込か（注文時点の設定）
+	Status           string    `json:"status"`                                  // received, preparing, ready, served, paid, cancelled, refunded
+	TableID          *uint     `json:"table_id,omitempty"`                      // テーブル参照（オプショナル）
+	TableSessionID   *uint     `gorm:"index" json:"table_session_id,omitempty"` // 来店（着席）単位の参照
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 
 	// リレーション
-	User    *User   `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	Product Product `gorm:"foreignKey:ProductID" json:"product,omitempty"`
-	Table   *Table  `gorm:"foreignKey:TableID" json:"table,omitempty"` // テーブル情報
+	User         *User         `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Table        *Table        `gorm:"foreignKey:TableID" json:"table,omitempty"` // テーブル情報
+	TableSession *TableSession `gorm:"foreignKey:TableSessionID" json:"table_session,omitempty"`
+	Items        []OrderItem   `gorm:"foreignKey:OrderID" json:"items"`
+
+	// 税率ごとの内訳（レスポンス用）
+	Tax *billing.TaxSummary `gorm:"-" json:"tax,omitempty"`
+}
+
+// OrderItem 注文明細行
+type OrderItem struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	OrderID   uint      `gorm:"index;not null" json:"order_id"`
+	ProductID uint      `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	UnitPrice int       `json:"unit_price"`           // 注文時点の単価（商品価格とオプション加算額のスナップショット）
+	LineTotal int       `json:"line_total"`           // 単価 × 数量（税込・税抜は注文の設定に従う）
+	TaxRate   int       `json:"tax_rate"`             // 注文時点の税率（%）
+	Status    string    `json:"status"`               // received, preparing, ready, served, cancelled
+	Station   string    `gorm:"index" json:"station"` // 注文時点の調理場所
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// リレーション
+	Product Product           `gorm:"foreignKey:ProductID" json:"product,omitempty"`
+	Options []OrderItemOption `gorm:"foreignKey:OrderItemID" json:"options"`
 }
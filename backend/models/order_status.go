@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// 注文ステータス
+// received → preparing → ready → served → paid の順に進み、
+// 支払い前は cancelled、支払い後は refunded に遷移できる
+const (
+	OrderStatusReceived  = "received"
+	OrderStatusPreparing = "preparing"
+	OrderStatusReady     = "ready"
+	OrderStatusServed    = "served"
+	OrderStatusPaid      = "paid"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
+// orderStatusFlow 通常の進行順
+var orderStatusFlow = []string{
+	OrderStatusReceived,
+	OrderStatusPreparing,
+	OrderStatusReady,
+	OrderStatusServed,
+	OrderStatusPaid,
+}
+
+// legacyOrderStatuses 旧ステータスから新ステータスへの対応
+var legacyOrderStatuses = map[string]string{
+	"pending":   OrderStatusReceived,
+	"completed": OrderStatusPaid,
+}
+
+// OpenOrderStatuses 未会計（進行中）のステータス
+var OpenOrderStatuses = []string{
+	OrderStatusReceived,
+	OrderStatusPreparing,
+	OrderStatusReady,
+	OrderStatusServed,
+}
+
+// OrderStatusStage 進行順での位置を返す（cancelled/refundedなどは-1）
+func OrderStatusStage(status string) int {
+	for i, s := range orderStatusFlow {
+		if s == status {
+			return i
+		}
+	}
+	return -1
+}
+
+// NormalizeOrderStatus 旧ステータス名を新ステータス名に変換
+func NormalizeOrderStatus(status string) string {
+	if s, ok := legacyOrderStatuses[status]; ok {
+		return s
+	}
+	return status
+}
+
+// IsValidOrderStatus 既知の注文ステータスか判定
+func IsValidOrderStatus(status string) bool {
+	return OrderStatusStage(status) >= 0 || status == OrderStatusCancelled || status == OrderStatusRefunded
+}
+
+// CanTransitionOrderStatus 注文ステータスの遷移が許可されているか判定
+func CanTransitionOrderStatus(from, to string) bool {
+	switch to {
+	case OrderStatusCancelled:
+		// 会計前ならいつでも取り消し可能
+		return OrderStatusStage(from) >= 0 && from != OrderStatusPaid
+	case OrderStatusRefunded:
+		// 返金は会計済みの注文のみ
+		return from == OrderStatusPaid
+	}
+
+	// キッチンでの呼び戻し（ready → preparing）のみ逆戻りを許可
+	if from == OrderStatusReady && to == OrderStatusPreparing {
+		return true
+	}
+
+	fromStage := OrderStatusStage(from)
+	toStage := OrderStatusStage(to)
+	if fromStage < 0 || toStage < 0 {
+		return false
+	}
+	// 進行方向のみ（工程の飛ばしは可）
+	return toStage > fromStage
+}
+
+// CanTransitionOrderItemStatus 明細行ステータスの遷移が許可されているか判定
+// 明細行は served までで、会計・返金は注文単位で扱う
+func CanTransitionOrderItemStatus(from, to string) bool {
+	if to == OrderStatusPaid || to == OrderStatusRefunded || from == OrderStatusPaid {
+		return false
+	}
+	if to == OrderStatusCancelled {
+		return from != OrderStatusServed && from != OrderStatusCancelled
+	}
+	return CanTransitionOrderStatus(from, to)
+}
+
+// OrderStatusEvent 注文ステータスの変更履歴
+type OrderStatusEvent struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	OrderID       uint      `gorm:"index;not null" json:"order_id"`
+	OrderItemID   *uint     `json:"order_item_id,omitempty"` // 明細行単位の変更の場合のみ
+	FromStatus    string    `json:"from_status"`             // 作成時は空文字
+	ToStatus      string    `json:"to_status"`
+	ChangedBy     *uint     `json:"changed_by,omitempty"` // 変更したユーザー（ゲスト・自動遷移はnil）
+	ChangedByName string    `json:"changed_by_name"`
+	TerminalID    *uint     `json:"terminal_id,omitempty"` // PINでログインした共有端末で変更した場合
+	Note          string    `json:"note"`
+	CreatedAt     time.Time `json:"created_at"`
+}
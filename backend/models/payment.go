@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Payment 会計時の支払い記録
+type Payment struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	TableID         *uint     `gorm:"index" json:"table_id,omitempty"`
+	TableSessionID  *uint     `gorm:"index" json:"table_session_id,omitempty"`   // 支払い対象の来店
+	OrderID         *uint     `gorm:"index" json:"order_id,omitempty"`           // 注文単位の会計の場合の対象（来店に紐づかない注文）
+	BillSplitPartID *uint     `gorm:"index" json:"bill_split_part_id,omitempty"` // 分割会計の場合の対象
+	Method          string    `json:"method"`                                    // cash, card, qr
+	Amount          int       `json:"amount"`                                    // 支払額
+	Tendered        int       `json:"tendered"`                                  // お預かり金額
+	Change          int       `json:"change"`                                    // おつり
+	Reference       string    `json:"reference"`                                 // 決済端末の取引番号など
+	Status          string    `json:"status"`                                    // completed, refunded
+	ProcessedBy     *uint     `json:"processed_by,omitempty"`                    // 会計したスタッフ
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// 支払いステータス
+const (
+	PaymentCompleted = "completed"
+	PaymentRefunded  = "refunded"
+)
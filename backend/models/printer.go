@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Printer ネットワーク接続のサーマルプリンター
+type Printer struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	StoreID   uint      `gorm:"index" json:"store_id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`                     // host または host:port（ポート省略時は9100）
+	Kind      string    `json:"kind"`                        // kitchen（調理伝票）, receipt（レシート）
+	Station   string    `json:"station"`                     // 調理伝票を印刷する調理場所（空の場合はすべて）
+	Enabled   bool      `gorm:"default:true" json:"enabled"` // false の間はジョブを保留する
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// プリンターの種類
+const (
+	PrinterKitchen = "kitchen"
+	PrinterReceipt = "receipt"
+)
+
+// PrintJob 印刷ジョブ（プリンターがオフラインの場合は再試行する）
+type PrintJob struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	StoreID       uint       `gorm:"index" json:"store_id"` // プリンターの店舗（プリンター削除後も絞り込めるように保存）
+	PrinterID     uint       `gorm:"index;not null" json:"printer_id"`
+	Kind          string     `json:"kind"` // kitchen_ticket, receipt, test
+	OrderID       *uint      `gorm:"index" json:"order_id,omitempty"`
+	ReceiptID     *uint      `gorm:"index" json:"receipt_id,omitempty"`
+	Data          []byte     `json:"-"`                   // ESC/POSのバイト列
+	Status        string     `gorm:"index" json:"status"` // queued, printing, done, failed, cancelled
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	PrintedAt     *time.Time `json:"printed_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// リレーション
+	Printer *Printer `gorm:"foreignKey:PrinterID" json:"printer,omitempty"`
+}
+
+// 印刷ジョブの種類
+const (
+	PrintJobKitchenTicket = "kitchen_ticket"
+	PrintJobReceipt       = "receipt"
+	PrintJobTest          = "test"
+)
+
+// 印刷ジョブのステータス
+const (
+	PrintJobQueued    = "queued"
+	PrintJobPrinting  = "printing"
+	PrintJobDone      = "done"
+	PrintJobFailed    = "failed"
+	PrintJobCancelled = "cancelled"
+)
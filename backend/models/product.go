@@ -5,13 +5,44 @@ import (
 )
 
 type Product struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
-	Name      string    `json:"name"`
-	Price     int       `json:"price"`
-	ImagePath string    `json:"imagePath"`
-	Labels    string    `json:"labels"` // カンマ区切りのラベル（例: "新商品,人気,セール"）
-	UserID    uint      `json:"userId"`
-	User      User      `json:"user"`
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	Name        string    `json:"name"`
+	Price       int       `json:"price"`
+	ImagePath   string    `json:"imagePath"`
+	Labels      string    `json:"labels"`                               // カンマ区切りのラベル（例: "新商品,人気,セール"）
+	Station     string    `gorm:"default:'kitchen'" json:"station"`     // 調理場所（kitchen, drinks, dessert）
+	TaxCategory string    `gorm:"default:'reduced'" json:"taxCategory"` // 税区分（reduced: 飲食料品, standard: 酒類など）
+	CategoryID  *uint     `gorm:"index" json:"categoryId"`              // 所属カテゴリー（未分類の場合は null）
+	SortOrder   int       `json:"sortOrder"`                            // カテゴリー内での表示順（昇順）
+	TrackStock  bool      `json:"trackStock"`                           // 在庫数を管理するか（false の場合は無制限）
+	Stock       int       `json:"stock"`                                // 在庫数（TrackStock が true の場合のみ有効）
+	SoldOut     bool      `json:"soldOut"`                              // 品切れ（在庫管理する商品は在庫0で自動設定）
+	StoreID     uint      `gorm:"index" json:"storeId"`
+	UserID      uint      `json:"userId"` // 登録したユーザー
+	User        User      `json:"user"`
+
+	OptionGroups []OptionGroup `gorm:"foreignKey:ProductID" json:"optionGroups,omitempty"`
+	Recipe       []RecipeItem  `gorm:"foreignKey:ProductID" json:"recipe,omitempty"`
+}
+
+// 調理場所（キッチンディスプレイの振り分け先）
+const (
+	StationKitchen = "kitchen"
+	StationDrinks  = "drinks"
+	StationDessert = "dessert"
+)
+
+// Stations 有効な調理場所の一覧
+var Stations = []string{StationKitchen, StationDrinks, StationDessert}
+
+// IsValidStation 有効な調理場所か判定
+func IsValidStation(station string) bool {
+	for _, s := range Stations {
+		if s == station {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Receipt 発行済みの領収書（発行時点の内容を保存し、再発行でも同じ内容を出力する）
+type Receipt struct {
+	ID                 uint       `gorm:"primaryKey" json:"id"`
+	StoreID            uint       `gorm:"uniqueIndex:idx_receipts_store_number" json:"store_id"`
+	Number             uint       `gorm:"uniqueIndex:idx_receipts_store_number" json:"number"` // 店舗ごとの連番の領収書番号
+	TableSessionID     *uint      `gorm:"index" json:"table_session_id,omitempty"`             // テーブル会計の場合
+	OrderID            *uint      `gorm:"index" json:"order_id,omitempty"`                     // 注文単位の場合
+	TableNumber        int        `json:"table_number,omitempty"`
+	Recipient          string     `json:"recipient"`           // 宛名
+	StoreName          string     `json:"store_name"`          // 発行時点の店舗名
+	RegistrationNumber string     `json:"registration_number"` // 発行時点の登録番号
+	PriceIncludesTax   bool       `json:"price_includes_tax"`
+	Subtotal           int        `json:"subtotal"`
+	TaxAmount          int        `json:"tax_amount"`
+	Total              int        `json:"total"`
+	IssuedBy           *uint      `json:"issued_by,omitempty"`
+	IssuedAt           time.Time  `json:"issued_at"`
+	ReprintCount       int        `json:"reprint_count"` // 再発行の回数
+	LastReprintedAt    *time.Time `json:"last_reprinted_at,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+
+	// リレーション
+	Lines []ReceiptLine `gorm:"foreignKey:ReceiptID" json:"lines"`
+	Taxes []ReceiptTax  `gorm:"foreignKey:ReceiptID" json:"taxes"`
+}
+
+// ReceiptLine 領収書の明細
+type ReceiptLine struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	ReceiptID   uint   `gorm:"index;not null" json:"receipt_id"`
+	OrderItemID uint   `json:"order_item_id"`
+	Name        string `json:"name"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int    `json:"unit_price"`
+	Amount      int    `json:"amount"`
+	TaxRate     int    `json:"tax_rate"`
+}
+
+// ReceiptTax 領収書の税率ごとの対象額と消費税額
+type ReceiptTax struct {
+	ID        uint `gorm:"primaryKey" json:"id"`
+	ReceiptID uint `gorm:"index;not null" json:"receipt_id"`
+	Rate      int  `json:"rate"`
+	Subtotal  int  `json:"subtotal"` // 税抜対象額
+	Tax       int  `json:"tax"`
+	Total     int  `json:"total"` // 税込対象額
+}
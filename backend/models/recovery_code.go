@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// RecoveryCode 認証アプリを使えない場合に確認コードの代わりに使う使い捨てのリカバリーコード
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"index;not null" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"` // コードのSHA-256（コード自体は保存しない）
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,77 @@
+package models
+
+// スタッフの役割
+const (
+	RoleOwner   = "owner"   // オーナー（店舗の設定・スタッフの管理を含むすべての操作）
+	RoleManager = "manager" // マネージャー（メニュー・在庫・テーブルの管理と売上の確認）
+	RoleStaff   = "staff"   // ホールスタッフ（接客・注文・会計）
+	RoleKitchen = "kitchen" // キッチンスタッフ（調理状況の更新）
+)
+
+// Roles 選択できる役割（権限の強い順）
+var Roles = []string{RoleOwner, RoleManager, RoleStaff, RoleKitchen}
+
+// 操作ごとの権限
+const (
+	PermStoreView       = "store.view"        // 店舗の注文・テーブル・メニューの閲覧
+	PermKitchenOperate  = "kitchen.operate"   // 調理状況・注文ステータスの更新、印刷ジョブの操作
+	PermFloorOperate    = "floor.operate"     // テーブルの着席・注文・会計・領収書
+	PermOrdersVoid      = "orders.void"       // 注文の削除・返金
+	PermTablesManage    = "tables.manage"     // テーブルの登録・変更・削除
+	PermMenuManage      = "menu.manage"       // 商品・カテゴリー・オプション・レシピ・ページの編集
+	PermInventoryManage = "inventory.manage"  // 在庫・食材の管理
+	PermPrintersManage  = "printers.manage"   // プリンターの登録・変更・削除
+	PermTerminalsManage = "terminals.manage"  // 共有端末の登録・解除
+	PermAPITokensManage = "api_tokens.manage" // 連携サービス用APIトークンの発行・解除
+	PermReportsView     = "reports.view"      // 売上の集計
+	PermStoreSettings   = "store.settings"    // 税・領収書・メインメニューの設定
+	PermStaffManage     = "staff.manage"      // スタッフの招待・役割の変更
+	PermSecurityAudit   = "security.audit"    // ログイン履歴の確認
+)
+
+// rolePermissions 役割ごとに許可する操作
+var rolePermissions = map[string][]string{
+	RoleOwner: {
+		PermStoreView, PermKitchenOperate, PermFloorOperate, PermOrdersVoid,
+		PermTablesManage, PermMenuManage, PermInventoryManage, PermPrintersManage,
+		PermTerminalsManage, PermAPITokensManage, PermReportsView, PermStoreSettings,
+		PermStaffManage, PermSecurityAudit,
+	},
+	RoleManager: {
+		PermStoreView, PermKitchenOperate, PermFloorOperate, PermOrdersVoid,
+		PermTablesManage, PermMenuManage, PermInventoryManage, PermPrintersManage,
+		PermTerminalsManage, PermAPITokensManage, PermReportsView,
+	},
+	RoleStaff: {
+		PermStoreView, PermKitchenOperate, PermFloorOperate,
+	},
+	RoleKitchen: {
+		PermStoreView, PermKitchenOperate,
+	},
+}
+
+// IsValidRole 役割が有効か
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// RoleHasPermission 役割が操作を許可されているか
+func RoleHasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleRequiresTwoFactor 店舗で二段階認証を必須にした場合に対象となる役割か（オーナー・マネージャー）
+func RoleRequiresTwoFactor(role string) bool {
+	return role == RoleOwner || role == RoleManager
+}
+
+// RolePermissions 役割に許可されている操作の一覧
+func RolePermissions(role string) []string {
+	return append([]string(nil), rolePermissions[role]...)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// StockMovement 在庫の増減履歴（監査用の台帳）
+type StockMovement struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	ProductID     uint      `gorm:"index;not null" json:"product_id"`
+	Delta         int       `json:"delta"`       // 増減数（出庫は負数）
+	StockAfter    int       `json:"stock_after"` // 増減後の在庫数
+	Reason        string    `json:"reason"`      // sale（注文）, cancel（取り消しによる戻し）, adjust（手動調整）
+	OrderID       *uint     `gorm:"index" json:"order_id,omitempty"`
+	OrderItemID   *uint     `gorm:"index" json:"order_item_id,omitempty"`
+	Note          string    `json:"note,omitempty"`
+	ChangedBy     *uint     `json:"changed_by,omitempty"`      // 変更したユーザー（ゲスト注文の場合は null）
+	ChangedByName string    `json:"changed_by_name,omitempty"` // 変更したユーザー名
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// 在庫の増減理由
+const (
+	StockReasonSale   = "sale"
+	StockReasonCancel = "cancel"
+	StockReasonAdjust = "adjust"
+)
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Store 店舗（商品・テーブル・ページ・注文・カートはいずれかの店舗に属する）
+type Store struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	Name             string    `json:"name"`                                   // 店舗名（領収書に記載）
+	OwnerID          *uint     `gorm:"index" json:"owner_id,omitempty"`        // 店舗を作成したユーザー
+	PriceIncludesTax bool      `gorm:"default:true" json:"price_includes_tax"` // 商品価格を税込で登録しているか（false の場合は税抜）
+	InvoiceNumber    string    `json:"invoice_number"`                         // 適格請求書発行事業者の登録番号（T + 13桁）
+	MainMenuPage     string    `json:"main_menu_page"`                         // メインメニューとして使用するHTMLページ名
+	RequireTwoFactor bool      `json:"require_two_factor"`                     // オーナー・マネージャーに二段階認証を必須にするか
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// DefaultStoreName 既存データの移行先となる店舗の名前（店舗名が未設定の場合）
+const DefaultStoreName = "デフォルト店舗"
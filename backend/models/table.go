@@ -5,9 +5,10 @@ import "time"
 // Table テーブル（座席）情報
 type Table struct {
 	ID          uint      `gorm:"primaryKey" json:"id"`
-	TableNumber int       `gorm:"uniqueIndex;not null" json:"table_number"` // 1, 2, 3...
-	Capacity    int       `json:"capacity"`                                  // 座席数
-	Status      string    `json:"status"`                                    // "active" or "inactive"
+	StoreID     uint      `gorm:"uniqueIndex:idx_tables_store_number" json:"store_id"`
+	TableNumber int       `gorm:"uniqueIndex:idx_tables_store_number;not null" json:"table_number"` // 1, 2, 3...（店舗内で一意）
+	Capacity    int       `json:"capacity"`                                                         // 座席数
+	Status      string    `json:"status"`                                                           // "active" or "inactive"
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// TableSession テーブルの1回の利用（着席〜会計）
+type TableSession struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	TableID   uint       `gorm:"index;not null" json:"table_id"`
+	PartySize int        `json:"party_size"`          // 人数
+	Status    string     `gorm:"index" json:"status"` // "open" or "closed"
+	OpenedAt  time.Time  `json:"opened_at"`           // 着席時刻
+	ClosedAt  *time.Time `json:"closed_at,omitempty"` // 会計（退席）時刻
+	OpenedBy  *uint      `json:"opened_by,omitempty"` // 案内したスタッフ（セルフオーダーでの自動開始はnil）
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+
+	// リレーション
+	Table *Table `gorm:"foreignKey:TableID" json:"table,omitempty"`
+}
+
+// テーブルセッションのステータス
+const (
+	TableSessionOpen   = "open"
+	TableSessionClosed = "closed"
+)
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// TableToken テーブルのQRコード用トークンの発行記録（失効管理用）
+type TableToken struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	TableID        uint       `gorm:"index;not null" json:"table_id"`
+	TableSessionID uint       `gorm:"index;not null" json:"table_session_id"` // 発行時の来店セッション
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Terminal 店舗に登録した共有端末（登録済みの端末でのみPINでログインできる）
+type Terminal struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	StoreID    uint       `gorm:"index;not null" json:"store_id"`
+	Name       string     `json:"name"`                          // 「ホール1」など
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"` // 端末トークンのSHA-256（トークン自体は保存しない）
+	CreatedBy  *uint      `json:"created_by,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
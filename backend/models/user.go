@@ -1,12 +1,37 @@
 // models/user.go
 package models
 
-import "gorm.io/gorm"
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
-	Username     string `gorm:"unique"`
-	Password     string
-	OpenAIKey    string `gorm:"default:''"`
-	MainMenuPage string `gorm:"default:''"` // メインメニューとして使用するHTMLページ名
+	Username  string `gorm:"unique"`
+	Password  string `json:"-"`
+	OpenAIKey string `gorm:"default:''" json:"-"`
+	StoreID   *uint  `gorm:"index"`   // 所属する店舗（店舗の設定は Store で管理する）
+	Role      string `gorm:"size:20"` // 店舗での役割（owner, manager, staff, kitchen）
+	PINHash   string `json:"-"`       // 共有端末でのクイックログイン用PIN（bcrypt）
+
+	Email           string     `gorm:"index" json:"email"`          // パスワードの再設定に使うメールアドレス（任意）
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"` // メールアドレスを確認した日時（未確認の場合は再設定に使えない）
+
+	FailedLogins int        `json:"-"`                      // パスワードの連続失敗回数（ログインに成功するとリセット）
+	LockedUntil  *time.Time `json:"locked_until,omitempty"` // 連続失敗による一時ロックの解除時刻
+
+	FailedPINs     int        `gorm:"column:failed_pins" json:"-"` // PINの連続失敗回数（PINでのログインに成功するとリセット）
+	PINLockedUntil *time.Time `json:"pin_locked_until,omitempty"`  // PINの連続失敗による一時ロックの解除時刻（パスワードではログインできる）
+
+	TOTPSecret   string `json:"-"`            // 二段階認証のシークレット（有効化前は設定中のもの）
+	TOTPEnabled  bool   `json:"totp_enabled"` // 二段階認証が有効か
+	TOTPLastStep int64  `json:"-"`            // 最後に使われたコードのステップ番号（同じコードの再利用を防ぐ）
+}
+
+// OpenAIKeyContext OpenAI APIキーを暗号化する際の保存先（別のユーザーの行にコピーしても復号できないようにする）
+func OpenAIKeyContext(userID uint) string {
+	return "users.open_ai_key:" + strconv.FormatUint(uint64(userID), 10)
 }
@@ -0,0 +1,33 @@
+package payments
+
+import "context"
+
+// CashProvider 現金払い（お預かり金額からおつりを計算）
+type CashProvider struct{}
+
+func (CashProvider) Method() string {
+	return MethodCash
+}
+
+func (CashProvider) Charge(ctx context.Context, req Request) (Result, error) {
+	tendered := req.Tendered
+	if tendered == 0 {
+		// お預かり金額の指定がなければちょうど受け取ったものとする
+		tendered = req.Amount
+	}
+	if tendered < req.Amount {
+		return Result{}, ErrInsufficientTender
+	}
+	return Result{
+		Method:    MethodCash,
+		Amount:    req.Amount,
+		Tendered:  tendered,
+		Change:    tendered - req.Amount,
+		Reference: req.Reference,
+	}, nil
+}
+
+func (CashProvider) Refund(ctx context.Context, result Result) error {
+	// 現金はレジでの返金のみ
+	return nil
+}
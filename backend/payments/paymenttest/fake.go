@@ -0,0 +1,61 @@
+// Package paymenttest テスト用の疑似決済Provider
+package paymenttest
+
+import (
+	"context"
+	"fmt"
+	"orderbase/payments"
+	"sync"
+)
+
+// FakeProvider カード・QR決済の疑似実装（テスト用）
+// Declineを設定すると決済が拒否される
+type FakeProvider struct {
+	MethodName string
+	Decline    bool
+
+	mu       sync.Mutex
+	seq      int
+	Charges  []payments.Result
+	Refunded []payments.Result
+}
+
+// NewFakeProvider 支払い方法を指定して疑似Providerを作成
+func NewFakeProvider(method string) *FakeProvider {
+	return &FakeProvider{MethodName: method}
+}
+
+func (p *FakeProvider) Method() string {
+	return p.MethodName
+}
+
+func (p *FakeProvider) Charge(ctx context.Context, req payments.Request) (payments.Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Decline {
+		return payments.Result{}, payments.ErrDeclined
+	}
+
+	p.seq++
+	reference := req.Reference
+	if reference == "" {
+		reference = fmt.Sprintf("FAKE-%s-%06d", p.MethodName, p.seq)
+	}
+	result := payments.Result{
+		Method:    p.MethodName,
+		Amount:    req.Amount,
+		Tendered:  req.Amount,
+		Reference: reference,
+	}
+	p.Charges = append(p.Charges, result)
+	return result, nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, result payments.Result) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Refunded = append(p.Refunded, result)
+	return nil
+}
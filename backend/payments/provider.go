@@ -0,0 +1,71 @@
+// Package payments 支払い方法ごとの決済処理
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+// 支払い方法
+const (
+	MethodCash = "cash"
+	MethodCard = "card"
+	MethodQR   = "qr"
+)
+
+var (
+	ErrUnknownMethod      = errors.New("対応していない支払い方法です")
+	ErrInsufficientTender = errors.New("お預かり金額が不足しています")
+	ErrDeclined           = errors.New("決済が承認されませんでした")
+	ErrReferenceRequired  = errors.New("決済端末の取引番号を入力してください")
+)
+
+// Request 決済リクエスト
+type Request struct {
+	Method    string
+	Amount    int    // 請求額（円）
+	Tendered  int    // お預かり金額（現金のみ）
+	Reference string // 決済端末の取引番号（カード・QRは必須）
+}
+
+// Result 決済結果
+type Result struct {
+	Method    string
+	Amount    int
+	Tendered  int
+	Change    int    // おつり
+	Reference string // 取引番号
+}
+
+// Provider 支払い方法ごとの決済処理
+type Provider interface {
+	// Method 担当する支払い方法
+	Method() string
+	// Charge 決済を実行
+	Charge(ctx context.Context, req Request) (Result, error)
+	// Refund 決済を取り消す（後続の処理が失敗した場合など）
+	Refund(ctx context.Context, result Result) error
+}
+
+// Registry 支払い方法とProviderの対応
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry Providerを登録したRegistryを作成
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider)}
+	for _, p := range providers {
+		r.providers[p.Method()] = p
+	}
+	return r
+}
+
+// Get 支払い方法に対応するProviderを取得
+func (r *Registry) Get(method string) (Provider, error) {
+	p, ok := r.providers[method]
+	if !ok {
+		return nil, ErrUnknownMethod
+	}
+	return p, nil
+}
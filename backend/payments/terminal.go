@@ -0,0 +1,39 @@
+package payments
+
+import (
+	"context"
+	"strings"
+)
+
+// TerminalProvider 店舗の決済端末（カード・QR）での支払い
+// 決済は端末で行い、スタッフが入力した端末の取引番号を記録する
+type TerminalProvider struct {
+	MethodName string
+}
+
+// NewTerminalProvider 支払い方法を指定して決済端末のProviderを作成
+func NewTerminalProvider(method string) *TerminalProvider {
+	return &TerminalProvider{MethodName: method}
+}
+
+func (p *TerminalProvider) Method() string {
+	return p.MethodName
+}
+
+func (p *TerminalProvider) Charge(ctx context.Context, req Request) (Result, error) {
+	reference := strings.TrimSpace(req.Reference)
+	if reference == "" {
+		return Result{}, ErrReferenceRequired
+	}
+	return Result{
+		Method:    p.MethodName,
+		Amount:    req.Amount,
+		Tendered:  req.Amount,
+		Reference: reference,
+	}, nil
+}
+
+func (p *TerminalProvider) Refund(ctx context.Context, result Result) error {
+	// 取り消しは決済端末で取引番号を指定して行う
+	return nil
+}
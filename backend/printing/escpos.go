@@ -0,0 +1,107 @@
+// Package printing ネットワーク接続のサーマルプリンター（ESC/POS）への印刷
+package printing
+
+import (
+	"bytes"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// ESC/POSコマンド
+var (
+	cmdInit        = []byte{0x1B, 0x40}             // ESC @ 初期化
+	cmdKatakana    = []byte{0x1B, 0x74, 0x01}       // ESC t 1 カタカナのコードページ
+	cmdKanjiOn     = []byte{0x1C, 0x26}             // FS & 漢字モード
+	cmdShiftJIS    = []byte{0x1C, 0x43, 0x01}       // FS C 1 漢字コード体系をShift_JISに
+	cmdAlignLeft   = []byte{0x1B, 0x61, 0x00}       // ESC a 0
+	cmdAlignCenter = []byte{0x1B, 0x61, 0x01}       // ESC a 1
+	cmdBoldOn      = []byte{0x1B, 0x45, 0x01}       // ESC E 1
+	cmdBoldOff     = []byte{0x1B, 0x45, 0x00}       // ESC E 0
+	cmdSizeNormal  = []byte{0x1D, 0x21, 0x00}       // GS ! 0
+	cmdSizeDouble  = []byte{0x1D, 0x21, 0x11}       // GS ! 0x11 縦横2倍
+	cmdKanjiDouble = []byte{0x1C, 0x21, 0x0C}       // FS ! 漢字の縦横2倍
+	cmdKanjiNormal = []byte{0x1C, 0x21, 0x00}       // FS ! 0
+	cmdFeedCut     = []byte{0x1D, 0x56, 0x42, 0x03} // GS V 66 3 3行送ってパーシャルカット
+)
+
+// Builder ESC/POSのバイト列を組み立てる（80mm幅の日本語モデル向け）
+type Builder struct {
+	buf bytes.Buffer
+}
+
+// NewBuilder プリンターを初期化し、日本語（Shift_JIS）を印字できる状態から始める
+func NewBuilder() *Builder {
+	b := &Builder{}
+	b.buf.Write(cmdInit)
+	b.buf.Write(cmdKatakana)
+	b.buf.Write(cmdKanjiOn)
+	b.buf.Write(cmdShiftJIS)
+	return b
+}
+
+// Center 中央寄せ
+func (b *Builder) Center() *Builder {
+	b.buf.Write(cmdAlignCenter)
+	return b
+}
+
+// Left 左寄せ
+func (b *Builder) Left() *Builder {
+	b.buf.Write(cmdAlignLeft)
+	return b
+}
+
+// Bold 強調の切り替え
+func (b *Builder) Bold(on bool) *Builder {
+	if on {
+		b.buf.Write(cmdBoldOn)
+	} else {
+		b.buf.Write(cmdBoldOff)
+	}
+	return b
+}
+
+// Large 文字サイズ（縦横2倍）の切り替え
+func (b *Builder) Large(on bool) *Builder {
+	if on {
+		b.buf.Write(cmdSizeDouble)
+		b.buf.Write(cmdKanjiDouble)
+	} else {
+		b.buf.Write(cmdSizeNormal)
+		b.buf.Write(cmdKanjiNormal)
+	}
+	return b
+}
+
+// Line 1行印字する（Shift_JISで表せない文字は「?」になる）
+func (b *Builder) Line(s string) *Builder {
+	b.buf.Write(encodeShiftJIS(s))
+	b.buf.WriteByte('\n')
+	return b
+}
+
+// Cut 用紙を送ってカットする
+func (b *Builder) Cut() *Builder {
+	b.buf.Write(cmdFeedCut)
+	return b
+}
+
+// Bytes 組み立てたバイト列
+func (b *Builder) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// encodeShiftJIS 文字列をShift_JISに変換（変換できない文字は「?」に置き換える）
+func encodeShiftJIS(s string) []byte {
+	encoder := japanese.ShiftJIS.NewEncoder()
+	var out bytes.Buffer
+	for _, r := range s {
+		encoded, err := encoder.String(string(r))
+		if err != nil {
+			out.WriteByte('?')
+			continue
+		}
+		out.WriteString(encoded)
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,40 @@
+package printing
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DefaultPort RAW印刷（JetDirect）のポート
+const DefaultPort = "9100"
+
+// 接続・送信のタイムアウト
+const (
+	dialTimeout  = 5 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+// normalizeAddress ポートが省略されていれば9100を補う
+func normalizeAddress(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, DefaultPort)
+}
+
+// Send プリンターにTCPで接続し、バイト列をそのまま送る
+func Send(ctx context.Context, address string, data []byte) error {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", normalizeAddress(address))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,184 @@
+package printing
+
+import (
+	"context"
+	"log"
+	"orderbase/events"
+	"orderbase/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 再試行の設定
+const (
+	pollInterval  = 2 * time.Second
+	retryBase     = 5 * time.Second
+	retryMaxDelay = 5 * time.Minute
+	MaxAttempts   = 10 // これを超えて失敗したジョブは failed にする
+)
+
+// Spooler 印刷ジョブをDBに保存し、プリンターごとに順番に送信する
+// プリンターがオフラインの場合は間隔を空けて再試行する
+type Spooler struct {
+	db   *gorm.DB
+	wake chan struct{}
+
+	// Send 送信処理（テストではローカルのTCPリスナーや差し替えた関数を使う）
+	Send func(ctx context.Context, address string, data []byte) error
+}
+
+// NewSpooler スプーラーを作成（Run で送信を開始する）
+func NewSpooler(db *gorm.DB) *Spooler {
+	return &Spooler{
+		db:   db,
+		wake: make(chan struct{}, 1),
+		Send: Send,
+	}
+}
+
+// Enqueue 印刷ジョブを登録（txを渡すと呼び出し側のトランザクションで保存される）
+func (s *Spooler) Enqueue(tx *gorm.DB, printer *models.Printer, kind string, data []byte, orderID, receiptID *uint) (*models.PrintJob, error) {
+	job := models.PrintJob{
+		StoreID:       printer.StoreID,
+		PrinterID:     printer.ID,
+		Kind:          kind,
+		OrderID:       orderID,
+		ReceiptID:     receiptID,
+		Data:          data,
+		Status:        models.PrintJobQueued,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.Create(&job).Error; err != nil {
+		return nil, err
+	}
+	s.Wake()
+	return &job, nil
+}
+
+// Wake 待機中のスプーラーをすぐに動かす
+func (s *Spooler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run ctxが終了するまでジョブを送信し続ける
+func (s *Spooler) Run(ctx context.Context) {
+	// 送信中に停止したジョブを再送対象に戻す
+	if err := s.db.Model(&models.PrintJob{}).
+		Where("status = ?", models.PrintJobPrinting).
+		Update("status", models.PrintJobQueued).Error; err != nil {
+		log.Printf("印刷ジョブの復旧に失敗しました: %v", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		s.process(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// process 送信時刻になったジョブを送信する
+// 同じプリンターのジョブは登録順に送り、先のジョブが待機中なら後のジョブも待たせる
+func (s *Spooler) process(ctx context.Context) {
+	var jobs []models.PrintJob
+	if err := s.db.Where("status = ?", models.PrintJobQueued).
+		Preload("Printer").
+		Order("id ASC").
+		Find(&jobs).Error; err != nil {
+		log.Printf("印刷ジョブの取得に失敗しました: %v", err)
+		return
+	}
+
+	blocked := make(map[uint]bool)
+	now := time.Now()
+	for i := range jobs {
+		job := &jobs[i]
+		if blocked[job.PrinterID] {
+			continue
+		}
+		if job.Printer == nil {
+			s.finish(job, models.PrintJobFailed, "プリンターが削除されています")
+			continue
+		}
+		if !job.Printer.Enabled || job.NextAttemptAt.After(now) {
+			blocked[job.PrinterID] = true
+			continue
+		}
+		if !s.print(ctx, job) {
+			blocked[job.PrinterID] = true
+		}
+	}
+}
+
+// print ジョブを1件送信し、成功したかを返す
+func (s *Spooler) print(ctx context.Context, job *models.PrintJob) bool {
+	if err := s.db.Model(job).Update("status", models.PrintJobPrinting).Error; err != nil {
+		log.Printf("印刷ジョブの更新に失敗しました: %v", err)
+		return false
+	}
+
+	err := s.Send(ctx, job.Printer.Address, job.Data)
+	job.Attempts++
+	if err == nil {
+		now := time.Now()
+		job.PrintedAt = &now
+		s.finish(job, models.PrintJobDone, "")
+		return true
+	}
+
+	if job.Attempts >= MaxAttempts {
+		s.finish(job, models.PrintJobFailed, err.Error())
+		return false
+	}
+
+	// オフラインの間は間隔を延ばしながら再試行する
+	job.Status = models.PrintJobQueued
+	job.LastError = err.Error()
+	job.NextAttemptAt = time.Now().Add(retryDelay(job.Attempts))
+	if err := s.db.Model(job).Updates(map[string]interface{}{
+		"status":          job.Status,
+		"attempts":        job.Attempts,
+		"last_error":      job.LastError,
+		"next_attempt_at": job.NextAttemptAt,
+	}).Error; err != nil {
+		log.Printf("印刷ジョブの更新に失敗しました: %v", err)
+	}
+	return false
+}
+
+// finish ジョブを完了・失敗にする
+func (s *Spooler) finish(job *models.PrintJob, status, lastError string) {
+	job.Status = status
+	job.LastError = lastError
+	if err := s.db.Model(job).Updates(map[string]interface{}{
+		"status":     job.Status,
+		"attempts":   job.Attempts,
+		"last_error": job.LastError,
+		"printed_at": job.PrintedAt,
+	}).Error; err != nil {
+		log.Printf("印刷ジョブの更新に失敗しました: %v", err)
+		return
+	}
+	events.Publish(job.StoreID, events.PrintJobChanged, job)
+}
+
+// retryDelay 再試行までの待ち時間（5秒から倍々に延ばし、最大5分）
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,202 @@
+package printing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"orderbase/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestSpooler 一時DBを使うスプーラーを作成（送信は実際のTCP接続で行う）
+func newTestSpooler(t *testing.T) (*Spooler, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Printer{}, &models.PrintJob{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewSpooler(db), db
+}
+
+// listenPrinter ローカルのTCPリスナーをプリンターの代わりに起動し、接続ごとに受信したバイト列を送る
+func listenPrinter(t *testing.T, address string) (net.Listener, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan []byte, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			data, _ := io.ReadAll(conn)
+			conn.Close()
+			received <- data
+		}
+	}()
+	return ln, received
+}
+
+// offlineAddress 接続を受け付けないアドレス（起動してすぐ閉じたリスナーのアドレス）
+func offlineAddress(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+	return address
+}
+
+func createPrinter(t *testing.T, db *gorm.DB, address string) *models.Printer {
+	t.Helper()
+	printer := models.Printer{StoreID: 1, Name: "キッチン", Address: address, Kind: models.PrinterKitchen, Enabled: true}
+	if err := db.Create(&printer).Error; err != nil {
+		t.Fatal(err)
+	}
+	return &printer
+}
+
+func loadJob(t *testing.T, db *gorm.DB, id uint) models.PrintJob {
+	t.Helper()
+	var job models.PrintJob
+	if err := db.First(&job, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func waitReceived(t *testing.T, received <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("プリンターがデータを受信しませんでした")
+		return nil
+	}
+}
+
+func TestSpoolerPrintsKitchenTicket(t *testing.T) {
+	spooler, db := newTestSpooler(t)
+	ln, received := listenPrinter(t, "127.0.0.1:0")
+	printer := createPrinter(t, db, ln.Addr().String())
+
+	data := KitchenTicket(Ticket{
+		OrderID:     42,
+		TableNumber: 3,
+		Station:     "厨房",
+		OrderedAt:   time.Date(2024, 4, 1, 12, 30, 0, 0, time.Local),
+		Items:       []TicketItem{{Name: "ラーメン", Quantity: 2, Options: []string{"大盛り"}}},
+	})
+	job, err := spooler.Enqueue(db, printer, models.PrintJobKitchenTicket, data, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spooler.process(context.Background())
+
+	got := waitReceived(t, received)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("received %q, want %q", got, data)
+	}
+	if !bytes.HasPrefix(got, append(append(append([]byte{}, cmdInit...), cmdKatakana...), cmdKanjiOn...)) {
+		t.Errorf("伝票がプリンターの初期化で始まっていません: % x", got[:8])
+	}
+	if !bytes.HasSuffix(got, cmdFeedCut) {
+		t.Errorf("伝票がカットで終わっていません: % x", got[len(got)-4:])
+	}
+	for _, text := range []string{"テーブル 3", "ラーメン x2", "  + 大盛り"} {
+		if !bytes.Contains(got, encodeShiftJIS(text)) {
+			t.Errorf("伝票に %q（Shift_JIS）が含まれていません", text)
+		}
+	}
+
+	saved := loadJob(t, db, job.ID)
+	if saved.Status != models.PrintJobDone || saved.Attempts != 1 || saved.PrintedAt == nil {
+		t.Errorf("job = status %q, attempts %d, printed_at %v; want done, 1, set", saved.Status, saved.Attempts, saved.PrintedAt)
+	}
+}
+
+func TestSpoolerRetriesWhilePrinterOffline(t *testing.T) {
+	spooler, db := newTestSpooler(t)
+	address := offlineAddress(t)
+	printer := createPrinter(t, db, address)
+
+	first, err := spooler.Enqueue(db, printer, models.PrintJobTest, TestPage("キッチン", time.Now()), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := spooler.Enqueue(db, printer, models.PrintJobTest, TestPage("キッチン", time.Now()), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	spooler.process(context.Background())
+
+	// 送信に失敗したジョブは間隔を空けて再試行し、同じプリンターの後のジョブは待たせる
+	job := loadJob(t, db, first.ID)
+	if job.Status != models.PrintJobQueued || job.Attempts != 1 || job.LastError == "" {
+		t.Fatalf("job = status %q, attempts %d, last_error %q; want queued, 1, set", job.Status, job.Attempts, job.LastError)
+	}
+	if job.NextAttemptAt.Before(before.Add(retryBase)) {
+		t.Errorf("next_attempt_at = %v, want after %v", job.NextAttemptAt, before.Add(retryBase))
+	}
+	if waiting := loadJob(t, db, second.ID); waiting.Status != models.PrintJobQueued || waiting.Attempts != 0 {
+		t.Errorf("second job = status %q, attempts %d; want queued, 0", waiting.Status, waiting.Attempts)
+	}
+
+	// プリンターが復帰し、再試行の時刻になると順番に送信される
+	_, received := listenPrinter(t, address)
+	if err := db.Model(&models.PrintJob{}).Where("id = ?", first.ID).Update("next_attempt_at", time.Now()).Error; err != nil {
+		t.Fatal(err)
+	}
+	spooler.process(context.Background())
+
+	waitReceived(t, received)
+	waitReceived(t, received)
+	for _, id := range []uint{first.ID, second.ID} {
+		if job := loadJob(t, db, id); job.Status != models.PrintJobDone {
+			t.Errorf("job %d status = %q, want done", id, job.Status)
+		}
+	}
+}
+
+func TestSpoolerFailsAfterMaxAttempts(t *testing.T) {
+	spooler, db := newTestSpooler(t)
+	printer := createPrinter(t, db, offlineAddress(t))
+
+	job, err := spooler.Enqueue(db, printer, models.PrintJobTest, TestPage("キッチン", time.Now()), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(job).Update("attempts", MaxAttempts-1).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	spooler.process(context.Background())
+
+	saved := loadJob(t, db, job.ID)
+	if saved.Status != models.PrintJobFailed || saved.Attempts != MaxAttempts || saved.LastError == "" {
+		t.Errorf("job = status %q, attempts %d, last_error %q; want failed, %d, set", saved.Status, saved.Attempts, saved.LastError, MaxAttempts)
+	}
+}
@@ -0,0 +1,75 @@
+package printing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TicketItem 調理伝票の1品
+type TicketItem struct {
+	Name     string
+	Quantity int
+	Options  []string // 選択されたオプション（品名の下に1行ずつ印字）
+}
+
+// Ticket 調理伝票（調理場所ごとに1枚）
+type Ticket struct {
+	OrderID     uint
+	TableNumber int    // テーブル注文でない場合は0
+	Station     string // 調理場所の表示名
+	Takeout     bool
+	OrderedAt   time.Time
+	Items       []TicketItem
+}
+
+// KitchenTicket 調理伝票をESC/POSのバイト列にする
+func KitchenTicket(t Ticket) []byte {
+	b := NewBuilder()
+	b.Center().Bold(true).Line(t.Station).Bold(false)
+
+	b.Large(true)
+	if t.TableNumber > 0 {
+		b.Line(fmt.Sprintf("テーブル %d", t.TableNumber))
+	}
+	if t.Takeout {
+		b.Line("【持ち帰り】")
+	}
+	b.Large(false)
+
+	b.Line(fmt.Sprintf("注文 #%d  %s", t.OrderID, t.OrderedAt.Format("15:04")))
+	b.Left().Line("------------------------------------------------")
+	b.Large(true)
+	for _, item := range t.Items {
+		b.Line(fmt.Sprintf("%s x%d", item.Name, item.Quantity))
+		for _, option := range item.Options {
+			b.Line("  + " + option)
+		}
+	}
+	b.Large(false)
+	return b.Cut().Bytes()
+}
+
+// Receipt 整形済みの領収書の行をESC/POSのバイト列にする（1行目は表題として大きく印字）
+func Receipt(lines []string) []byte {
+	b := NewBuilder()
+	for i, line := range lines {
+		if i == 0 {
+			b.Center().Large(true).Line(strings.TrimSpace(line)).Large(false).Left()
+			continue
+		}
+		b.Line(line)
+	}
+	return b.Cut().Bytes()
+}
+
+// TestPage 接続確認用の印字
+func TestPage(printerName string, now time.Time) []byte {
+	return NewBuilder().
+		Center().
+		Bold(true).Line("テスト印刷").Bold(false).
+		Line(printerName).
+		Line(now.Format("2006/01/02 15:04:05")).
+		Cut().
+		Bytes()
+}
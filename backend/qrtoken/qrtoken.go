@@ -0,0 +1,86 @@
+// Package qrtoken テーブルのQRコードに埋め込む署名付きトークン
+package qrtoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("不正なトークンです")
+	ErrExpiredToken = errors.New("トークンの有効期限が切れています")
+)
+
+// Claims トークンに含まれる情報
+type Claims struct {
+	TokenID   uint  `json:"k"` // 失効管理用のトークンID
+	TableID   uint  `json:"t"`
+	SessionID uint  `json:"s"` // 発行時の来店セッション
+	ExpiresAt int64 `json:"e"` // UNIX時刻（秒）
+}
+
+// Signer HMAC-SHA256でトークンを署名・検証する
+type Signer struct {
+	key []byte
+}
+
+// NewSigner 署名鍵からSignerを作成
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// RandomKey ランダムな署名鍵を生成（鍵が設定されていない開発環境用）
+func RandomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Sign クレームに署名してトークン文字列を作成
+func (s *Signer) Sign(claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signature(encoded), nil
+}
+
+// Verify 署名と有効期限を検証してクレームを返す
+func (s *Signer) Verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return claims, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(s.signature(parts[0]))) {
+		return claims, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+func (s *Signer) signature(encoded string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
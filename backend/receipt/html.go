@@ -0,0 +1,67 @@
+package receipt
+
+import (
+	"bytes"
+	"html/template"
+	"orderbase/billing"
+)
+
+var htmlTemplate = template.Must(template.New("receipt").Funcs(template.FuncMap{
+	"yen": Yen,
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} No.{{.Number}}</title>
+<style>
+body { font-family: sans-serif; width: 80mm; margin: 0 auto; padding: 8px; font-size: 13px; }
+h1 { text-align: center; font-size: 20px; margin: 8px 0; }
+.duplicate { color: #c00; }
+table { width: 100%; border-collapse: collapse; }
+td.amount { text-align: right; white-space: nowrap; }
+.rule { border-top: 1px dashed #000; margin: 6px 0; }
+.total td { font-weight: bold; font-size: 16px; }
+.note { font-size: 11px; }
+</style>
+</head>
+<body>
+<h1{{if .Duplicate}} class="duplicate"{{end}}>{{.Title}}</h1>
+{{if .Recipient}}<p>{{.Recipient}} 様</p>{{end}}
+<div>{{.StoreName}}</div>
+<div>登録番号: {{.RegistrationNumber}}</div>
+<div>発行日時: {{.IssuedAt.Format "2006/01/02 15:04"}}</div>
+<div>No. {{.Number}}</div>
+{{if .Note}}<div>{{.Note}}</div>{{end}}
+<div class="rule"></div>
+<table>
+{{range .Lines}}<tr><td>{{.Name}}{{if .Reduced}} ※{{end}}<br>&nbsp;&nbsp;{{yen .UnitPrice}} x {{.Quantity}}</td><td class="amount">{{yen .Amount}}</td></tr>
+{{end}}</table>
+<div class="rule"></div>
+<table>
+{{range .Taxes}}{{if $.PriceIncludesTax}}<tr><td>{{.Rate}}%対象</td><td class="amount">{{yen .Total}}</td></tr>
+<tr><td>&nbsp;&nbsp;内消費税</td><td class="amount">{{yen .Tax}}</td></tr>
+{{else}}<tr><td>{{.Rate}}%対象（税抜）</td><td class="amount">{{yen .Subtotal}}</td></tr>
+<tr><td>&nbsp;&nbsp;消費税</td><td class="amount">{{yen .Tax}}</td></tr>
+{{end}}{{end}}<tr class="total"><td>合計</td><td class="amount">{{yen .Total}}</td></tr>
+<tr><td>&nbsp;&nbsp;うち消費税</td><td class="amount">{{yen .Tax}}</td></tr>
+</table>
+<div class="rule"></div>
+{{if .HasReduced}}<p class="note">※は軽減税率（{{.ReducedRate}}%）対象商品です</p>{{end}}
+<p class="note">上記正に領収いたしました</p>
+</body>
+</html>
+`))
+
+// ReducedRate 軽減税率（テンプレート用）
+func (d Document) ReducedRate() int {
+	return billing.ReducedTaxRate
+}
+
+// HTML 領収書を印刷用のHTMLで出力
+func HTML(d Document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
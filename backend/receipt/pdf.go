@@ -0,0 +1,73 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf16"
+)
+
+// PDFのレイアウト（単位: pt）
+const (
+	pdfFontSize   = 10
+	pdfLineHeight = 13
+	pdfMargin     = 12
+)
+
+// pdfHexString 文字列をUCS-2（UTF-16BE）の16進文字列にする
+// BMP外の文字はフォントのエンコーディングで扱えないため「?」に置き換える
+func pdfHexString(s string) string {
+	var b bytes.Buffer
+	b.WriteByte('<')
+	for _, r := range s {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// PDF 領収書をPDFで出力
+// フォントは埋め込まず、PDFビューアが標準で備える日本語フォント（HeiseiKakuGo-W5）を参照する
+// 英数字を半角幅（UniJIS-UCS2-HW-H）にすることで、テキスト出力と同じ桁揃えになる
+func PDF(d Document) []byte {
+	lines := TextLines(d)
+	width := pdfMargin*2 + textWidth*pdfFontSize/2
+	height := pdfMargin*2 + len(lines)*pdfLineHeight
+
+	var content bytes.Buffer
+	content.WriteString("BT\n")
+	fmt.Fprintf(&content, "/F1 %d Tf\n%d TL\n", pdfFontSize, pdfLineHeight)
+	fmt.Fprintf(&content, "%d %d Td\n", pdfMargin, height-pdfMargin-pdfFontSize)
+	for _, line := range lines {
+		fmt.Fprintf(&content, "%s Tj T*\n", pdfHexString(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>", width, height),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /HeiseiKakuGo-W5 /Encoding /UniJIS-UCS2-HW-H /DescendantFonts [6 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /HeiseiKakuGo-W5 /CIDSystemInfo << /Registry (Adobe) /Ordering (Japan1) /Supplement 2 >> /FontDescriptor 7 0 R /DW 1000 /W [231 632 500] >>",
+		"<< /Type /FontDescriptor /FontName /HeiseiKakuGo-W5 /Flags 4 /FontBBox [-92 -250 1010 922] /ItalicAngle 0 /Ascent 752 /Descent -221 /CapHeight 737 /StemV 114 >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+// Package receipt 適格請求書（適格簡易請求書）形式の領収書の出力
+package receipt
+
+import (
+	"fmt"
+	"orderbase/billing"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// registrationNumberPattern 適格請求書発行事業者の登録番号（T + 13桁）
+var registrationNumberPattern = regexp.MustCompile(`^T[0-9]{13}$`)
+
+// IsValidRegistrationNumber 登録番号の形式か判定
+func IsValidRegistrationNumber(number string) bool {
+	return registrationNumberPattern.MatchString(number)
+}
+
+// Line 領収書の明細
+type Line struct {
+	Name      string
+	Quantity  int
+	UnitPrice int
+	Amount    int
+	Rate      int // 税率（%）
+}
+
+// Reduced 軽減税率の対象か
+func (l Line) Reduced() bool {
+	return l.Rate == billing.ReducedTaxRate
+}
+
+// Document 領収書の記載内容
+type Document struct {
+	Number             string
+	IssuedAt           time.Time
+	StoreName          string
+	RegistrationNumber string
+	Recipient          string // 宛名（任意）
+	Note               string // テーブル番号など
+	Duplicate          bool   // 再発行の場合true
+	PriceIncludesTax   bool
+	Lines              []Line
+	Taxes              []billing.RateTotal // 税率ごとの対象額と消費税額
+	Subtotal           int
+	Tax                int
+	Total              int
+}
+
+// Title 表題（再発行の場合はその旨を付ける）
+func (d Document) Title() string {
+	if d.Duplicate {
+		return "領収書（再発行）"
+	}
+	return "領収書"
+}
+
+// HasReduced 軽減税率の対象商品を含むか
+func (d Document) HasReduced() bool {
+	for _, line := range d.Lines {
+		if line.Reduced() {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatNumber 領収書番号を表示用にする
+func FormatNumber(n uint) string {
+	return fmt.Sprintf("%06d", n)
+}
+
+// Yen 金額を「￥1,234」の形式にする
+func Yen(amount int) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := fmt.Sprintf("%d", amount)
+	var b strings.Builder
+	for i, r := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+	return sign + "￥" + b.String()
+}
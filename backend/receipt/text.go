@@ -0,0 +1,102 @@
+package receipt
+
+import (
+	"fmt"
+	"orderbase/billing"
+	"strings"
+)
+
+// 1行の桁数（半角換算）
+const textWidth = 40
+
+// displayWidth 半角を1、全角を2として文字列の表示幅を返す
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r < 0x80 || (r >= 0xFF61 && r <= 0xFF9F) {
+			width++
+		} else {
+			width += 2
+		}
+	}
+	return width
+}
+
+// padBetween 左右の文字列の間を空白で埋めて1行にする
+func padBetween(left, right string) string {
+	space := textWidth - displayWidth(left) - displayWidth(right)
+	if space < 1 {
+		space = 1
+	}
+	return left + strings.Repeat(" ", space) + right
+}
+
+// center 文字列を中央寄せにする
+func center(s string) string {
+	space := (textWidth - displayWidth(s)) / 2
+	if space < 0 {
+		space = 0
+	}
+	return strings.Repeat(" ", space) + s
+}
+
+// TextLines 領収書を等幅の行に整形する（テキスト・PDF出力で共通）
+func TextLines(d Document) []string {
+	rule := strings.Repeat("-", textWidth)
+
+	lines := []string{center(d.Title()), ""}
+	if d.Recipient != "" {
+		lines = append(lines, d.Recipient+" 様", "")
+	}
+	lines = append(lines,
+		d.StoreName,
+		"登録番号: "+d.RegistrationNumber,
+		"発行日時: "+d.IssuedAt.Format("2006/01/02 15:04"),
+		"No. "+d.Number,
+	)
+	if d.Note != "" {
+		lines = append(lines, d.Note)
+	}
+	lines = append(lines, rule)
+
+	for _, line := range d.Lines {
+		name := line.Name
+		if line.Reduced() {
+			name += " ※"
+		}
+		lines = append(lines,
+			name,
+			padBetween(fmt.Sprintf("  %s x %d", Yen(line.UnitPrice), line.Quantity), Yen(line.Amount)),
+		)
+	}
+	lines = append(lines, rule)
+
+	for _, t := range d.Taxes {
+		if d.PriceIncludesTax {
+			lines = append(lines,
+				padBetween(fmt.Sprintf("%d%%対象", t.Rate), Yen(t.Total)),
+				padBetween("  内消費税", Yen(t.Tax)),
+			)
+		} else {
+			lines = append(lines,
+				padBetween(fmt.Sprintf("%d%%対象（税抜）", t.Rate), Yen(t.Subtotal)),
+				padBetween("  消費税", Yen(t.Tax)),
+			)
+		}
+	}
+	lines = append(lines,
+		padBetween("合計", Yen(d.Total)),
+		padBetween("  うち消費税", Yen(d.Tax)),
+		rule,
+	)
+	if d.HasReduced() {
+		lines = append(lines, fmt.Sprintf("※は軽減税率（%d%%）対象商品です", billing.ReducedTaxRate))
+	}
+	lines = append(lines, "上記正に領収いたしました")
+	return lines
+}
+
+// Text 領収書をプレーンテキストで出力
+func Text(d Document) string {
+	return strings.Join(TextLines(d), "\n") + "\n"
+}
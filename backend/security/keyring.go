@@ -0,0 +1,187 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 暗号化した値の先頭に付ける文字列（"enc:v<鍵のバージョン>:<nonce+暗号文のBase64>"）
+const encryptedPrefix = "enc:v"
+
+// 鍵の長さ（AES-256）
+const keyringKeySize = 32
+
+var (
+	ErrUnknownKeyVersion = errors.New("暗号化に使われた鍵が設定されていません")
+	ErrDecrypt           = errors.New("暗号化された値を復号できません")
+)
+
+// Keyring DBに保存する秘密情報（OpenAI APIキーなど）をAES-GCMで暗号化する鍵のセット
+// 鍵にはバージョンを付け、新しい値は現在のバージョンで暗号化する。古いバージョンの鍵は復号と再暗号化のために残す
+type Keyring struct {
+	current int
+	keys    map[int]cipher.AEAD
+}
+
+// NewKeyring バージョンごとの鍵（32バイト）から Keyring を作成
+func NewKeyring(current int, keys map[int][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("現在の鍵のバージョン %d が設定されていません", current)
+	}
+	k := &Keyring{current: current, keys: make(map[int]cipher.AEAD)}
+	for version, key := range keys {
+		if version <= 0 {
+			return nil, fmt.Errorf("鍵のバージョンは1以上にしてください: %d", version)
+		}
+		if len(key) != keyringKeySize {
+			return nil, fmt.Errorf("鍵のバージョン %d の長さが%dバイトではありません", version, keyringKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[version] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring "1:<Base64の鍵>,2:<Base64の鍵>" 形式の設定から Keyring を作成
+// current が0の場合は最も新しいバージョンを現在の鍵にする
+func ParseKeyring(spec string, current int) (*Keyring, error) {
+	keys := make(map[int][]byte)
+	latest := 0
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		versionText, encoded, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("鍵の設定は「バージョン:Base64の鍵」の形式にしてください")
+		}
+		version, err := strconv.Atoi(strings.TrimSpace(versionText))
+		if err != nil {
+			return nil, fmt.Errorf("鍵のバージョンが不正です: %s", versionText)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("鍵のバージョン %d がBase64ではありません", version)
+		}
+		keys[version] = key
+		if version > latest {
+			latest = version
+		}
+	}
+	if current == 0 {
+		current = latest
+	}
+	return NewKeyring(current, keys)
+}
+
+// RandomKeyringKey ランダムな鍵を生成（Base64）
+func RandomKeyringKey() (string, error) {
+	key := make([]byte, keyringKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// CurrentVersion 新しく暗号化する値に使う鍵のバージョン
+func (k *Keyring) CurrentVersion() int {
+	return k.current
+}
+
+// IsEncrypted 値が暗号化されているか（移行前の平文は false）
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypt 値を現在の鍵で暗号化（空文字は空文字のまま）
+// context には値の保存先（テーブル・列・ID）を渡し、別の行に値をコピーしても復号できないようにする
+func (k *Keyring) Encrypt(plaintext, context string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return encryptedPrefix + strconv.Itoa(k.current) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 暗号化された値を復号（空文字は空文字のまま）
+func (k *Keyring) Decrypt(value, context string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	version, sealed, err := parseEncrypted(value)
+	if err != nil {
+		return "", err
+	}
+	aead, ok := k.keys[version]
+	if !ok {
+		return "", ErrUnknownKeyVersion
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plaintext), nil
+}
+
+// NeedsReencrypt 平文のまま、または現在とは別のバージョンの鍵で暗号化されている値か
+func (k *Keyring) NeedsReencrypt(value string) bool {
+	if value == "" {
+		return false
+	}
+	version, _, err := parseEncrypted(value)
+	return err != nil || version != k.current
+}
+
+// Reencrypt 平文・古い鍵で暗号化された値を現在の鍵で暗号化し直す
+func (k *Keyring) Reencrypt(value, context string) (string, error) {
+	plaintext := value
+	if IsEncrypted(value) {
+		var err error
+		if plaintext, err = k.Decrypt(value, context); err != nil {
+			return "", err
+		}
+	}
+	return k.Encrypt(plaintext, context)
+}
+
+// parseEncrypted 暗号化された値から鍵のバージョンとnonce+暗号文を取り出す
+func parseEncrypted(value string) (int, []byte, error) {
+	if !IsEncrypted(value) {
+		return 0, nil, ErrDecrypt
+	}
+	versionText, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return 0, nil, ErrDecrypt
+	}
+	version, err := strconv.Atoi(versionText)
+	if err != nil {
+		return 0, nil, ErrDecrypt
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, ErrDecrypt
+	}
+	return version, sealed, nil
+}
@@ -0,0 +1,105 @@
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+// LimiterConfig ログイン試行の制限の設定
+type LimiterConfig struct {
+	FreeAttempts int           // 待ち時間なしで失敗できる回数
+	BaseDelay    time.Duration // 最初の待ち時間（以降は失敗ごとに2倍にする）
+	MaxDelay     time.Duration // 待ち時間の上限
+	ResetAfter   time.Duration // 最後の失敗からこの時間が経つと失敗回数をリセット
+}
+
+// 記録を掃除するキーの数の目安（失敗が続く間のメモリ使用量を抑える）
+const limiterSweepSize = 10000
+
+// Limiter キー（IPアドレス・ユーザー名など）ごとにログインの連続失敗を数え、指数的に待ち時間を延ばす
+// プロセス内でのみ保持する（再起動するとリセットされる）
+type Limiter struct {
+	mu      sync.Mutex
+	config  LimiterConfig
+	entries map[string]*limiterEntry
+}
+
+type limiterEntry struct {
+	failures int
+	lastFail time.Time
+	until    time.Time // この時刻まで試行を受け付けない
+}
+
+// NewLimiter Limiterを作成
+func NewLimiter(config LimiterConfig) *Limiter {
+	return &Limiter{
+		config:  config,
+		entries: make(map[string]*limiterEntry),
+	}
+}
+
+// Wait 次の試行まで待つ必要がある時間（待たずに試行できる場合は0）
+func (l *Limiter) Wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[key]
+	if !ok || now.After(entry.until) {
+		return 0
+	}
+	return entry.until.Sub(now)
+}
+
+// Fail 失敗を記録し、次の試行までの待ち時間を返す
+func (l *Limiter) Fail(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) >= limiterSweepSize {
+		l.sweep(now)
+	}
+
+	entry, ok := l.entries[key]
+	if !ok || now.Sub(entry.lastFail) > l.config.ResetAfter {
+		entry = &limiterEntry{}
+		l.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFail = now
+
+	delay := l.delay(entry.failures)
+	entry.until = now.Add(delay)
+	return delay
+}
+
+// Reset 失敗の記録を消す（ログインに成功した場合）
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// delay 連続失敗回数に応じた待ち時間
+func (l *Limiter) delay(failures int) time.Duration {
+	over := failures - l.config.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	delay := l.config.BaseDelay
+	for i := 1; i < over && delay < l.config.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.config.MaxDelay {
+		delay = l.config.MaxDelay
+	}
+	return delay
+}
+
+// sweep リセット済みの扱いになった記録を削除
+func (l *Limiter) sweep(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.lastFail) > l.config.ResetAfter {
+			delete(l.entries, key)
+		}
+	}
+}
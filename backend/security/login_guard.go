@@ -0,0 +1,60 @@
+package security
+
+import (
+	"strings"
+	"time"
+)
+
+// LoginGuard ログインの試行制限（IPアドレスごと・アカウントごとの待ち時間と、アカウントの一時ロック）
+type LoginGuard struct {
+	IP      *Limiter // 同じIPアドレスからの試行（多数のアカウントへの総当たり対策）
+	Account *Limiter // 同じアカウントへの試行（複数のIPアドレスからの総当たり対策）
+
+	LockoutThreshold int           // アカウントを一時ロックする連続失敗回数
+	LockoutDuration  time.Duration // 一時ロックの時間
+}
+
+// DefaultLoginGuard 既定の設定で LoginGuard を作成
+func DefaultLoginGuard() *LoginGuard {
+	return &LoginGuard{
+		IP: NewLimiter(LimiterConfig{
+			FreeAttempts: 10,
+			BaseDelay:    time.Second,
+			MaxDelay:     5 * time.Minute,
+			ResetAfter:   time.Hour,
+		}),
+		Account: NewLimiter(LimiterConfig{
+			FreeAttempts: 3,
+			BaseDelay:    time.Second,
+			MaxDelay:     5 * time.Minute,
+			ResetAfter:   time.Hour,
+		}),
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+}
+
+// AccountKey ユーザー名からアカウントごとの制限のキーを作成（存在しないユーザー名も同じように制限する）
+func AccountKey(method, username string) string {
+	return method + ":" + strings.ToLower(strings.TrimSpace(username))
+}
+
+// Wait IPアドレス・アカウントのどちらかで待つ必要がある場合はその時間を返す
+func (g *LoginGuard) Wait(ip, accountKey string, now time.Time) time.Duration {
+	wait := g.IP.Wait(ip, now)
+	if accountWait := g.Account.Wait(accountKey, now); accountWait > wait {
+		wait = accountWait
+	}
+	return wait
+}
+
+// Fail ログインの失敗を記録
+func (g *LoginGuard) Fail(ip, accountKey string, now time.Time) {
+	g.IP.Fail(ip, now)
+	g.Account.Fail(accountKey, now)
+}
+
+// Succeed ログインの成功を記録（アカウントの失敗回数のみリセットし、IPアドレスの記録は時間経過で消える）
+func (g *LoginGuard) Succeed(accountKey string) {
+	g.Account.Reset(accountKey)
+}
@@ -0,0 +1,67 @@
+// Package security ログインの試行制限・パスワードポリシー・署名付きトークン・保存する秘密情報の暗号化
+package security
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy パスワードの条件
+type PasswordPolicy struct {
+	MinLength      int  `json:"min_length"`
+	RequireLetter  bool `json:"require_letter"`  // 英字を含む
+	RequireDigit   bool `json:"require_digit"`   // 数字を含む
+	RequireSymbol  bool `json:"require_symbol"`  // 記号を含む
+	ForbidUsername bool `json:"forbid_username"` // ユーザー名を含むパスワードを禁止
+}
+
+// DefaultPasswordPolicy 既定のパスワードポリシー（8文字以上、英字と数字を含む）
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      8,
+		RequireLetter:  true,
+		RequireDigit:   true,
+		ForbidUsername: true,
+	}
+}
+
+// パスワードの長さの上限（bcryptは72バイトを超える部分を無視する）
+const maxPasswordBytes = 72
+
+// Validate パスワードが条件を満たしているか確認（満たしていない場合は利用者向けのエラーを返す）
+func (p PasswordPolicy) Validate(password, username string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("パスワードは%d文字以上にしてください", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("パスワードは%dバイト以内にしてください", maxPasswordBytes)
+	}
+
+	var hasLetter, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireLetter && !hasLetter {
+		return errors.New("パスワードには英字を含めてください")
+	}
+	if p.RequireDigit && !hasDigit {
+		return errors.New("パスワードには数字を含めてください")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		return errors.New("パスワードには記号を含めてください")
+	}
+	if p.ForbidUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return errors.New("パスワードにユーザー名を含めることはできません")
+	}
+	return nil
+}
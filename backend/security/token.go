@@ -0,0 +1,81 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("不正なトークンです")
+	ErrExpiredToken = errors.New("トークンの有効期限が切れています")
+)
+
+// トークンの用途（用途の違うトークンは検証に失敗する）
+const (
+	PurposePasswordReset = "password-reset"
+	PurposeEmailVerify   = "email-verify"
+)
+
+// TokenSigner メールで送る署名付きの期限付きトークンを作成・検証する（DBには保存しない）
+// 署名にはユーザーの状態（パスワードのハッシュ・メールアドレスなど）を含め、状態が変わるとトークンを無効にする
+type TokenSigner struct {
+	key []byte
+}
+
+// NewTokenSigner 署名鍵から TokenSigner を作成
+func NewTokenSigner(key []byte) *TokenSigner {
+	return &TokenSigner{key: key}
+}
+
+var tokenEncoding = base64.RawURLEncoding
+
+// Sign ユーザーIDと有効期限を含むトークンを作成
+func (s *TokenSigner) Sign(purpose string, userID uint, fingerprint string, expiresAt time.Time) string {
+	payload := tokenEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", userID, expiresAt.Unix())))
+	return payload + "." + tokenEncoding.EncodeToString(s.mac(purpose, payload, fingerprint))
+}
+
+// Verify トークンを検証してユーザーIDを返す
+// fingerprint にはトークンのユーザーの現在の状態を返す関数を渡す（ユーザーが存在しない場合はエラーを返す）
+func (s *TokenSigner) Verify(purpose, token string, now time.Time, fingerprint func(userID uint) (string, error)) (uint, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidToken
+	}
+	raw, err := tokenEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	sig, err := tokenEncoding.DecodeString(signature)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	var userID uint
+	var expiresAt int64
+	if _, err := fmt.Sscanf(string(raw), "%d.%d", &userID, &expiresAt); err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	current, err := fingerprint(userID)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+	if !hmac.Equal(sig, s.mac(purpose, payload, current)) {
+		return 0, ErrInvalidToken
+	}
+	if now.Unix() > expiresAt {
+		return 0, ErrExpiredToken
+	}
+	return userID, nil
+}
+
+func (s *TokenSigner) mac(purpose, payload, fingerprint string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(purpose + "\x00" + payload + "\x00" + fingerprint))
+	return mac.Sum(nil)
+}
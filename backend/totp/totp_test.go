@@ -0,0 +1,71 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret RFC 6238 付録B のテスト用シークレット（SHA1、"12345678901234567890" のBase32）
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	// RFC 6238 付録B の8桁の値の下6桁
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	codeAt := func(step int64) string {
+		code, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name      string
+		code      string
+		afterStep int64
+		wantStep  int64
+		wantOK    bool
+	}{
+		{"現在のステップ", codeAt(current), 0, current, true},
+		{"1つ前のステップ", codeAt(current - 1), 0, current - 1, true},
+		{"1つ後のステップ", codeAt(current + 1), 0, current + 1, true},
+		{"2つ前のステップ", codeAt(current - 2), 0, 0, false},
+		{"2つ後のステップ", codeAt(current + 2), 0, 0, false},
+		{"使用済みのステップ", codeAt(current), current, 0, false},
+		{"使用済みより後のステップ", codeAt(current + 1), current, current + 1, true},
+		{"前後の空白", " " + codeAt(current) + " ", 0, current, true},
+		{"桁数が違う", codeAt(current)[:Digits-1], 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Verify(rfcSecret, tt.code, now, tt.afterStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("Verify = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}